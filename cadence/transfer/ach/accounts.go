@@ -0,0 +1,102 @@
+package ach
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	AccountChecking = "checking"
+	AccountSavings  = "savings"
+)
+
+// BankAccount is where a customer's bank credits go. Accounts at banks
+// outside the US have an IBAN and BIC instead of routing and account
+// numbers, and are paid with a pain.001 rather than in an ACH file.
+type BankAccount struct {
+	RoutingNumber string `json:"routing_number,omitempty"`
+	AccountNumber string `json:"account_number,omitempty"`
+	Name          string `json:"name"`
+	Type          string `json:"type,omitempty"`
+	IBAN          string `json:"iban,omitempty"`
+	BIC           string `json:"bic,omitempty"`
+}
+
+// Foreign tells whether the account is outside the US.
+func (a BankAccount) Foreign() bool {
+	return a.IBAN != ""
+}
+
+func (a BankAccount) validate() error {
+	// The pain.001 the account is paid with checks the IBAN and BIC.
+	if a.Foreign() {
+		if a.BIC == "" || a.Name == "" {
+			return fmt.Errorf("accounts with an IBAN need a BIC and a name")
+		}
+
+		return nil
+	}
+
+	if !ValidRoutingNumber(a.RoutingNumber) {
+		return fmt.Errorf("invalid routing number %q", a.RoutingNumber)
+	}
+
+	if a.AccountNumber == "" || len(a.AccountNumber) > 17 {
+		return fmt.Errorf("invalid account number")
+	}
+
+	if a.Type != AccountChecking && a.Type != AccountSavings {
+		return fmt.Errorf("invalid account type %q", a.Type)
+	}
+
+	return nil
+}
+
+type BankAccounts interface {
+	BankAccount(ctx context.Context, accID string) (*BankAccount, error)
+	SaveBankAccount(ctx context.Context, accID string, account BankAccount) error
+}
+
+type bankAccountsImpl struct {
+	redis redis.RedisConnection
+}
+
+func NewBankAccounts(redis redis.RedisConnection) BankAccounts {
+	return &bankAccountsImpl{redis: redis}
+}
+
+func (b *bankAccountsImpl) BankAccount(ctx context.Context, accID string) (*BankAccount, error) {
+	result, err := b.redis.GetConn().Get(ctx, bankAccountKey(accID)).Result()
+	if b.redis.NoKeyError(err) {
+		return nil, fmt.Errorf("account %s has no bank account", accID)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var account BankAccount
+	err = json.Unmarshal([]byte(result), &account)
+
+	return &account, err
+}
+
+func (b *bankAccountsImpl) SaveBankAccount(ctx context.Context, accID string, account BankAccount) error {
+	err := account.validate()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+
+	return b.redis.GetConn().Set(ctx, bankAccountKey(accID), data, 0).Err()
+}
+
+func bankAccountKey(accID string) string {
+	return fmt.Sprintf("bank_account_%s", accID)
+}
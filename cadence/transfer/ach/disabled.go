@@ -0,0 +1,42 @@
+package ach
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDisabled is returned by an originator of a worker that was not given
+// the routing numbers of our banks.
+var ErrDisabled = errors.New("ach credits are disabled: ach_odfi and ach_destination are not set")
+
+type disabledOriginator struct{}
+
+// NewDisabledOriginator refuses every credit with ErrDisabled, so a worker
+// without routing numbers fails only the transfers paid over ACH.
+func NewDisabledOriginator() Originator {
+	return disabledOriginator{}
+}
+
+func (disabledOriginator) QueueCredit(ctx context.Context, req CreditRequest) error {
+	return ErrDisabled
+}
+
+func (disabledOriginator) Flush(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func (disabledOriginator) ProcessReturnFile(ctx context.Context, path string) error {
+	return ErrDisabled
+}
+
+func (disabledOriginator) Credit(ctx context.Context, executionID string) (*CreditRecord, error) {
+	return nil, ErrDisabled
+}
+
+func (disabledOriginator) FileCredits(ctx context.Context, file string) ([]string, error) {
+	return nil, ErrDisabled
+}
+
+func (disabledOriginator) Start() {}
+
+func (disabledOriginator) Stop() {}
@@ -0,0 +1,224 @@
+package ach
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	recordSize     = 94
+	blockingFactor = 10
+
+	serviceClassCredits = "220"
+	secPPD              = "PPD"
+
+	TransactionCheckingCredit = "22"
+	TransactionSavingsCredit  = "32"
+)
+
+// Config identifies us as originator in the files we write.
+type Config struct {
+	// ImmediateDestination is the routing number of the bank receiving the
+	// file, ImmediateOrigin ours.
+	ImmediateDestination     string
+	ImmediateOrigin          string
+	ImmediateDestinationName string
+	ImmediateOriginName      string
+	CompanyName              string
+	CompanyID                string
+	EntryDescription         string
+	// ODFI is the 8 digit routing prefix of the originating bank, also
+	// the start of every trace number.
+	ODFI string
+}
+
+// Entry is one credit in a file. TraceNumber is assigned when the file is
+// built.
+type Entry struct {
+	ExecutionID     string
+	TransactionCode string
+	RoutingNumber   string
+	AccountNumber   string
+	AmountCents     int64
+	IndividualID    string
+	IndividualName  string
+	TraceNumber     string
+}
+
+// ValidRoutingNumber checks the length and the ABA check digit.
+func ValidRoutingNumber(routing string) bool {
+	if len(routing) != 9 {
+		return false
+	}
+
+	for _, c := range routing {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return checkDigit(routing[:8]) == routing[8]
+}
+
+func checkDigit(prefix string) byte {
+	weights := []int{3, 7, 1, 3, 7, 1, 3, 7}
+
+	sum := 0
+	for i, c := range prefix {
+		sum += int(c-'0') * weights[i]
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
+
+// BuildFile writes a NACHA file with a single PPD credit batch holding
+// entries. Trace numbers are the ODFI followed by firstSequence onwards,
+// and are set on the returned entries.
+func BuildFile(config Config, entries []Entry, fileIDModifier byte, firstSequence int64, now time.Time) ([]byte, []Entry, error) {
+	if len(entries) == 0 {
+		return nil, nil, fmt.Errorf("ach file needs at least one entry")
+	}
+
+	if len(config.ODFI) != 8 {
+		return nil, nil, fmt.Errorf("odfi must have 8 digits, got %q", config.ODFI)
+	}
+
+	var buf bytes.Buffer
+	records := 0
+
+	write := func(record string) error {
+		if len(record) != recordSize {
+			return fmt.Errorf("ach record has %d characters: %q", len(record), record)
+		}
+
+		buf.WriteString(record)
+		buf.WriteString("\n")
+		records++
+
+		return nil
+	}
+
+	const batchNumber = 1
+
+	err := write("1" + "01" +
+		right(" "+config.ImmediateDestination, 10) +
+		right(" "+config.ImmediateOrigin, 10) +
+		now.Format("060102") + now.Format("1504") +
+		string(fileIDModifier) + "094" + "10" + "1" +
+		left(config.ImmediateDestinationName, 23) +
+		left(config.ImmediateOriginName, 23) +
+		left("", 8))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = write("5" + serviceClassCredits +
+		left(config.CompanyName, 16) +
+		left("", 20) +
+		left(config.CompanyID, 10) +
+		secPPD +
+		left(config.EntryDescription, 10) +
+		now.Format("060102") +
+		now.Format("060102") +
+		"   " + "1" +
+		config.ODFI +
+		number(batchNumber, 7))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var hash, credit int64
+	traced := make([]Entry, 0, len(entries))
+
+	for i, entry := range entries {
+		if !ValidRoutingNumber(entry.RoutingNumber) {
+			return nil, nil, fmt.Errorf("transfer %s: invalid routing number %q", entry.ExecutionID, entry.RoutingNumber)
+		}
+
+		if entry.AmountCents <= 0 {
+			return nil, nil, fmt.Errorf("transfer %s: amount must be positive", entry.ExecutionID)
+		}
+
+		entry.TraceNumber = config.ODFI + number((firstSequence+int64(i))%10000000, 7)
+
+		err = write("6" + entry.TransactionCode +
+			entry.RoutingNumber +
+			left(entry.AccountNumber, 17) +
+			number(entry.AmountCents, 10) +
+			left(entry.IndividualID, 15) +
+			left(entry.IndividualName, 22) +
+			"  " + "0" +
+			entry.TraceNumber)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rdfi, _ := strconv.ParseInt(entry.RoutingNumber[:8], 10, 64)
+
+		hash += rdfi
+		credit += entry.AmountCents
+		traced = append(traced, entry)
+	}
+
+	// Only the rightmost 10 digits of the hash are kept.
+	hash %= 10000000000
+
+	err = write("8" + serviceClassCredits +
+		number(int64(len(entries)), 6) +
+		number(hash, 10) +
+		number(0, 12) +
+		number(credit, 12) +
+		left(config.CompanyID, 10) +
+		left("", 19) + left("", 6) +
+		config.ODFI +
+		number(batchNumber, 7))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blocks := (records + 1 + blockingFactor - 1) / blockingFactor
+
+	err = write("9" +
+		number(1, 6) +
+		number(int64(blocks), 6) +
+		number(int64(len(entries)), 8) +
+		number(hash, 10) +
+		number(0, 12) +
+		number(credit, 12) +
+		left("", 39))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for records%blockingFactor != 0 {
+		write(strings.Repeat("9", recordSize))
+	}
+
+	return buf.Bytes(), traced, nil
+}
+
+// left pads or cuts s to n characters, upper case, aligned left, as
+// alphanumeric NACHA fields are.
+func left(s string, n int) string {
+	s = strings.ToUpper(s)
+	if len(s) > n {
+		return s[:n]
+	}
+
+	return s + strings.Repeat(" ", n-len(s))
+}
+
+func right(s string, n int) string {
+	if len(s) > n {
+		return s[len(s)-n:]
+	}
+
+	return strings.Repeat(" ", n-len(s)) + s
+}
+
+func number(v int64, n int) string {
+	return fmt.Sprintf("%0*d", n, v)
+}
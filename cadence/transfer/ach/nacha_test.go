@@ -0,0 +1,202 @@
+package ach
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{
+	ImmediateDestination:     "021000021",
+	ImmediateOrigin:          "123456780",
+	ImmediateDestinationName: "JPMorgan Chase",
+	ImmediateOriginName:      "Avenue Securities",
+	CompanyName:              "Avenue Securities",
+	CompanyID:                "1234567890",
+	EntryDescription:         "Withdrawal",
+	ODFI:                     "12345678",
+}
+
+func testEntries() []Entry {
+	return []Entry{
+		{
+			ExecutionID:     "sd_to_bank_1",
+			TransactionCode: TransactionCheckingCredit,
+			RoutingNumber:   "021000021",
+			AccountNumber:   "000123456789",
+			AmountCents:     150000,
+			IndividualID:    "SB1",
+			IndividualName:  "Jane Doe",
+		},
+		{
+			ExecutionID:     "sd_to_bank_2",
+			TransactionCode: TransactionSavingsCredit,
+			RoutingNumber:   "011000015",
+			AccountNumber:   "9876543210",
+			AmountCents:     25050,
+			IndividualID:    "SB2",
+			IndividualName:  "John Roe",
+		},
+	}
+}
+
+func TestValidRoutingNumber(t *testing.T) {
+	for routing, want := range map[string]bool{
+		"021000021":  true,
+		"011000015":  true,
+		"021000022":  false,
+		"02100002":   false,
+		"0210000210": false,
+		"02100002a":  false,
+	} {
+		if got := ValidRoutingNumber(routing); got != want {
+			t.Errorf("ValidRoutingNumber(%q) = %v, want %v", routing, got, want)
+		}
+	}
+}
+
+func TestBuildFile(t *testing.T) {
+	now := time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)
+
+	data, traced, err := BuildFile(testConfig, testEntries(), 'A', 41, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	// Header, batch header, two entries, batch control and file control,
+	// padded to a block of ten.
+	if len(records) != blockingFactor {
+		t.Fatalf("%d records, want %d", len(records), blockingFactor)
+	}
+
+	for i, record := range records {
+		if len(record) != recordSize {
+			t.Errorf("record %d has %d characters: %q", i+1, len(record), record)
+		}
+	}
+
+	for i, record := range records[6:] {
+		if record != strings.Repeat("9", recordSize) {
+			t.Errorf("padding record %d is %q", i+7, record)
+		}
+	}
+
+	header := records[0]
+	if got := header[3:23]; got != " 021000021 123456780" {
+		t.Errorf("header routing %q", got)
+	}
+
+	if got := header[23:34]; got != "2610190830A" {
+		t.Errorf("header creation %q", got)
+	}
+
+	for i, want := range []string{"123456780000041", "123456780000042"} {
+		if traced[i].TraceNumber != want {
+			t.Errorf("entry %d trace number %q, want %q", i+1, traced[i].TraceNumber, want)
+		}
+
+		if got := records[2+i][79:94]; got != want {
+			t.Errorf("entry record %d trace number %q, want %q", i+1, got, want)
+		}
+	}
+
+	if got := records[2][29:39]; got != "0000150000" {
+		t.Errorf("entry amount %q", got)
+	}
+
+	// 02100002 + 01100001
+	const hash = "0003200003"
+
+	control := records[4]
+	if got := control[4:10]; got != "000002" {
+		t.Errorf("batch entry count %q", got)
+	}
+
+	if got := control[10:20]; got != hash {
+		t.Errorf("batch entry hash %q, want %q", got, hash)
+	}
+
+	if got := control[32:44]; got != "000000175050" {
+		t.Errorf("batch credit total %q", got)
+	}
+
+	file := records[5]
+	if got := file[1:13]; got != "000001000001" {
+		t.Errorf("file batch and block count %q", got)
+	}
+
+	if got := file[13:21]; got != "00000002" {
+		t.Errorf("file entry count %q", got)
+	}
+
+	if got := file[21:31]; got != hash {
+		t.Errorf("file entry hash %q, want %q", got, hash)
+	}
+
+	if got := file[43:55]; got != "000000175050" {
+		t.Errorf("file credit total %q", got)
+	}
+}
+
+func TestBuildFileBlocks(t *testing.T) {
+	entries := make([]Entry, 0, 6)
+	for len(entries) < 6 {
+		entries = append(entries, testEntries()...)
+	}
+
+	data, _, err := BuildFile(testConfig, entries, 'A', 1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ten records with the six entries, so the file ends without padding.
+	records := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(records) != blockingFactor {
+		t.Fatalf("%d records, want %d", len(records), blockingFactor)
+	}
+
+	if got := records[9][7:13]; got != "000001" {
+		t.Errorf("block count %q, want 000001", got)
+	}
+
+	entries = append(entries, testEntries()[0])
+
+	data, _, err = BuildFile(testConfig, entries, 'A', 1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(records) != 2*blockingFactor {
+		t.Fatalf("%d records, want %d", len(records), 2*blockingFactor)
+	}
+
+	if got := records[10][7:13]; got != "000002" {
+		t.Errorf("block count %q, want 000002", got)
+	}
+}
+
+func TestBuildFileRejectsEntries(t *testing.T) {
+	invalid := testEntries()[:1]
+	invalid[0].RoutingNumber = "021000022"
+
+	_, _, err := BuildFile(testConfig, invalid, 'A', 1, time.Now())
+	if err == nil {
+		t.Error("invalid routing number accepted")
+	}
+
+	empty := testEntries()[:1]
+	empty[0].AmountCents = 0
+
+	_, _, err = BuildFile(testConfig, empty, 'A', 1, time.Now())
+	if err == nil {
+		t.Error("zero amount accepted")
+	}
+
+	_, _, err = BuildFile(testConfig, nil, 'A', 1, time.Now())
+	if err == nil {
+		t.Error("file without entries accepted")
+	}
+}
@@ -0,0 +1,493 @@
+package ach
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	CreditQueued   = "queued"
+	CreditSent     = "sent"
+	CreditReturned = "returned"
+
+	pendingKey   = "ach_pending"
+	sequenceKey  = "ach_trace_sequence"
+	flushLockKey = "ach_flush_lock"
+
+	fileIDModifiers = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	maxSequence     = 10000000
+)
+
+var ErrFlushInProgress = errors.New("another ach flush is running")
+
+// CreditRequest is a transfer approved for the bank leg. AccID finds the
+// customer's bank account; LedgerAccountID is credited back if the bank
+// returns the entry.
+type CreditRequest struct {
+	ExecutionID     string
+	AccID           string
+	LedgerAccountID string
+	Amount          float64
+}
+
+// CreditRecord tracks a credit from the queue to the file it went out in
+// and, possibly, its return.
+type CreditRecord struct {
+	ExecutionID     string      `json:"execution_id"`
+	AccID           string      `json:"acc_id"`
+	LedgerAccountID string      `json:"ledger_account_id"`
+	AmountCents     int64       `json:"amount_cents"`
+	Account         BankAccount `json:"account"`
+	Status          string      `json:"status"`
+	File            string      `json:"file,omitempty"`
+	TraceNumber     string      `json:"trace_number,omitempty"`
+	ReturnCode      string      `json:"return_code,omitempty"`
+	QueuedAt        time.Time   `json:"queued_at"`
+	SentAt          time.Time   `json:"sent_at,omitempty"`
+	ReturnedAt      time.Time   `json:"returned_at,omitempty"`
+}
+
+type OriginatorConfig struct {
+	Config
+	OutboxDir  string
+	ReturnsDir string
+	// Interval is how often queued credits are written out and the returns
+	// directory is read.
+	Interval time.Duration
+}
+
+// ReturnHandler is told about each credit the bank returned, once its
+// money is back on the ledger.
+type ReturnHandler func(ctx context.Context, executionID, code string) error
+
+// Originator batches bank credits into NACHA files and reverses the ones
+// the bank returns.
+type Originator interface {
+	// QueueCredit adds a credit to the next file. Queuing the same
+	// execution twice is a no-op.
+	QueueCredit(ctx context.Context, req CreditRequest) error
+	// Flush writes every queued credit to a new file in the outbox and
+	// returns its path, or "" when nothing was queued.
+	Flush(ctx context.Context) (string, error)
+	// ProcessReturnFile reverses the credits returned in a file.
+	ProcessReturnFile(ctx context.Context, path string) error
+	Credit(ctx context.Context, executionID string) (*CreditRecord, error)
+	// FileCredits lists the executions that went out in a file.
+	FileCredits(ctx context.Context, file string) ([]string, error)
+	Start()
+	Stop()
+}
+
+type originatorImpl struct {
+	broker   broker.Broker
+	redis    redis.RedisConnection
+	accounts BankAccounts
+	config   OriginatorConfig
+	onReturn ReturnHandler
+	done     chan struct{}
+	logger   *zap.SugaredLogger
+}
+
+func NewOriginator(broker broker.Broker, redis redis.RedisConnection, accounts BankAccounts, config OriginatorConfig, onReturn ReturnHandler) Originator {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("ach")
+
+	if config.Interval <= 0 {
+		config.Interval = time.Minute
+	}
+
+	if config.EntryDescription == "" {
+		config.EntryDescription = "TRANSFER"
+	}
+
+	return &originatorImpl{
+		broker:   broker,
+		redis:    redis,
+		accounts: accounts,
+		config:   config,
+		onReturn: onReturn,
+		done:     make(chan struct{}),
+		logger:   logger.Sugar(),
+	}
+}
+
+func (o *originatorImpl) QueueCredit(ctx context.Context, req CreditRequest) error {
+	account, err := o.accounts.BankAccount(ctx, req.AccID)
+	if err != nil {
+		return err
+	}
+
+	err = account.validate()
+	if err != nil {
+		return fmt.Errorf("account %s: %v", req.AccID, err)
+	}
+
+	if account.Foreign() {
+		return fmt.Errorf("account %s is outside the US and can't be paid by ACH", req.AccID)
+	}
+
+	record := CreditRecord{
+		ExecutionID:     req.ExecutionID,
+		AccID:           req.AccID,
+		LedgerAccountID: req.LedgerAccountID,
+		AmountCents:     int64(math.Round(req.Amount * 100)),
+		Account:         *account,
+		Status:          CreditQueued,
+		QueuedAt:        time.Now().UTC(),
+	}
+
+	if record.AmountCents <= 0 {
+		return fmt.Errorf("credit %s: amount must be positive", req.ExecutionID)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	key := creditKey(req.ExecutionID)
+
+	return o.redis.GetConn().Watch(ctx, func(tx *goredis.Tx) error {
+		exists, err := tx.Exists(ctx, key).Result()
+		if err != nil || exists > 0 {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.Set(ctx, key, data, 0)
+			pipe.SAdd(ctx, pendingKey, req.ExecutionID)
+			return nil
+		})
+
+		if err == nil {
+			o.logger.Infow("Credit queued", "execution_id", req.ExecutionID, "amount_cents", record.AmountCents)
+		}
+
+		return err
+	}, key)
+}
+
+func (o *originatorImpl) Flush(ctx context.Context) (string, error) {
+	locked, err := o.redis.GetConn().SetNX(ctx, flushLockKey, "1", 5*time.Minute).Result()
+	if err != nil {
+		return "", err
+	}
+
+	if !locked {
+		return "", ErrFlushInProgress
+	}
+	defer o.redis.GetConn().Del(ctx, flushLockKey)
+
+	ids, err := o.redis.GetConn().SMembers(ctx, pendingKey).Result()
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+
+	sort.Strings(ids)
+
+	records := make([]*CreditRecord, 0, len(ids))
+	entries := make([]Entry, 0, len(ids))
+
+	for _, id := range ids {
+		record, err := o.Credit(ctx, id)
+		if err != nil {
+			return "", err
+		}
+
+		code := TransactionCheckingCredit
+		if record.Account.Type == AccountSavings {
+			code = TransactionSavingsCredit
+		}
+
+		records = append(records, record)
+		entries = append(entries, Entry{
+			ExecutionID:     id,
+			TransactionCode: code,
+			RoutingNumber:   record.Account.RoutingNumber,
+			AccountNumber:   record.Account.AccountNumber,
+			AmountCents:     record.AmountCents,
+			IndividualID:    record.AccID,
+			IndividualName:  record.Account.Name,
+		})
+	}
+
+	last, err := o.redis.GetConn().IncrBy(ctx, sequenceKey, int64(len(entries))).Result()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+
+	modifier, err := o.fileIDModifier(ctx, now)
+	if err != nil {
+		return "", err
+	}
+
+	data, traced, err := BuildFile(o.config.Config, entries, modifier, (last-int64(len(entries)))%maxSequence+1, now)
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s-%c.ach", now.Format("20060102-150405"), modifier)
+	path := filepath.Join(o.config.OutboxDir, name)
+
+	err = writeFile(path, data)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = o.redis.GetConn().TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, record := range records {
+			record.Status = CreditSent
+			record.File = name
+			record.TraceNumber = traced[i].TraceNumber
+			record.SentAt = now
+
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+
+			pipe.Set(ctx, creditKey(record.ExecutionID), data, 0)
+			pipe.Set(ctx, traceKey(record.TraceNumber), record.ExecutionID, 0)
+			pipe.SAdd(ctx, fileKey(name), record.ExecutionID)
+			pipe.SRem(ctx, pendingKey, record.ExecutionID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		// The file is out but not recorded; pull it back so the credits go
+		// out once, in the next file.
+		os.Remove(path)
+		return "", err
+	}
+
+	o.logger.Infow("ACH file written", "file", path, "entries", len(records))
+
+	return path, nil
+}
+
+// fileIDModifier tells apart the files written on the same day.
+func (o *originatorImpl) fileIDModifier(ctx context.Context, now time.Time) (byte, error) {
+	key := fmt.Sprintf("ach_file_count_%s", now.Format("20060102"))
+
+	count, err := o.redis.GetConn().Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	o.redis.GetConn().Expire(ctx, key, 48*time.Hour)
+
+	if count > int64(len(fileIDModifiers)) {
+		return 0, fmt.Errorf("more than %d ach files today", len(fileIDModifiers))
+	}
+
+	return fileIDModifiers[count-1], nil
+}
+
+func (o *originatorImpl) ProcessReturnFile(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	returns, err := ParseReturns(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	for _, ret := range returns {
+		err = o.reverse(ctx, ret)
+		if err != nil {
+			return err
+		}
+	}
+
+	o.logger.Infow("ACH return file processed", "file", path, "returns", len(returns))
+
+	return nil
+}
+
+// reverse gives the money of a returned credit back on the ledger, tells
+// the return handler and marks the credit returned. Returns seen before
+// are skipped, and MoneyBin posts the credit entry once, so a file can be
+// processed again.
+func (o *originatorImpl) reverse(ctx context.Context, ret Return) error {
+	id, err := o.redis.GetConn().Get(ctx, traceKey(ret.OriginalTraceNumber)).Result()
+	if o.redis.NoKeyError(err) {
+		o.logger.Warnw("Return for an unknown trace number", "trace", ret.OriginalTraceNumber, "code", ret.Code)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	record, err := o.Credit(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if record.Status == CreditReturned {
+		return nil
+	}
+
+	ctx = broker.WithCorrelationID(ctx, id)
+
+	err = broker.ProduceStruct(ctx, o.broker, &pb.AddEntry{
+		Amount:      float64(record.AmountCents) / 100,
+		AccId:       record.LedgerAccountID,
+		Kind:        pb.EntryKind_Credit,
+		ExecutionId: id,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = o.onReturn(ctx, id, ret.Code)
+	if err != nil {
+		return err
+	}
+
+	record.Status = CreditReturned
+	record.ReturnCode = ret.Code
+	record.ReturnedAt = time.Now().UTC()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	o.logger.Warnw("Credit returned", "execution_id", id, "code", ret.Code, "reason", ret.Reason())
+
+	return o.redis.GetConn().Set(ctx, creditKey(id), data, 0).Err()
+}
+
+func (o *originatorImpl) Credit(ctx context.Context, executionID string) (*CreditRecord, error) {
+	result, err := o.redis.GetConn().Get(ctx, creditKey(executionID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var record CreditRecord
+	err = json.Unmarshal([]byte(result), &record)
+
+	return &record, err
+}
+
+func (o *originatorImpl) FileCredits(ctx context.Context, file string) ([]string, error) {
+	return o.redis.GetConn().SMembers(ctx, fileKey(file)).Result()
+}
+
+func (o *originatorImpl) Start() {
+	go func() {
+		ticker := time.NewTicker(o.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-o.done:
+				return
+			case <-ticker.C:
+			}
+
+			ctx := context.Background()
+
+			_, err := o.Flush(ctx)
+			if err != nil && err != ErrFlushInProgress {
+				o.logger.Errorw("Error writing ACH file", "err", err)
+			}
+
+			o.processReturns(ctx)
+		}
+	}()
+}
+
+func (o *originatorImpl) Stop() {
+	close(o.done)
+}
+
+// processReturns handles every file in the returns directory and moves it
+// to processed/ once done.
+func (o *originatorImpl) processReturns(ctx context.Context) {
+	if o.config.ReturnsDir == "" {
+		return
+	}
+
+	files, err := ioutil.ReadDir(o.config.ReturnsDir)
+	if err != nil {
+		o.logger.Errorw("Error reading ACH returns", "dir", o.config.ReturnsDir, "err", err)
+		return
+	}
+
+	processed := filepath.Join(o.config.ReturnsDir, "processed")
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(o.config.ReturnsDir, file.Name())
+
+		err = o.ProcessReturnFile(ctx, path)
+		if err != nil {
+			o.logger.Errorw("Error processing ACH return file", "file", path, "err", err)
+			continue
+		}
+
+		err = os.MkdirAll(processed, 0755)
+		if err == nil {
+			err = os.Rename(path, filepath.Join(processed, file.Name()))
+		}
+
+		if err != nil {
+			o.logger.Errorw("Error moving ACH return file", "file", path, "err", err)
+		}
+	}
+}
+
+// writeFile writes through a temporary file so whoever picks up the outbox
+// never sees half a file.
+func writeFile(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func creditKey(executionID string) string {
+	return fmt.Sprintf("ach_credit_%s", executionID)
+}
+
+func traceKey(trace string) string {
+	return fmt.Sprintf("ach_trace_%s", trace)
+}
+
+func fileKey(file string) string {
+	return fmt.Sprintf("ach_file_%s", file)
+}
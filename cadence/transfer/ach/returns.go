@@ -0,0 +1,85 @@
+package ach
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReturnReasons names the return codes we expect to see on credits.
+var ReturnReasons = map[string]string{
+	"R01": "Insufficient funds",
+	"R02": "Account closed",
+	"R03": "No account or unable to locate account",
+	"R04": "Invalid account number",
+	"R06": "Returned per ODFI request",
+	"R16": "Account frozen",
+	"R20": "Non-transaction account",
+}
+
+// Return is an entry the receiving bank sent back, taken from a return
+// entry and its 99 addenda.
+type Return struct {
+	Code                string
+	OriginalTraceNumber string
+	AmountCents         int64
+	AccountNumber       string
+}
+
+func (r Return) Reason() string {
+	if reason, ok := ReturnReasons[r.Code]; ok {
+		return reason
+	}
+
+	return "Unknown return reason"
+}
+
+// ParseReturns reads the returns out of a NACHA return file. Records other
+// than entries and their return addenda are skipped.
+func ParseReturns(r io.Reader) ([]Return, error) {
+	var returns []Return
+	var entry *Return
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		record := strings.TrimRight(scanner.Text(), "\r")
+
+		if record == "" || strings.Trim(record, "9") == "" {
+			continue
+		}
+
+		if len(record) != recordSize {
+			return nil, fmt.Errorf("line %d: record has %d characters, want %d", line, len(record), recordSize)
+		}
+
+		switch {
+		case record[0] == '6':
+			amount, err := strconv.ParseInt(record[29:39], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid amount %q", line, record[29:39])
+			}
+
+			entry = &Return{
+				AmountCents:   amount,
+				AccountNumber: strings.TrimSpace(record[12:29]),
+			}
+
+		case strings.HasPrefix(record, "799"):
+			if entry == nil {
+				return nil, fmt.Errorf("line %d: return addenda without an entry", line)
+			}
+
+			entry.Code = record[3:6]
+			entry.OriginalTraceNumber = record[6:21]
+			returns = append(returns, *entry)
+			entry = nil
+		}
+	}
+
+	return returns, scanner.Err()
+}
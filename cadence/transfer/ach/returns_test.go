@@ -0,0 +1,58 @@
+package ach
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseReturns(t *testing.T) {
+	file, err := os.Open(filepath.Join("testdata", "return.ach"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	returns, err := ParseReturns(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Return{
+		{
+			Code:                "R01",
+			OriginalTraceNumber: "123456780000001",
+			AmountCents:         150000,
+			AccountNumber:       "000123456789",
+		},
+		{
+			Code:                "R03",
+			OriginalTraceNumber: "123456780000002",
+			AmountCents:         25050,
+			AccountNumber:       "9876543210",
+		},
+	}
+
+	if !reflect.DeepEqual(returns, want) {
+		t.Errorf("got %+v\nwant %+v", returns, want)
+	}
+
+	if got := returns[0].Reason(); got != "Insufficient funds" {
+		t.Errorf("reason %q", got)
+	}
+}
+
+func TestParseReturnsRejectsRecords(t *testing.T) {
+	for name, file := range map[string]string{
+		"short record":       "6" + strings.Repeat("0", 50),
+		"addenda alone":      "799R01" + strings.Repeat("0", recordSize-6),
+		"non numeric amount": "622" + strings.Repeat("0", 26) + "00000ABCDE" + strings.Repeat(" ", recordSize-39),
+	} {
+		_, err := ParseReturns(strings.NewReader(file))
+		if err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package apex
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type ErrorCode string
+
+const (
+	ErrorInvalidRequest ErrorCode = "invalid_request"
+	ErrorUnauthorized   ErrorCode = "unauthorized"
+	ErrorNotFound       ErrorCode = "not_found"
+	ErrorDuplicate      ErrorCode = "duplicate_request"
+	ErrorNotCancelable  ErrorCode = "not_cancelable"
+	ErrorRateLimited    ErrorCode = "rate_limited"
+	ErrorUnavailable    ErrorCode = "unavailable"
+	ErrorUnexpected     ErrorCode = "unexpected"
+)
+
+// Error is returned for every failure reported by Apex or on the way to it.
+type Error struct {
+	Code       ErrorCode
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("apex %s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// Retryable tells whether the same request can succeed if sent again later.
+func (e *Error) Retryable() bool {
+	return e.Code == ErrorRateLimited || e.Code == ErrorUnavailable
+}
+
+func IsRetryable(err error) bool {
+	apexErr, ok := err.(*Error)
+	return ok && apexErr.Retryable()
+}
+
+func IsCode(err error, code ErrorCode) bool {
+	apexErr, ok := err.(*Error)
+	return ok && apexErr.Code == code
+}
+
+// errorFromStatus maps an HTTP status to an error when the body carries no
+// code of its own.
+func errorFromStatus(status int, message string) *Error {
+	code := ErrorUnexpected
+
+	switch {
+	case status == http.StatusBadRequest || status == http.StatusUnprocessableEntity:
+		code = ErrorInvalidRequest
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		code = ErrorUnauthorized
+	case status == http.StatusNotFound:
+		code = ErrorNotFound
+	case status == http.StatusConflict:
+		code = ErrorDuplicate
+	case status == http.StatusTooManyRequests:
+		code = ErrorRateLimited
+	case status >= 500:
+		code = ErrorUnavailable
+	}
+
+	return &Error{Code: code, StatusCode: status, Message: message}
+}
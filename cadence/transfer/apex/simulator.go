@@ -0,0 +1,245 @@
+package apex
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Duration reads durations written as "1s" or "250ms" in the scenario file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Step is one status update the simulator sends, Delay after the previous
+// one, give or take Jitter.
+type Step struct {
+	Status string   `json:"status"`
+	Delay  Duration `json:"delay"`
+	Jitter Duration `json:"jitter"`
+}
+
+// Scenario is a scripted Apex behaviour. A scenario without steps never
+// answers, which is how timeouts are simulated. Duplicates sends every step
+// that many extra times.
+type Scenario struct {
+	Name       string  `json:"name"`
+	Weight     float64 `json:"weight"`
+	Steps      []Step  `json:"steps"`
+	Duplicates int     `json:"duplicates"`
+}
+
+type SimulatorConfig struct {
+	Seed      int64      `json:"seed"`
+	Scenarios []Scenario `json:"scenarios"`
+}
+
+// DefaultSimulatorConfig is mostly happy paths with a bit of everything
+// else.
+func DefaultSimulatorConfig() SimulatorConfig {
+	return SimulatorConfig{
+		Scenarios: []Scenario{
+			{Name: "concluded", Weight: 0.6, Steps: []Step{{Status: "COMPLETE", Delay: Duration(time.Second)}}},
+			{Name: "postponed", Weight: 0.2, Steps: []Step{
+				{Status: "POSTPONED", Delay: Duration(time.Second)},
+				{Status: "FUNDS_POSTED", Delay: Duration(5 * time.Second), Jitter: Duration(2 * time.Second)},
+			}},
+			{Name: "canceled", Weight: 0.1, Steps: []Step{{Status: "CANCELED", Delay: Duration(2 * time.Second)}}},
+			{Name: "timeout", Weight: 0.05},
+			{Name: "duplicate", Weight: 0.05, Duplicates: 2, Steps: []Step{{Status: "COMPLETE", Delay: Duration(time.Second)}}},
+		},
+	}
+}
+
+func LoadSimulatorConfig(path string) (SimulatorConfig, error) {
+	var config SimulatorConfig
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return config, err
+	}
+
+	return config, config.validate()
+}
+
+func (c SimulatorConfig) validate() error {
+	if len(c.Scenarios) == 0 {
+		return fmt.Errorf("simulator config has no scenarios")
+	}
+
+	for _, scenario := range c.Scenarios {
+		if scenario.Weight < 0 {
+			return fmt.Errorf("scenario %s has a negative weight", scenario.Name)
+		}
+
+		for _, step := range scenario.Steps {
+			if _, err := statusFromWire(step.Status); err != nil {
+				return fmt.Errorf("scenario %s: %v", scenario.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Simulator stands in for Apex on the broker: it consumes withdraw requests
+// and answers each with the status sequence of a randomly chosen scenario.
+type Simulator interface {
+	Start() error
+	Stop() error
+}
+
+type simulatorImpl struct {
+	broker     broker.Broker
+	config     SimulatorConfig
+	dispatcher broker.Dispatcher
+	mu         sync.Mutex
+	random     *rand.Rand
+	logger     *zap.SugaredLogger
+}
+
+func NewSimulator(b broker.Broker, config SimulatorConfig) (Simulator, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("apex_simulator")
+
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	s := &simulatorImpl{
+		broker: b,
+		config: config,
+		random: rand.New(rand.NewSource(seed)),
+		logger: logger.Sugar(),
+	}
+
+	s.dispatcher = broker.NewDispatcher(b, broker.DispatcherOptions{
+		Name:        "apex_simulator",
+		Concurrency: 4,
+		Middlewares: []broker.Middleware{
+			broker.RecoveryMiddleware(s.logger),
+			broker.LoggingMiddleware(s.logger),
+		},
+	})
+	s.dispatcher.MustRegister(s.handleWithdraw)
+
+	return s, nil
+}
+
+func (s *simulatorImpl) Start() error {
+	return s.dispatcher.Start()
+}
+
+func (s *simulatorImpl) Stop() error {
+	return s.dispatcher.Stop()
+}
+
+func (s *simulatorImpl) handleWithdraw(ctx context.Context, message *pb.ApexWithdrawMessage) error {
+	scenario := s.pick()
+
+	s.logger.Infow("Simulating withdraw", "execution_id", message.ExecutionId, "scenario", scenario.Name)
+
+	// Replies keep the request's envelope so they are caused by it, but
+	// must outlive the handler.
+	envelope, _ := broker.EnvelopeFromContext(ctx)
+	go s.play(broker.WithEnvelope(context.Background(), envelope), message, scenario)
+
+	return nil
+}
+
+func (s *simulatorImpl) play(ctx context.Context, message *pb.ApexWithdrawMessage, scenario Scenario) {
+	for _, step := range scenario.Steps {
+		time.Sleep(s.delay(step))
+
+		status, _ := statusFromWire(step.Status)
+		resp := &pb.ApexWithdrawResponse{
+			Amount:      message.Amount,
+			ApexAccId:   message.ApexAccId,
+			ExecutionId: message.ExecutionId,
+			Direction:   message.Direction,
+			Status:      status,
+		}
+
+		for i := 0; i <= scenario.Duplicates; i++ {
+			err := broker.ProduceStruct(ctx, s.broker, resp)
+			if err != nil {
+				s.logger.Errorw("Error publishing simulated response", "execution_id", message.ExecutionId, "err", err)
+			}
+		}
+	}
+}
+
+func (s *simulatorImpl) pick() Scenario {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0.0
+	for _, scenario := range s.config.Scenarios {
+		total += scenario.Weight
+	}
+
+	r := s.random.Float64() * total
+	for _, scenario := range s.config.Scenarios {
+		r -= scenario.Weight
+		if r < 0 {
+			return scenario
+		}
+	}
+
+	return s.config.Scenarios[len(s.config.Scenarios)-1]
+}
+
+func (s *simulatorImpl) delay(step Step) time.Duration {
+	d := time.Duration(step.Delay)
+	if step.Jitter <= 0 {
+		return d
+	}
+
+	s.mu.Lock()
+	jitter := time.Duration(s.random.Int63n(int64(2*step.Jitter))) - time.Duration(step.Jitter)
+	s.mu.Unlock()
+
+	if d+jitter < 0 {
+		return 0
+	}
+
+	return d + jitter
+}
@@ -0,0 +1,217 @@
+package apex
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// transitions are the legal moves between Apex statuses. A withdrawal may
+// start in any status, because the first update we see isn't always
+// REQUESTED. Concluded, Fundsposted and Canceled are final.
+var transitions = map[pb.ApexStatus][]pb.ApexStatus{
+	pb.ApexStatus_Requested: {pb.ApexStatus_Postponed, pb.ApexStatus_Concluded, pb.ApexStatus_Fundsposted, pb.ApexStatus_Canceled},
+	pb.ApexStatus_Postponed: {pb.ApexStatus_Concluded, pb.ApexStatus_Fundsposted, pb.ApexStatus_Canceled},
+}
+
+// stages order the statuses so an update from an earlier stage than the
+// current one can be told apart from a transition that is simply illegal.
+var stages = map[pb.ApexStatus]int{
+	pb.ApexStatus_Requested:   0,
+	pb.ApexStatus_Postponed:   1,
+	pb.ApexStatus_Concluded:   2,
+	pb.ApexStatus_Fundsposted: 2,
+	pb.ApexStatus_Canceled:    2,
+}
+
+var (
+	ErrDuplicateUpdate   = errors.New("apex status already applied")
+	ErrOutOfOrderUpdate  = errors.New("apex status arrived after a later one")
+	ErrInvalidTransition = errors.New("apex status transition not allowed")
+	// ErrHistoryChanged is returned by HistoryStore.Append when someone
+	// else appended first.
+	ErrHistoryChanged = errors.New("apex status history changed")
+)
+
+// TransitionError is returned for updates the state machine refuses. It
+// unwraps to ErrDuplicateUpdate, ErrOutOfOrderUpdate or ErrInvalidTransition.
+type TransitionError struct {
+	ExecutionID string
+	From        pb.ApexStatus
+	To          pb.ApexStatus
+	Err         error
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("apex %s: %s -> %s: %v", e.ExecutionID, e.From, e.To, e.Err)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return e.Err
+}
+
+// CanTransition tells whether a withdrawal in status from may move to to.
+func CanTransition(from, to pb.ApexStatus) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// StatusChange is one entry of a withdrawal's status history.
+type StatusChange struct {
+	Status    pb.ApexStatus `json:"status"`
+	MessageID string        `json:"message_id,omitempty"`
+	At        time.Time     `json:"at"`
+}
+
+// StatusEvent is the domain event for an applied status change. Initial is
+// set for the first status of a withdrawal, in which case From is
+// meaningless.
+type StatusEvent struct {
+	ExecutionID string
+	From        pb.ApexStatus
+	To          pb.ApexStatus
+	Initial     bool
+	Final       bool
+	Response    *pb.ApexWithdrawResponse
+	At          time.Time
+}
+
+type EventHandler func(ctx context.Context, event StatusEvent) error
+
+// HistoryStore keeps the status history of each withdrawal.
+type HistoryStore interface {
+	Load(ctx context.Context, executionID string) ([]StatusChange, error)
+	// Append adds change if the history still has n entries and returns
+	// ErrHistoryChanged otherwise.
+	Append(ctx context.Context, executionID string, n int, change StatusChange) error
+}
+
+type StateMachine interface {
+	// Apply validates an update against the withdrawal's history, emits
+	// its event and records it. Refused updates return a *TransitionError.
+	Apply(ctx context.Context, resp *pb.ApexWithdrawResponse) (StatusEvent, error)
+	// Status is the current status, false if nothing was applied yet.
+	Status(ctx context.Context, executionID string) (pb.ApexStatus, bool, error)
+	History(ctx context.Context, executionID string) ([]StatusChange, error)
+}
+
+type stateMachineImpl struct {
+	store    HistoryStore
+	handlers []EventHandler
+	logger   *zap.SugaredLogger
+}
+
+func NewStateMachine(store HistoryStore, handlers ...EventHandler) StateMachine {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("apex_state")
+
+	return &stateMachineImpl{
+		store:    store,
+		handlers: handlers,
+		logger:   logger.Sugar(),
+	}
+}
+
+func (m *stateMachineImpl) Apply(ctx context.Context, resp *pb.ApexWithdrawResponse) (StatusEvent, error) {
+	for {
+		history, err := m.store.Load(ctx, resp.ExecutionId)
+		if err != nil {
+			return StatusEvent{}, err
+		}
+
+		event, err := m.validate(resp, history)
+		if err != nil {
+			m.logger.Warnw("Apex update refused", "execution_id", resp.ExecutionId, "status", resp.Status, "err", err)
+			return event, err
+		}
+
+		// Handlers run before the change is recorded: if one fails the
+		// update is retried as new rather than swallowed as a duplicate.
+		// The price is that an event may be emitted twice.
+		for _, handler := range m.handlers {
+			err = handler(ctx, event)
+			if err != nil {
+				return event, err
+			}
+		}
+
+		change := StatusChange{Status: resp.Status, At: event.At}
+		if envelope, ok := broker.EnvelopeFromContext(ctx); ok {
+			change.MessageID = envelope.MessageID
+		}
+
+		err = m.store.Append(ctx, resp.ExecutionId, len(history), change)
+		if err == ErrHistoryChanged {
+			continue
+		}
+
+		if err != nil {
+			return event, err
+		}
+
+		m.logger.Infow("Apex status changed", "execution_id", resp.ExecutionId, "from", event.From, "to", event.To, "initial", event.Initial)
+
+		return event, nil
+	}
+}
+
+func (m *stateMachineImpl) validate(resp *pb.ApexWithdrawResponse, history []StatusChange) (StatusEvent, error) {
+	event := StatusEvent{
+		ExecutionID: resp.ExecutionId,
+		To:          resp.Status,
+		Initial:     len(history) == 0,
+		Final:       IsFinal(resp.Status),
+		Response:    resp,
+		At:          time.Now().UTC(),
+	}
+
+	if event.Initial {
+		return event, nil
+	}
+
+	event.From = history[len(history)-1].Status
+
+	var reason error
+	for _, change := range history {
+		if change.Status == resp.Status {
+			reason = ErrDuplicateUpdate
+		}
+	}
+
+	if reason == nil && stages[resp.Status] < stages[event.From] {
+		reason = ErrOutOfOrderUpdate
+	}
+
+	if reason == nil && !CanTransition(event.From, resp.Status) {
+		reason = ErrInvalidTransition
+	}
+
+	if reason != nil {
+		return event, &TransitionError{ExecutionID: resp.ExecutionId, From: event.From, To: resp.Status, Err: reason}
+	}
+
+	return event, nil
+}
+
+func (m *stateMachineImpl) Status(ctx context.Context, executionID string) (pb.ApexStatus, bool, error) {
+	history, err := m.store.Load(ctx, executionID)
+	if err != nil || len(history) == 0 {
+		return pb.ApexStatus_Requested, false, err
+	}
+
+	return history[len(history)-1].Status, true, nil
+}
+
+func (m *stateMachineImpl) History(ctx context.Context, executionID string) ([]StatusChange, error) {
+	return m.store.Load(ctx, executionID)
+}
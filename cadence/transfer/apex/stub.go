@@ -0,0 +1,131 @@
+package apex
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// stubServer mimics the Apex withdrawal API for local runs. A withdrawal is
+// REQUESTED when created and COMPLETE from the first status check on.
+type stubServer struct {
+	signer      Signer
+	mu          sync.Mutex
+	withdrawals map[string]*withdrawResponse
+}
+
+func NewStubHandler(signer Signer) http.Handler {
+	s := &stubServer{
+		signer:      signer,
+		withdrawals: map[string]*withdrawResponse{},
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/v1/withdrawals", s.verified(s.create)).Methods("POST")
+	router.Handle("/v1/withdrawals/{id}", s.verified(s.status)).Methods("GET")
+	router.Handle("/v1/withdrawals/{id}/cancel", s.verified(s.cancel)).Methods("POST")
+
+	return router
+}
+
+func (s *stubServer) verified(next func(w http.ResponseWriter, r *http.Request, body []byte)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrorInvalidRequest, err.Error())
+			return
+		}
+
+		err = s.signer.Verify(r, body)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, ErrorUnauthorized, err.Error())
+			return
+		}
+
+		next(w, r, body)
+	})
+}
+
+func (s *stubServer) create(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req withdrawRequest
+	err := json.Unmarshal(body, &req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	if _, err := fromWireRequest(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.withdrawals[req.RequestID]; ok {
+		writeError(w, http.StatusConflict, ErrorDuplicate, "request_id already used")
+		return
+	}
+
+	resp := &withdrawResponse{
+		RequestID: req.RequestID,
+		Account:   req.Account,
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+		Type:      req.Type,
+		Status:    "REQUESTED",
+	}
+	s.withdrawals[req.RequestID] = resp
+
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *stubServer) status(w http.ResponseWriter, r *http.Request, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp, ok := s.withdrawals[mux.Vars(r)["id"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrorNotFound, "withdrawal not found")
+		return
+	}
+
+	if resp.Status == "REQUESTED" {
+		resp.Status = "COMPLETE"
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *stubServer) cancel(w http.ResponseWriter, r *http.Request, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp, ok := s.withdrawals[mux.Vars(r)["id"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrorNotFound, "withdrawal not found")
+		return
+	}
+
+	if resp.Status != "REQUESTED" && resp.Status != "POSTPONED" {
+		writeError(w, http.StatusConflict, ErrorNotCancelable, "withdrawal is "+resp.Status)
+		return
+	}
+
+	resp.Status = "CANCELED"
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func writeError(w http.ResponseWriter, status int, code ErrorCode, message string) {
+	writeJSON(w, status, errorResponse{Code: string(code), Message: message})
+}
@@ -0,0 +1,29 @@
+package apex
+
+import (
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"encoding/json"
+)
+
+// ParseWebhook reads an Apex status callback, which carries the same body
+// as a withdrawal status response. Bodies without a request id are
+// rejected with ErrorInvalidRequest.
+func ParseWebhook(body []byte) (*pb.ApexWithdrawResponse, error) {
+	var wire withdrawResponse
+	err := json.Unmarshal(body, &wire)
+	if err != nil {
+		return nil, &Error{Code: ErrorInvalidRequest, Message: err.Error()}
+	}
+
+	if wire.RequestID == "" {
+		return nil, &Error{Code: ErrorInvalidRequest, Message: "request_id is required"}
+	}
+
+	return fromWireResponse(&wire)
+}
+
+// EncodeWebhook writes a status callback body as Apex sends it, which is
+// what ParseWebhook reads back.
+func EncodeWebhook(msg *pb.ApexWithdrawResponse) ([]byte, error) {
+	return json.Marshal(toWireResponse(msg))
+}
@@ -0,0 +1,148 @@
+package apex
+
+import (
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"fmt"
+	"strconv"
+)
+
+const currencyUSD = "USD"
+
+// Wire format of the Apex withdrawal API. Amounts travel as decimal strings
+// with two places, never as floats.
+type withdrawRequest struct {
+	RequestID string `json:"request_id"`
+	Account   string `json:"account"`
+	Amount    string `json:"amount"`
+	Currency  string `json:"currency"`
+	Type      string `json:"type"`
+}
+
+type withdrawResponse struct {
+	RequestID string `json:"request_id"`
+	Account   string `json:"account"`
+	Amount    string `json:"amount"`
+	Currency  string `json:"currency"`
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+}
+
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var wireStatuses = map[pb.ApexStatus]string{
+	pb.ApexStatus_Requested:   "REQUESTED",
+	pb.ApexStatus_Concluded:   "COMPLETE",
+	pb.ApexStatus_Postponed:   "POSTPONED",
+	pb.ApexStatus_Fundsposted: "FUNDS_POSTED",
+	pb.ApexStatus_Canceled:    "CANCELED",
+}
+
+var wireDirections = map[pb.Direction]string{
+	pb.Direction_SdToBank: "WITHDRAWAL",
+}
+
+func toWireRequest(msg *pb.ApexWithdrawMessage) (*withdrawRequest, error) {
+	kind, ok := wireDirections[msg.Direction]
+	if !ok {
+		return nil, &Error{Code: ErrorInvalidRequest, Message: fmt.Sprintf("unsupported direction %s", msg.Direction)}
+	}
+
+	return &withdrawRequest{
+		RequestID: msg.ExecutionId,
+		Account:   msg.ApexAccId,
+		Amount:    formatAmount(msg.Amount),
+		Currency:  currencyUSD,
+		Type:      kind,
+	}, nil
+}
+
+func fromWireRequest(req *withdrawRequest) (*pb.ApexWithdrawMessage, error) {
+	amount, err := parseAmount(req.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	direction, err := directionFromWire(req.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ApexWithdrawMessage{
+		Amount:      amount,
+		ApexAccId:   req.Account,
+		ExecutionId: req.RequestID,
+		Direction:   direction,
+	}, nil
+}
+
+func toWireResponse(msg *pb.ApexWithdrawResponse) *withdrawResponse {
+	return &withdrawResponse{
+		RequestID: msg.ExecutionId,
+		Account:   msg.ApexAccId,
+		Amount:    formatAmount(msg.Amount),
+		Currency:  currencyUSD,
+		Type:      wireDirections[msg.Direction],
+		Status:    wireStatuses[msg.Status],
+	}
+}
+
+func fromWireResponse(resp *withdrawResponse) (*pb.ApexWithdrawResponse, error) {
+	amount, err := parseAmount(resp.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	direction, err := directionFromWire(resp.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := statusFromWire(resp.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ApexWithdrawResponse{
+		Amount:      amount,
+		ApexAccId:   resp.Account,
+		ExecutionId: resp.RequestID,
+		Direction:   direction,
+		Status:      status,
+	}, nil
+}
+
+func statusFromWire(status string) (pb.ApexStatus, error) {
+	for k, v := range wireStatuses {
+		if v == status {
+			return k, nil
+		}
+	}
+
+	return 0, &Error{Code: ErrorUnexpected, Message: fmt.Sprintf("unknown status %q", status)}
+}
+
+func directionFromWire(kind string) (pb.Direction, error) {
+	for k, v := range wireDirections {
+		if v == kind {
+			return k, nil
+		}
+	}
+
+	return 0, &Error{Code: ErrorInvalidRequest, Message: fmt.Sprintf("unknown type %q", kind)}
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+func parseAmount(amount string) (float64, error) {
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return 0, &Error{Code: ErrorInvalidRequest, Message: fmt.Sprintf("invalid amount %q", amount)}
+	}
+
+	return value, nil
+}
@@ -1 +1,123 @@
 package apex
+
+import (
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type WithdrawClient interface {
+	RequestWithdraw(ctx context.Context, msg *pb.ApexWithdrawMessage) (*pb.ApexWithdrawResponse, error)
+	GetWithdrawStatus(ctx context.Context, executionID string) (*pb.ApexWithdrawResponse, error)
+	CancelWithdraw(ctx context.Context, executionID string) (*pb.ApexWithdrawResponse, error)
+}
+
+type Config struct {
+	BaseURL string
+	KeyID   string
+	Secret  string
+	Timeout time.Duration
+}
+
+type httpWithdrawClientImpl struct {
+	baseURL string
+	signer  Signer
+	http    *http.Client
+	logger  *zap.SugaredLogger
+}
+
+func NewHTTPWithdrawClient(config Config) WithdrawClient {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("apex_client")
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &httpWithdrawClientImpl{
+		baseURL: config.BaseURL,
+		signer:  Signer{KeyID: config.KeyID, Secret: []byte(config.Secret)},
+		http:    &http.Client{Timeout: timeout},
+		logger:  logger.Sugar(),
+	}
+}
+
+func (c *httpWithdrawClientImpl) RequestWithdraw(ctx context.Context, msg *pb.ApexWithdrawMessage) (*pb.ApexWithdrawResponse, error) {
+	req, err := toWireRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodPost, "/v1/withdrawals", req)
+}
+
+func (c *httpWithdrawClientImpl) GetWithdrawStatus(ctx context.Context, executionID string) (*pb.ApexWithdrawResponse, error) {
+	return c.do(ctx, http.MethodGet, "/v1/withdrawals/"+url.PathEscape(executionID), nil)
+}
+
+func (c *httpWithdrawClientImpl) CancelWithdraw(ctx context.Context, executionID string) (*pb.ApexWithdrawResponse, error) {
+	return c.do(ctx, http.MethodPost, "/v1/withdrawals/"+url.PathEscape(executionID)+"/cancel", nil)
+}
+
+func (c *httpWithdrawClientImpl) do(ctx context.Context, method, path string, payload interface{}) (*pb.ApexWithdrawResponse, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		body, err = json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	c.signer.Sign(req, body)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, &Error{Code: ErrorUnavailable, Message: err.Error()}
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, &Error{Code: ErrorUnavailable, StatusCode: res.StatusCode, Message: err.Error()}
+	}
+
+	if res.StatusCode >= 300 {
+		var apiErr errorResponse
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Code != "" {
+			return nil, &Error{Code: ErrorCode(apiErr.Code), StatusCode: res.StatusCode, Message: apiErr.Message}
+		}
+
+		return nil, errorFromStatus(res.StatusCode, string(data))
+	}
+
+	var wire withdrawResponse
+	err = json.Unmarshal(data, &wire)
+	if err != nil {
+		return nil, &Error{Code: ErrorUnexpected, StatusCode: res.StatusCode, Message: err.Error()}
+	}
+
+	c.logger.Infow("Apex call", "method", method, "path", path, "status", wire.Status)
+
+	return fromWireResponse(&wire)
+}
+
+// IsFinal tells whether Apex will send no further updates for a withdrawal.
+func IsFinal(status pb.ApexStatus) bool {
+	return status == pb.ApexStatus_Concluded || status == pb.ApexStatus_Fundsposted || status == pb.ApexStatus_Canceled
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// APIKey is a service calling the API with a key. Only the SHA-256 of the
+// key is kept, so the keys file holds no secrets.
+type APIKey struct {
+	Name     string   `json:"name"`
+	SHA256   string   `json:"sha256"`
+	Roles    []Role   `json:"roles"`
+	Accounts []string `json:"accounts"`
+}
+
+// APIKeys turns an API key into the service holding it.
+type APIKeys interface {
+	Verify(key string) (*Principal, error)
+}
+
+type apiKeysImpl struct {
+	keys map[string]APIKey
+}
+
+// LoadAPIKeys reads a JSON list of APIKey.
+func LoadAPIKeys(path string) (APIKeys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []APIKey
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, fmt.Errorf("api keys %s: %w", path, err)
+	}
+
+	return NewAPIKeys(list)
+}
+
+func NewAPIKeys(list []APIKey) (APIKeys, error) {
+	keys := &apiKeysImpl{keys: make(map[string]APIKey, len(list))}
+
+	for _, key := range list {
+		hash := strings.ToLower(key.SHA256)
+
+		if key.Name == "" || len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("api key %q needs a name and a sha256", key.Name)
+		}
+
+		if _, ok := keys.keys[hash]; ok {
+			return nil, fmt.Errorf("api key %s is a duplicate", key.Name)
+		}
+
+		keys.keys[hash] = key
+	}
+
+	return keys, nil
+}
+
+func (k *apiKeysImpl) Verify(key string) (*Principal, error) {
+	sum := sha256.Sum256([]byte(key))
+
+	found, ok := k.keys[hex.EncodeToString(sum[:])]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{Subject: found.Name, Roles: found.Roles, Accounts: found.Accounts}, nil
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Credentials are what a caller presented: a bearer token or an API key.
+type Credentials struct {
+	Bearer string
+	APIKey string
+}
+
+type Authenticator interface {
+	Authenticate(ctx context.Context, credentials Credentials) (*Principal, error)
+}
+
+type authenticatorImpl struct {
+	tokens TokenVerifier
+	keys   APIKeys
+}
+
+// NewAuthenticator checks bearer tokens with the verifier and API keys
+// against the keys. Either may be nil, turning that kind of credentials
+// down.
+func NewAuthenticator(tokens TokenVerifier, keys APIKeys) Authenticator {
+	return &authenticatorImpl{tokens: tokens, keys: keys}
+}
+
+func (a *authenticatorImpl) Authenticate(ctx context.Context, credentials Credentials) (*Principal, error) {
+	switch {
+	case credentials.APIKey != "" && a.keys != nil:
+		return a.keys.Verify(credentials.APIKey)
+	case credentials.Bearer != "" && a.tokens != nil:
+		return a.tokens.Verify(credentials.Bearer)
+	case credentials.APIKey != "" || credentials.Bearer != "":
+		return nil, ErrInvalidCredentials
+	}
+
+	return nil, ErrNoCredentials
+}
+
+type disabledImpl struct{}
+
+// Disabled lets every caller in as an admin. It is for local development
+// only.
+func Disabled() Authenticator {
+	return disabledImpl{}
+}
+
+func (disabledImpl) Authenticate(ctx context.Context, credentials Credentials) (*Principal, error) {
+	return &Principal{Subject: "anonymous", Roles: []Role{RoleAdmin}, Accounts: []string{AnyAccount}}, nil
+}
+
+// HTTPCredentials reads the Authorization and X-API-Key headers. Browsers
+// cannot set headers on an EventSource or a WebSocket, so a token may also
+// come in the access_token parameter.
+func HTTPCredentials(r *http.Request) Credentials {
+	credentials := Credentials{APIKey: r.Header.Get("X-API-Key")}
+
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		credentials.Bearer = strings.TrimSpace(header[7:])
+	}
+
+	if credentials.Bearer == "" {
+		credentials.Bearer = r.URL.Query().Get("access_token")
+	}
+
+	return credentials
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// defaultLeeway is the clock skew allowed on token times.
+const defaultLeeway = time.Minute
+
+// TokenVerifier turns a bearer token into the principal it was issued to.
+type TokenVerifier interface {
+	Verify(token string) (*Principal, error)
+}
+
+type JWTConfig struct {
+	// JWKSFile holds the public keys tokens are signed with, picked by
+	// the kid of each token.
+	JWKSFile string
+	// Key is a static HMAC key, used when there is no JWKS file.
+	Key []byte
+	// Issuer and Audience, when set, must be those of every token.
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+}
+
+// tokenClaims are the registered claims plus the roles of the caller and
+// the accounts it may act for.
+type tokenClaims struct {
+	jwt.Claims
+	Roles    []Role   `json:"roles"`
+	Accounts []string `json:"accounts"`
+}
+
+type jwtVerifierImpl struct {
+	keys   jose.JSONWebKeySet
+	config JWTConfig
+}
+
+func NewJWTVerifier(config JWTConfig) (TokenVerifier, error) {
+	if config.Leeway <= 0 {
+		config.Leeway = defaultLeeway
+	}
+
+	verifier := &jwtVerifierImpl{config: config}
+
+	switch {
+	case config.JWKSFile != "":
+		data, err := ioutil.ReadFile(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(data, &verifier.keys)
+		if err != nil {
+			return nil, fmt.Errorf("jwks %s: %w", config.JWKSFile, err)
+		}
+
+		if len(verifier.keys.Keys) == 0 {
+			return nil, fmt.Errorf("jwks %s has no keys", config.JWKSFile)
+		}
+
+		for _, key := range verifier.keys.Keys {
+			if !key.IsPublic() {
+				return nil, fmt.Errorf("jwks %s: key %s is not a public key", config.JWKSFile, key.KeyID)
+			}
+		}
+	case len(config.Key) > 0:
+		verifier.keys.Keys = []jose.JSONWebKey{{Key: config.Key}}
+	default:
+		return nil, fmt.Errorf("no jwks file nor key")
+	}
+
+	return verifier, nil
+}
+
+func (v *jwtVerifierImpl) Verify(token string) (*Principal, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil || len(parsed.Headers) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	header := parsed.Headers[0]
+
+	key, ok := v.key(header.KeyID)
+	if !ok || (key.Algorithm != "" && key.Algorithm != header.Algorithm) {
+		return nil, ErrInvalidCredentials
+	}
+
+	var claims tokenClaims
+	if parsed.Claims(key.Key, &claims) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	expected := jwt.Expected{Issuer: v.config.Issuer, Time: time.Now()}
+	if v.config.Audience != "" {
+		expected.Audience = jwt.Audience{v.config.Audience}
+	}
+
+	if claims.Expiry == nil || claims.Subject == "" || claims.ValidateWithLeeway(expected, v.config.Leeway) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{Subject: claims.Subject, Roles: claims.Roles, Accounts: claims.Accounts}, nil
+}
+
+// key picks the key a token was signed with. Tokens without a kid may
+// only be used with a single key.
+func (v *jwtVerifierImpl) key(kid string) (jose.JSONWebKey, bool) {
+	if kid == "" {
+		if len(v.keys.Keys) == 1 {
+			return v.keys.Keys[0], true
+		}
+
+		return jose.JSONWebKey{}, false
+	}
+
+	keys := v.keys.Key(kid)
+	if len(keys) == 0 {
+		return jose.JSONWebKey{}, false
+	}
+
+	return keys[0], true
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+)
+
+type Role string
+
+const (
+	// RoleAdmin may do anything, for any account.
+	RoleAdmin Role = "admin"
+	// RoleApprover approves and cancels transfers of any account.
+	RoleApprover Role = "approver"
+	// RoleSupport reads transfers, accounts and reports of any account.
+	RoleSupport Role = "support"
+)
+
+// AnyAccount in the accounts of a principal lets it act for every account.
+const AnyAccount = "*"
+
+// Principal is an authenticated caller: a user holding a token, or a
+// service holding an API key.
+type Principal struct {
+	Subject  string   `json:"sub"`
+	Roles    []Role   `json:"roles,omitempty"`
+	Accounts []string `json:"accounts,omitempty"`
+}
+
+// HasRole tells whether the principal has one of the roles. Admins have
+// every role.
+func (p *Principal) HasRole(roles ...Role) bool {
+	for _, has := range p.Roles {
+		if has == RoleAdmin {
+			return true
+		}
+
+		for _, role := range roles {
+			if has == role {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// CanAccess tells whether the principal may act for the account.
+func (p *Principal) CanAccess(accID string) bool {
+	for _, account := range p.Accounts {
+		if account == AnyAccount || (accID != "" && account == accID) {
+			return true
+		}
+	}
+
+	return p.HasRole(RoleAdmin)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
@@ -0,0 +1,75 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const ContentTypeProtobuf = "application/x-protobuf"
+
+// Message is what travels through a broker: a typed body plus its envelope.
+// Type is the fully qualified proto message name.
+type Message struct {
+	Type        string
+	ContentType string
+	Body        []byte
+	Envelope    Envelope
+}
+
+// Delivery is a message handed to a subscriber. It must be acked or nacked
+// exactly once.
+type Delivery interface {
+	Message() Message
+	Redelivered() bool
+	Ack() error
+	Nack(requeue bool) error
+}
+
+type SubscribeOptions struct {
+	// Name identifies the consumer. Subscriptions with the same name share
+	// a queue and compete for its messages; every name gets its own copy of
+	// each message. Backends with durable consumers use it to resume where
+	// the consumer left off.
+	Name string
+	// Prefetch is the maximum number of unacked deliveries the subscriber
+	// holds at once.
+	Prefetch int
+}
+
+type Subscription interface {
+	Deliveries() <-chan Delivery
+	// Close stops new deliveries and waits for the ones in flight to be
+	// acked or nacked.
+	Close() error
+}
+
+// Broker is the messaging backend the transfer services publish to and
+// consume from. Every message goes to all subscriber names, and each
+// consumer handles the types it registered.
+type Broker interface {
+	Publish(ctx context.Context, message Message) error
+	Subscribe(options SubscribeOptions) (Subscription, error)
+	Close() error
+}
+
+// Pinger is implemented by brokers that can tell whether they are still
+// connected to their backend.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ProduceStruct publishes a proto message, filling its envelope from ctx.
+func ProduceStruct(ctx context.Context, b Broker, message proto.Message) error {
+	body, err := proto.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return b.Publish(ctx, Message{
+		Type:        proto.MessageName(message),
+		ContentType: ContentTypeProtobuf,
+		Body:        body,
+		Envelope:    NewEnvelope(ctx, message),
+	})
+}
@@ -0,0 +1,300 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+)
+
+// HandlerFunc is the untyped form every registered handler is adapted to.
+// Middlewares wrap HandlerFuncs.
+type HandlerFunc func(ctx context.Context, message proto.Message) error
+
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// RetryError asks the dispatcher to put the message back after Delay
+// instead of failing it. Handlers return it for conditions that clear up
+// on their own, such as a message another consumer is still handling or a
+// provider that is down.
+type RetryError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func RetryLater(err error, delay time.Duration) error {
+	return &RetryError{Err: err, Delay: delay}
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("retry in %s: %v", e.Delay, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+type UnknownTypePolicy int
+
+const (
+	// UnknownTypeDrop acks and discards messages without a registered handler.
+	UnknownTypeDrop UnknownTypePolicy = iota
+	// UnknownTypeRequeue puts the message back so another consumer of the
+	// queue can pick it up.
+	UnknownTypeRequeue
+	// UnknownTypeReject rejects the message without requeue, sending it to
+	// the backend's dead letter destination when it has one.
+	UnknownTypeReject
+)
+
+type DispatcherOptions struct {
+	Name        string
+	Concurrency int
+	Prefetch    int
+	UnknownType UnknownTypePolicy
+	Middlewares []Middleware
+}
+
+type Dispatcher interface {
+	// Register adds a handler with the signature
+	// func(context.Context, *pb.X) error, where *pb.X is a proto message.
+	Register(handler interface{}) error
+	MustRegister(handler interface{})
+	Use(middlewares ...Middleware)
+	Start() error
+	Stop() error
+}
+
+type handlerEntry struct {
+	messageType reflect.Type
+	fn          reflect.Value
+}
+
+type dispatcherImpl struct {
+	broker       Broker
+	options      DispatcherOptions
+	handlers     map[string]handlerEntry
+	middlewares  []Middleware
+	subscription Subscription
+	wg           sync.WaitGroup
+	logger       *zap.SugaredLogger
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	messageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+func NewDispatcher(broker Broker, options DispatcherOptions) Dispatcher {
+	if options.Name == "" {
+		options.Name = "dispatcher"
+	}
+
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+
+	if options.Prefetch <= 0 {
+		options.Prefetch = options.Concurrency
+	}
+
+	logger, _ := zap.NewProduction()
+	logger = logger.Named(options.Name)
+
+	return &dispatcherImpl{
+		broker:      broker,
+		options:     options,
+		handlers:    map[string]handlerEntry{},
+		middlewares: options.Middlewares,
+		logger:      logger.Sugar(),
+	}
+}
+
+func (d *dispatcherImpl) Register(handler interface{}) error {
+	fn := reflect.ValueOf(handler)
+	t := fn.Type()
+
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.NumOut() != 1 {
+		return fmt.Errorf("handler must be func(context.Context, *Message) error, got %s", t)
+	}
+
+	if t.In(0) != contextType || t.Out(0) != errorType {
+		return fmt.Errorf("handler must be func(context.Context, *Message) error, got %s", t)
+	}
+
+	msgType := t.In(1)
+	if msgType.Kind() != reflect.Ptr || !msgType.Implements(messageType) {
+		return fmt.Errorf("handler argument %s is not a proto message", msgType)
+	}
+
+	name := proto.MessageName(reflect.New(msgType.Elem()).Interface().(proto.Message))
+	if name == "" {
+		return fmt.Errorf("message %s is not registered in protobuf", msgType)
+	}
+
+	if _, ok := d.handlers[name]; ok {
+		return fmt.Errorf("handler for %s already registered", name)
+	}
+
+	d.handlers[name] = handlerEntry{
+		messageType: msgType,
+		fn:          fn,
+	}
+
+	return nil
+}
+
+func (d *dispatcherImpl) MustRegister(handler interface{}) {
+	if err := d.Register(handler); err != nil {
+		panic(err)
+	}
+}
+
+func (d *dispatcherImpl) Use(middlewares ...Middleware) {
+	d.middlewares = append(d.middlewares, middlewares...)
+}
+
+func (d *dispatcherImpl) Start() error {
+	subscription, err := d.broker.Subscribe(SubscribeOptions{
+		Name:     d.options.Name,
+		Prefetch: d.options.Prefetch,
+	})
+	if err != nil {
+		return err
+	}
+
+	d.subscription = subscription
+
+	for i := 0; i < d.options.Concurrency; i++ {
+		d.wg.Add(1)
+		go d.work(subscription.Deliveries())
+	}
+
+	d.logger.Infow(" [*] Waiting for messages", "concurrency", d.options.Concurrency, "prefetch", d.options.Prefetch)
+
+	return nil
+}
+
+func (d *dispatcherImpl) Stop() error {
+	if d.subscription == nil {
+		return nil
+	}
+
+	// Closing the subscription closes the deliveries channel, which lets the
+	// workers finish what they already hold and exit.
+	err := d.subscription.Close()
+	d.wg.Wait()
+
+	return err
+}
+
+func (d *dispatcherImpl) work(deliveries <-chan Delivery) {
+	defer d.wg.Done()
+
+	handler := d.chain(d.invoke)
+
+	for delivery := range deliveries {
+		d.dispatch(handler, delivery)
+	}
+}
+
+func (d *dispatcherImpl) dispatch(handler HandlerFunc, delivery Delivery) {
+	msg := delivery.Message()
+
+	entry, ok := d.handlers[msg.Type]
+	if !ok {
+		d.unknownType(delivery)
+		return
+	}
+
+	message := reflect.New(entry.messageType.Elem()).Interface().(proto.Message)
+
+	err := proto.Unmarshal(msg.Body, message)
+	if err != nil {
+		// A body we can't decode will not decode on redelivery either.
+		d.logger.Errorw("error unmarshalling message", "type", msg.Type, "err", err)
+		delivery.Nack(false)
+		return
+	}
+
+	ctx := WithEnvelope(withDelivery(context.Background(), delivery), msg.Envelope)
+
+	err = handler(ctx, message)
+
+	var retry *RetryError
+	if errors.As(err, &retry) {
+		// The worker moves on meanwhile; the delivery stays unacked, and
+		// so counts against the prefetch, until it is put back.
+		d.logger.Warnw("retrying message later", "type", msg.Type, "delay", retry.Delay, "err", retry.Err)
+		time.AfterFunc(retry.Delay, func() {
+			delivery.Nack(true)
+		})
+		return
+	}
+
+	if err != nil {
+		// Give the message one more chance before it is rejected. RabbitMQ
+		// and Redis Streams move rejected messages to their dead letter
+		// queue and stream; NATS and the memory broker drop them.
+		d.logger.Errorw("error handling message", "type", msg.Type, "redelivered", delivery.Redelivered(), "err", err)
+
+		if delivery.Redelivered() {
+			d.logger.Errorw("message rejected", "type", msg.Type, "message_id", msg.Envelope.MessageID, "correlation_id", msg.Envelope.CorrelationID)
+		}
+
+		delivery.Nack(!delivery.Redelivered())
+		return
+	}
+
+	delivery.Ack()
+}
+
+func (d *dispatcherImpl) unknownType(delivery Delivery) {
+	d.logger.Warnw("no handler for message type", "type", delivery.Message().Type, "policy", d.options.UnknownType)
+
+	switch d.options.UnknownType {
+	case UnknownTypeRequeue:
+		delivery.Nack(true)
+	case UnknownTypeReject:
+		delivery.Nack(false)
+	default:
+		delivery.Ack()
+	}
+}
+
+func (d *dispatcherImpl) invoke(ctx context.Context, message proto.Message) error {
+	entry := d.handlers[proto.MessageName(message)]
+
+	out := entry.fn.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(message)})
+	if err, ok := out[0].Interface().(error); ok {
+		return err
+	}
+
+	return nil
+}
+
+func (d *dispatcherImpl) chain(handler HandlerFunc) HandlerFunc {
+	for i := len(d.middlewares) - 1; i >= 0; i-- {
+		handler = d.middlewares[i](handler)
+	}
+
+	return handler
+}
+
+type deliveryKey struct{}
+
+func withDelivery(ctx context.Context, delivery Delivery) context.Context {
+	return context.WithValue(ctx, deliveryKey{}, delivery)
+}
+
+// DeliveryFromContext returns the delivery being handled.
+func DeliveryFromContext(ctx context.Context) (Delivery, bool) {
+	delivery, ok := ctx.Value(deliveryKey{}).(Delivery)
+	return delivery, ok
+}
@@ -0,0 +1,155 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+const (
+	HeaderMessageID     = "x-message-id"
+	HeaderCorrelationID = "x-correlation-id"
+	HeaderCausationID   = "x-causation-id"
+	HeaderTimestamp     = "x-timestamp"
+	HeaderSchemaVersion = "x-schema-version"
+	HeaderProducer      = "x-producer"
+	HeaderTraceParent   = "traceparent"
+	HeaderTraceState    = "tracestate"
+
+	SchemaVersion = "1"
+)
+
+// Producer identifies this process in the envelope of every message it
+// publishes.
+var Producer = defaultProducer()
+
+// Envelope is the metadata carried next to every message body. The
+// correlation id is the execution id of the transfer the message belongs
+// to, and the causation id is the message id of the message whose handling
+// produced this one.
+type Envelope struct {
+	MessageID     string    `json:"message_id"`
+	CorrelationID string    `json:"correlation_id"`
+	CausationID   string    `json:"causation_id"`
+	Timestamp     time.Time `json:"timestamp"`
+	SchemaVersion string    `json:"schema_version"`
+	Producer      string    `json:"producer"`
+	TraceParent   string    `json:"traceparent"`
+	TraceState    string    `json:"tracestate"`
+}
+
+type executionIDMessage interface {
+	GetExecutionId() string
+}
+
+type envelopeKey struct{}
+
+func WithEnvelope(ctx context.Context, envelope Envelope) context.Context {
+	return context.WithValue(ctx, envelopeKey{}, envelope)
+}
+
+func EnvelopeFromContext(ctx context.Context) (Envelope, bool) {
+	envelope, ok := ctx.Value(envelopeKey{}).(Envelope)
+	return envelope, ok
+}
+
+// WithCorrelationID sets the correlation id for messages published with the
+// returned context, keeping the rest of the envelope already in ctx.
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	envelope, _ := EnvelopeFromContext(ctx)
+	envelope.CorrelationID = correlationID
+
+	return WithEnvelope(ctx, envelope)
+}
+
+// NewEnvelope builds the envelope for a message published while handling
+// whatever is in ctx. Messages carrying an execution id are correlated to
+// it; otherwise the correlation id is inherited from ctx.
+func NewEnvelope(ctx context.Context, message interface{}) Envelope {
+	parent, _ := EnvelopeFromContext(ctx)
+
+	envelope := Envelope{
+		MessageID:     uuid.New(),
+		CorrelationID: parent.CorrelationID,
+		CausationID:   parent.MessageID,
+		Timestamp:     time.Now().UTC(),
+		SchemaVersion: SchemaVersion,
+		Producer:      Producer,
+		TraceParent:   childTraceParent(parent.TraceParent),
+		TraceState:    parent.TraceState,
+	}
+
+	if m, ok := message.(executionIDMessage); ok && m.GetExecutionId() != "" {
+		envelope.CorrelationID = m.GetExecutionId()
+	}
+
+	if envelope.CorrelationID == "" {
+		envelope.CorrelationID = envelope.MessageID
+	}
+
+	return envelope
+}
+
+// Headers flattens the envelope into string headers for backends that carry
+// metadata as a plain map.
+func (e Envelope) Headers() map[string]string {
+	return map[string]string{
+		HeaderMessageID:     e.MessageID,
+		HeaderCorrelationID: e.CorrelationID,
+		HeaderCausationID:   e.CausationID,
+		HeaderTimestamp:     e.Timestamp.Format(time.RFC3339Nano),
+		HeaderSchemaVersion: e.SchemaVersion,
+		HeaderProducer:      e.Producer,
+		HeaderTraceParent:   e.TraceParent,
+		HeaderTraceState:    e.TraceState,
+	}
+}
+
+func EnvelopeFromHeaders(headers map[string]string) Envelope {
+	envelope := Envelope{
+		MessageID:     headers[HeaderMessageID],
+		CorrelationID: headers[HeaderCorrelationID],
+		CausationID:   headers[HeaderCausationID],
+		SchemaVersion: headers[HeaderSchemaVersion],
+		Producer:      headers[HeaderProducer],
+		TraceParent:   headers[HeaderTraceParent],
+		TraceState:    headers[HeaderTraceState],
+	}
+
+	if ts, err := time.Parse(time.RFC3339Nano, headers[HeaderTimestamp]); err == nil {
+		envelope.Timestamp = ts
+	}
+
+	return envelope
+}
+
+// childTraceParent keeps the trace id of a W3C traceparent and gives the hop
+// a new span id. Without a valid parent a new trace is started.
+func childTraceParent(parent string) string {
+	parts := strings.Split(parent, "-")
+	if len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		return fmt.Sprintf("00-%s-%s-%s", parts[1], randomHex(8), parts[3])
+	}
+
+	return fmt.Sprintf("00-%s-%s-01", randomHex(16), randomHex(8))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+func defaultProducer() string {
+	host, _ := os.Hostname()
+
+	return fmt.Sprintf("%s@%s", filepath.Base(os.Args[0]), host)
+}
@@ -0,0 +1,222 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+)
+
+var ErrClosed = errors.New("memory broker is closed")
+
+type entry struct {
+	message     broker.Message
+	redelivered bool
+}
+
+// memoryBroker keeps an in-process queue per subscriber name, with the same
+// semantics as the AMQP queues. Messages published before a name first
+// subscribes are not kept for it, and nothing survives a restart, so it is
+// meant for tests and local demos where server and worker share a process.
+type memoryBroker struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues map[string]*queue
+	closed bool
+}
+
+type queue struct {
+	pending []entry
+}
+
+func NewBroker() broker.Broker {
+	b := &memoryBroker{
+		queues: map[string]*queue{},
+	}
+	b.cond = sync.NewCond(&b.mu)
+
+	return b
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, message broker.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	for _, q := range b.queues {
+		q.pending = append(q.pending, entry{message: message})
+	}
+
+	b.cond.Broadcast()
+
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(options broker.SubscribeOptions) (broker.Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	prefetch := options.Prefetch
+	if prefetch <= 0 {
+		prefetch = 1
+	}
+
+	q, ok := b.queues[options.Name]
+	if !ok {
+		q = &queue{}
+		b.queues[options.Name] = q
+	}
+
+	sub := &subscription{
+		broker:     b,
+		queue:      q,
+		deliveries: make(chan broker.Delivery),
+		credits:    make(chan struct{}, prefetch),
+		done:       make(chan struct{}),
+		exited:     make(chan struct{}),
+	}
+
+	go sub.run()
+
+	return sub, nil
+}
+
+func (b *memoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.cond.Broadcast()
+
+	return nil
+}
+
+// next blocks until there is a message or the subscription stops.
+func (b *memoryBroker) next(sub *subscription) (entry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(sub.queue.pending) == 0 && !b.closed && !sub.stopped {
+		b.cond.Wait()
+	}
+
+	if b.closed || sub.stopped {
+		return entry{}, false
+	}
+
+	e := sub.queue.pending[0]
+	sub.queue.pending = sub.queue.pending[1:]
+
+	return e, true
+}
+
+func (b *memoryBroker) requeue(q *queue, e entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.redelivered = true
+	q.pending = append([]entry{e}, q.pending...)
+	b.cond.Broadcast()
+}
+
+type subscription struct {
+	broker     *memoryBroker
+	queue      *queue
+	deliveries chan broker.Delivery
+	// credits holds one token per unacked delivery, which caps them at the
+	// prefetch count.
+	credits  chan struct{}
+	done     chan struct{}
+	exited   chan struct{}
+	stopped  bool
+	inFlight sync.WaitGroup
+}
+
+func (s *subscription) run() {
+	defer close(s.exited)
+	defer close(s.deliveries)
+
+	for {
+		select {
+		case s.credits <- struct{}{}:
+		case <-s.done:
+			return
+		}
+
+		e, ok := s.broker.next(s)
+		if !ok {
+			return
+		}
+
+		s.inFlight.Add(1)
+		d := &delivery{entry: e, sub: s}
+
+		select {
+		case s.deliveries <- d:
+		case <-s.done:
+			d.Nack(true)
+			return
+		}
+	}
+}
+
+func (s *subscription) Deliveries() <-chan broker.Delivery {
+	return s.deliveries
+}
+
+func (s *subscription) Close() error {
+	s.broker.mu.Lock()
+	s.stopped = true
+	s.broker.cond.Broadcast()
+	s.broker.mu.Unlock()
+
+	close(s.done)
+	<-s.exited
+	s.inFlight.Wait()
+
+	return nil
+}
+
+type delivery struct {
+	entry entry
+	sub   *subscription
+	once  sync.Once
+}
+
+func (d *delivery) Message() broker.Message {
+	return d.entry.message
+}
+
+func (d *delivery) Redelivered() bool {
+	return d.entry.redelivered
+}
+
+func (d *delivery) Ack() error {
+	d.once.Do(d.settle)
+	return nil
+}
+
+func (d *delivery) Nack(requeue bool) error {
+	d.once.Do(func() {
+		if requeue {
+			d.sub.broker.requeue(d.sub.queue, d.entry)
+		}
+
+		d.settle()
+	})
+
+	return nil
+}
+
+func (d *delivery) settle() {
+	<-d.sub.credits
+	d.sub.inFlight.Done()
+}
@@ -0,0 +1,203 @@
+package broker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+func LoggingMiddleware(logger *zap.SugaredLogger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, message proto.Message) error {
+			start := time.Now()
+			err := next(ctx, message)
+
+			if err != nil {
+				logger.Errorw("message failed", "type", proto.MessageName(message), "duration", time.Since(start), "err", err)
+			} else {
+				logger.Infow("message handled", "type", proto.MessageName(message), "duration", time.Since(start))
+			}
+
+			return err
+		}
+	}
+}
+
+func MetricsMiddleware(scope tally.Scope) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, message proto.Message) error {
+			tagged := scope.Tagged(map[string]string{"message_type": proto.MessageName(message)})
+
+			sw := tagged.Timer("message_latency").Start()
+			err := next(ctx, message)
+			sw.Stop()
+
+			if err != nil {
+				tagged.Counter("message_failed").Inc(1)
+			} else {
+				tagged.Counter("message_handled").Inc(1)
+			}
+
+			return err
+		}
+	}
+}
+
+// RecoveryMiddleware turns a panicking handler into a failed message, so a
+// single bad message can't take the consumer goroutine down.
+func RecoveryMiddleware(logger *zap.SugaredLogger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, message proto.Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Errorw("panic handling message", "type", proto.MessageName(message), "panic", r, "stack", string(debug.Stack()))
+					err = fmt.Errorf("panic handling %s: %v", proto.MessageName(message), r)
+				}
+			}()
+
+			return next(ctx, message)
+		}
+	}
+}
+
+// Deduplicator is the consumer inbox: a message is claimed before its
+// handler runs, completed once it succeeds and released when it fails so a
+// redelivery can try again.
+type Deduplicator interface {
+	// Claim returns false when the message was already processed.
+	Claim(ctx context.Context, key string) (bool, error)
+	Complete(ctx context.Context, key string) error
+	Release(ctx context.Context, key string) error
+}
+
+// claimRetryDelay is how long a message waits before it is tried again
+// when its claim could not be taken.
+const claimRetryDelay = 5 * time.Second
+
+// DeduplicationMiddleware skips messages the inbox already processed. The
+// key is only completed once the handler succeeds, so failed messages are
+// still retried. A message whose claim is held elsewhere, or can't be
+// checked, is retried later rather than failed, since it may be the only
+// copy left.
+func DeduplicationMiddleware(dedup Deduplicator) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, message proto.Message) error {
+			key := MessageKey(ctx, message)
+
+			claimed, err := dedup.Claim(ctx, key)
+			if err != nil {
+				return RetryLater(err, claimRetryDelay)
+			}
+
+			if !claimed {
+				return nil
+			}
+
+			// A panic skips the release below; drop the claim before it
+			// goes on up to the recovery middleware.
+			defer func() {
+				if r := recover(); r != nil {
+					dedup.Release(ctx, key)
+					panic(r)
+				}
+			}()
+
+			err = next(ctx, message)
+			if err != nil {
+				if releaseErr := dedup.Release(ctx, key); releaseErr != nil {
+					return fmt.Errorf("%v (releasing inbox: %v)", err, releaseErr)
+				}
+
+				return err
+			}
+
+			return dedup.Complete(ctx, key)
+		}
+	}
+}
+
+// MessageKey identifies a message for deduplication. It uses the envelope
+// message id when the producer set one and falls back to a hash of the
+// message type and body.
+func MessageKey(ctx context.Context, message proto.Message) string {
+	if envelope, ok := EnvelopeFromContext(ctx); ok && envelope.MessageID != "" {
+		return envelope.MessageID
+	}
+
+	body, _ := proto.Marshal(message)
+	sum := sha256.Sum256(append([]byte(proto.MessageName(message)), body...))
+
+	return hex.EncodeToString(sum[:])
+}
+
+type memoryDeduplicatorImpl struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	processing map[string]bool
+	seen       map[string]time.Time
+}
+
+// NewMemoryDeduplicator keeps processed keys in process memory. It only
+// protects against redeliveries to the same consumer process.
+func NewMemoryDeduplicator(ttl time.Duration) Deduplicator {
+	return &memoryDeduplicatorImpl{
+		ttl:        ttl,
+		processing: map[string]bool{},
+		seen:       map[string]time.Time{},
+	}
+}
+
+func (m *memoryDeduplicatorImpl) Claim(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if expires, ok := m.seen[key]; ok {
+		if time.Now().Before(expires) {
+			return false, nil
+		}
+
+		delete(m.seen, key)
+	}
+
+	if m.processing[key] {
+		return false, fmt.Errorf("message %s is already being processed", key)
+	}
+
+	m.processing[key] = true
+
+	return true, nil
+}
+
+func (m *memoryDeduplicatorImpl) Complete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for k, expires := range m.seen {
+		if now.After(expires) {
+			delete(m.seen, k)
+		}
+	}
+
+	delete(m.processing, key)
+	m.seen[key] = now.Add(m.ttl)
+
+	return nil
+}
+
+func (m *memoryDeduplicatorImpl) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.processing, key)
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.uber.org/cadence/workflow"
+)
+
+const envelopeCadenceHeader = "avenue-envelope"
+
+type envelopePropagator struct{}
+
+// NewEnvelopePropagator carries the message envelope through Cadence
+// headers, from the client that starts or signals a workflow into the
+// workflow and from there into its activities.
+func NewEnvelopePropagator() workflow.ContextPropagator {
+	return &envelopePropagator{}
+}
+
+func (p *envelopePropagator) Inject(ctx context.Context, writer workflow.HeaderWriter) error {
+	envelope, ok := EnvelopeFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	return writeEnvelope(envelope, writer)
+}
+
+func (p *envelopePropagator) Extract(ctx context.Context, reader workflow.HeaderReader) (context.Context, error) {
+	envelope, ok, err := readEnvelope(reader)
+	if err != nil || !ok {
+		return ctx, err
+	}
+
+	return WithEnvelope(ctx, envelope), nil
+}
+
+func (p *envelopePropagator) InjectFromWorkflow(ctx workflow.Context, writer workflow.HeaderWriter) error {
+	envelope, ok := ctx.Value(envelopeKey{}).(Envelope)
+	if !ok {
+		return nil
+	}
+
+	return writeEnvelope(envelope, writer)
+}
+
+func (p *envelopePropagator) ExtractToWorkflow(ctx workflow.Context, reader workflow.HeaderReader) (workflow.Context, error) {
+	envelope, ok, err := readEnvelope(reader)
+	if err != nil || !ok {
+		return ctx, err
+	}
+
+	return workflow.WithValue(ctx, envelopeKey{}, envelope), nil
+}
+
+func writeEnvelope(envelope Envelope, writer workflow.HeaderWriter) error {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	writer.Set(envelopeCadenceHeader, data)
+
+	return nil
+}
+
+func readEnvelope(reader workflow.HeaderReader) (Envelope, bool, error) {
+	var envelope Envelope
+	found := false
+
+	err := reader.ForEachKey(func(key string, value []byte) error {
+		if key != envelopeCadenceHeader {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(value, &envelope)
+	})
+
+	return envelope, found, err
+}
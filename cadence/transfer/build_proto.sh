@@ -6,8 +6,9 @@ set -eo pipefail
 
 rm -f cadence/transfer/common/protogen/*.pb.go
 
-protoc --go_out=plugins=grpc:./cadence/transfer/common/protogen -I ./cadence/transfer/common/protodefs/  ./cadence/transfer/common/protodefs/*.proto
+protoc --go_out=./cadence/transfer/common/protogen --go-grpc_out=./cadence/transfer/common/protogen -I ./cadence/transfer/common/protodefs/  ./cadence/transfer/common/protodefs/*.proto
 
-# go get google.golang.org/grpc
+# go install google.golang.org/protobuf/cmd/protoc-gen-go@v1.26.0
+# go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.2.0
 
 
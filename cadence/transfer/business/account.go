@@ -26,6 +26,8 @@ var accounts []string = []string{
 	"6ff38d11-77db-4e01-8be6-f72b6311b8ca",
 }
 
+var ErrAccountNotFound = NewError(CodeAccountNotFound, "account not found")
+
 type AccountService interface {
 	GetAccount(id string) (*pb.AccountInformation, error)
 }
@@ -109,7 +111,15 @@ func (s *accountServiceImpl) createAccount(id string) error {
 }
 
 func (s *accountServiceImpl) GetAccount(id string) (*pb.AccountInformation, error) {
-	result, err := s.redis.GetConn().Get(context.Background(), fmt.Sprintf("account_%s", id)).Result()
+	return loadAccount(context.Background(), s.redis, id)
+}
+
+func loadAccount(ctx context.Context, redis redis.RedisConnection, id string) (*pb.AccountInformation, error) {
+	result, err := redis.GetConn().Get(ctx, fmt.Sprintf("account_%s", id)).Result()
+	if redis.NoKeyError(err) {
+		return nil, ErrAccountNotFound
+	}
+
 	if err != nil {
 		return nil, err
 	}
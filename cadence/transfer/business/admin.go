@@ -0,0 +1,362 @@
+package business
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/pborman/uuid"
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
+	"go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// historyPageSize is how many events a page of workflow history holds.
+const historyPageSize = 100
+
+// signalTriggers are the triggers the workflow acts on.
+var signalTriggers = map[SignalTrigger]bool{
+	SdToBankSignalStartValidate:     true,
+	SdToBankSignalStartBlock:        true,
+	SdToBankSignalStartJournal:      true,
+	SdToBankSignalStartUnblockDebit: true,
+	SdToBankSignalStartCredit:       true,
+	SdToBankSignalDone:              true,
+	SdToBankSignalBankReturned:      true,
+	SdToBankSignalCancel:            true,
+	SdToBankSignalWithdrawCanceled:  true,
+}
+
+// resetSteps are the steps a workflow can be reset to, each started by its
+// trigger. The credit follows the debit without a signal of its own, so
+// resetting to it runs the debit again, which posts nothing twice on the
+// ledger.
+var resetSteps = map[string]SignalTrigger{
+	"validate":      SdToBankSignalStartValidate,
+	"block":         SdToBankSignalStartBlock,
+	"unblock_debit": SdToBankSignalStartUnblockDebit,
+	"credit":        SdToBankSignalStartUnblockDebit,
+}
+
+// SignalTriggers lists the triggers the workflow acts on.
+func SignalTriggers() []SignalTrigger {
+	triggers := make([]SignalTrigger, 0, len(signalTriggers))
+	for trigger := range signalTriggers {
+		triggers = append(triggers, trigger)
+	}
+
+	sort.Slice(triggers, func(i, j int) bool { return triggers[i] < triggers[j] })
+
+	return triggers
+}
+
+// ResetSteps lists the steps a workflow can be reset to.
+func ResetSteps() []string {
+	steps := make([]string, 0, len(resetSteps))
+	for step := range resetSteps {
+		steps = append(steps, step)
+	}
+
+	sort.Strings(steps)
+
+	return steps
+}
+
+// HistoryPage is a page of raw workflow history. NextPageToken is empty on
+// the last page.
+type HistoryPage struct {
+	Events        []*shared.HistoryEvent `json:"events"`
+	NextPageToken []byte                 `json:"next_page_token,omitempty"`
+}
+
+// TransferAdmin is how operators move stuck transfers along. Every
+// operation is logged with its reason.
+type TransferAdmin interface {
+	// Signal sends any trigger to the workflow, whatever its status.
+	Signal(ctx context.Context, workflowID string, trigger SignalTrigger) error
+	// RetryActivity resets the workflow to the decision that scheduled its
+	// last failed or timed out activity, which runs it again.
+	RetryActivity(ctx context.Context, workflowID, reason string) error
+	// Reset resets the workflow to the decision that handled the last
+	// trigger of the step. Signals received after it are sent again unless
+	// skipSignals is set.
+	Reset(ctx context.Context, workflowID, step, reason string, skipSignals bool) error
+	// Terminate stops the workflow for good and marks the transfer
+	// terminated. Its hold, if any, is left for ReleaseHold.
+	Terminate(ctx context.Context, workflowID, reason string) error
+	// ReleaseHold gives the funds the transfer blocked on the ledger back.
+	// The workflow must be closed first, or it would go on to debit funds
+	// the customer may have spent meanwhile.
+	ReleaseHold(ctx context.Context, workflowID, reason string) (*Hold, error)
+	History(ctx context.Context, workflowID string, pageToken []byte) (*HistoryPage, error)
+}
+
+type transferAdminImpl struct {
+	wf        workflowserviceclient.Interface
+	domain    string
+	transfers SdToBankService
+	ledger    MoneyBinService
+	logger    *zap.SugaredLogger
+}
+
+func NewTransferAdmin(wf workflowserviceclient.Interface, domain string, transfers SdToBankService, ledger MoneyBinService) TransferAdmin {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("transfer_admin")
+
+	return &transferAdminImpl{
+		wf:        wf,
+		domain:    domain,
+		transfers: transfers,
+		ledger:    ledger,
+		logger:    logger.Sugar(),
+	}
+}
+
+func (s *transferAdminImpl) Signal(ctx context.Context, workflowID string, trigger SignalTrigger) error {
+	if !signalTriggers[trigger] {
+		return Errorf(CodeInvalidRequest, "unknown signal trigger %q", trigger)
+	}
+
+	err := s.client().SignalWorkflow(ctx, workflowID, "", SdToBankSignalName, string(trigger))
+	if err != nil {
+		return workflowError(err)
+	}
+
+	s.logger.Infow("Signal sent", "workflow_id", workflowID, "trigger", trigger)
+
+	return nil
+}
+
+func (s *transferAdminImpl) RetryActivity(ctx context.Context, workflowID, reason string) error {
+	execution, events, err := s.history(ctx, workflowID)
+	if err != nil {
+		return err
+	}
+
+	scheduled := map[int64]*shared.HistoryEvent{}
+	var failed *shared.HistoryEvent
+
+	for _, event := range events {
+		switch event.GetEventType() {
+		case shared.EventTypeActivityTaskScheduled:
+			scheduled[event.GetEventId()] = event
+		case shared.EventTypeActivityTaskFailed, shared.EventTypeActivityTaskTimedOut:
+			failed = event
+		}
+	}
+
+	if failed == nil {
+		return Errorf(CodeTransferState, "workflow %s has no failed activity", workflowID)
+	}
+
+	scheduledID := failed.ActivityTaskFailedEventAttributes.GetScheduledEventId()
+	if failed.GetEventType() == shared.EventTypeActivityTaskTimedOut {
+		scheduledID = failed.ActivityTaskTimedOutEventAttributes.GetScheduledEventId()
+	}
+
+	event, ok := scheduled[scheduledID]
+	if !ok {
+		return fmt.Errorf("activity scheduled event %d of %s not in history", scheduledID, workflowID)
+	}
+
+	attributes := event.ActivityTaskScheduledEventAttributes
+	s.logger.Infow("Retrying activity", "workflow_id", workflowID, "activity", attributes.ActivityType.GetName(), "reason", reason)
+
+	return s.reset(ctx, execution, attributes.GetDecisionTaskCompletedEventId(), reason, false)
+}
+
+func (s *transferAdminImpl) Reset(ctx context.Context, workflowID, step, reason string, skipSignals bool) error {
+	trigger, ok := resetSteps[step]
+	if !ok {
+		return Errorf(CodeInvalidRequest, "unknown step %q", step)
+	}
+
+	execution, events, err := s.history(ctx, workflowID)
+	if err != nil {
+		return err
+	}
+
+	// The decision to reset to is the first one completed after the last
+	// signal with the trigger, as it is the one that handled it.
+	var decisionID int64
+	signaled := false
+
+	for _, event := range events {
+		switch event.GetEventType() {
+		case shared.EventTypeWorkflowExecutionSignaled:
+			if isTrigger(event.WorkflowExecutionSignaledEventAttributes, trigger) {
+				signaled = true
+				decisionID = 0
+			}
+		case shared.EventTypeDecisionTaskCompleted:
+			if signaled && decisionID == 0 {
+				decisionID = event.GetEventId()
+			}
+		}
+	}
+
+	if decisionID == 0 {
+		return Errorf(CodeTransferState, "workflow %s never ran step %s", workflowID, step)
+	}
+
+	s.logger.Infow("Resetting workflow", "workflow_id", workflowID, "step", step, "reason", reason)
+
+	return s.reset(ctx, execution, decisionID, reason, skipSignals)
+}
+
+func (s *transferAdminImpl) Terminate(ctx context.Context, workflowID, reason string) error {
+	if reason == "" {
+		return Errorf(CodeInvalidRequest, "terminating a workflow needs a reason")
+	}
+
+	err := s.client().TerminateWorkflow(ctx, workflowID, "", reason, nil)
+	if err != nil {
+		return workflowError(err)
+	}
+
+	s.logger.Infow("Workflow terminated", "workflow_id", workflowID, "reason", reason)
+
+	return s.transfers.SetTransferStatus(ctx, workflowID, TransferStatusTerminated)
+}
+
+func (s *transferAdminImpl) ReleaseHold(ctx context.Context, workflowID, reason string) (*Hold, error) {
+	if reason == "" {
+		return nil, Errorf(CodeInvalidRequest, "releasing a hold needs a reason")
+	}
+
+	described, err := s.wf.DescribeWorkflowExecution(ctx, &shared.DescribeWorkflowExecutionRequest{
+		Domain:    &s.domain,
+		Execution: &shared.WorkflowExecution{WorkflowId: &workflowID},
+	})
+	if err != nil {
+		return nil, workflowError(err)
+	}
+
+	if described.WorkflowExecutionInfo == nil {
+		return nil, ErrTransferNotFound
+	}
+
+	if described.WorkflowExecutionInfo.CloseStatus == nil {
+		return nil, Errorf(CodeTransferState, "workflow %s is still running; terminate it before releasing its hold", workflowID)
+	}
+
+	s.logger.Infow("Releasing hold", "workflow_id", workflowID, "reason", reason)
+
+	return s.ledger.ReleaseHold(ctx, workflowID)
+}
+
+func (s *transferAdminImpl) History(ctx context.Context, workflowID string, pageToken []byte) (*HistoryPage, error) {
+	resp, err := s.wf.GetWorkflowExecutionHistory(ctx, &shared.GetWorkflowExecutionHistoryRequest{
+		Domain:          &s.domain,
+		Execution:       &shared.WorkflowExecution{WorkflowId: &workflowID},
+		MaximumPageSize: int32Ptr(historyPageSize),
+		NextPageToken:   pageToken,
+	})
+	if err != nil {
+		return nil, workflowError(err)
+	}
+
+	return &HistoryPage{Events: historyEvents(resp.History), NextPageToken: resp.NextPageToken}, nil
+}
+
+// history reads the whole history of the current run of the workflow.
+func (s *transferAdminImpl) history(ctx context.Context, workflowID string) (*shared.WorkflowExecution, []*shared.HistoryEvent, error) {
+	described, err := s.wf.DescribeWorkflowExecution(ctx, &shared.DescribeWorkflowExecutionRequest{
+		Domain:    &s.domain,
+		Execution: &shared.WorkflowExecution{WorkflowId: &workflowID},
+	})
+	if err != nil {
+		return nil, nil, workflowError(err)
+	}
+
+	if described.WorkflowExecutionInfo == nil {
+		return nil, nil, ErrTransferNotFound
+	}
+
+	execution := described.WorkflowExecutionInfo.Execution
+
+	var events []*shared.HistoryEvent
+	var pageToken []byte
+
+	for {
+		resp, err := s.wf.GetWorkflowExecutionHistory(ctx, &shared.GetWorkflowExecutionHistoryRequest{
+			Domain:          &s.domain,
+			Execution:       execution,
+			MaximumPageSize: int32Ptr(historyPageSize),
+			NextPageToken:   pageToken,
+		})
+		if err != nil {
+			return nil, nil, workflowError(err)
+		}
+
+		events = append(events, historyEvents(resp.History)...)
+
+		pageToken = resp.NextPageToken
+		if len(pageToken) == 0 {
+			return execution, events, nil
+		}
+	}
+}
+
+func (s *transferAdminImpl) reset(ctx context.Context, execution *shared.WorkflowExecution, decisionID int64, reason string, skipSignals bool) error {
+	requestID := uuid.New()
+
+	resp, err := s.wf.ResetWorkflowExecution(ctx, &shared.ResetWorkflowExecutionRequest{
+		Domain:                &s.domain,
+		WorkflowExecution:     execution,
+		Reason:                &reason,
+		DecisionFinishEventId: &decisionID,
+		RequestId:             &requestID,
+		SkipSignalReapply:     &skipSignals,
+	})
+	if err != nil {
+		return workflowError(err)
+	}
+
+	s.logger.Infow("Workflow reset", "workflow_id", execution.GetWorkflowId(), "from_run_id", execution.GetRunId(), "run_id", resp.GetRunId(), "event_id", decisionID)
+
+	return nil
+}
+
+func (s *transferAdminImpl) client() client.Client {
+	return client.NewClient(
+		s.wf, s.domain, &client.Options{Identity: "transfer-admin", MetricsScope: tally.NoopScope, ContextPropagators: []workflow.ContextPropagator{broker.NewEnvelopePropagator()}})
+}
+
+// isTrigger tells whether the signal carried the trigger. Signal inputs
+// are JSON encoded.
+func isTrigger(attributes *shared.WorkflowExecutionSignaledEventAttributes, trigger SignalTrigger) bool {
+	if attributes.GetSignalName() != SdToBankSignalName {
+		return false
+	}
+
+	var received SignalTrigger
+	err := json.Unmarshal(attributes.Input, &received)
+
+	return err == nil && received == trigger
+}
+
+func historyEvents(history *shared.History) []*shared.HistoryEvent {
+	if history == nil {
+		return nil
+	}
+
+	return history.Events
+}
+
+// workflowError turns Cadence errors about missing workflows into
+// ErrTransferNotFound.
+func workflowError(err error) error {
+	var notExists *shared.EntityNotExistsError
+	if errors.As(err, &notExists) {
+		return fmt.Errorf("%s: %w", notExists.Message, ErrTransferNotFound)
+	}
+
+	return err
+}
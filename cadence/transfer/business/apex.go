@@ -1,24 +1,98 @@
 package business
 
 import (
-	"avenuesec/workflow-poc/cadence/transfer/rabbitmq"
+	"avenuesec/workflow-poc/cadence/transfer/apex"
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"context"
 
 	"go.uber.org/zap"
 )
 
-type ApexService interface{}
+type ApexService interface {
+	// Withdraw sends the withdrawal to Apex and publishes its response, so
+	// it reaches the workflow through the same path as Apex's own updates.
+	Withdraw(ctx context.Context, msg *pb.ApexWithdrawMessage) (*pb.ApexWithdrawResponse, error)
+	WithdrawStatus(ctx context.Context, executionID string) (*pb.ApexWithdrawResponse, error)
+	CancelWithdraw(ctx context.Context, executionID string) (*pb.ApexWithdrawResponse, error)
+}
 
 type apexServiceImpl struct {
-	rabbit rabbitmq.AmqpConnection
+	broker broker.Broker
+	client apex.WithdrawClient
 	logger *zap.SugaredLogger
 }
 
-func ApexBinService(rabbit rabbitmq.AmqpConnection) ApexService {
+func ApexBinService(broker broker.Broker, client apex.WithdrawClient) ApexService {
 	logger, _ := zap.NewProduction()
 	logger = logger.Named("apex_service")
 
 	return &apexServiceImpl{
-		rabbit: rabbit,
+		broker: broker,
+		client: client,
 		logger: logger.Sugar(),
 	}
 }
+
+func (s *apexServiceImpl) Withdraw(ctx context.Context, msg *pb.ApexWithdrawMessage) (*pb.ApexWithdrawResponse, error) {
+	resp, err := s.client.RequestWithdraw(ctx, msg)
+
+	// The execution id is the Apex request id, so a duplicate means an
+	// earlier attempt got through and only its status is missing.
+	if apex.IsCode(err, apex.ErrorDuplicate) {
+		s.logger.Infow("Withdraw already requested", "execution_id", msg.ExecutionId)
+		resp, err = s.client.GetWithdrawStatus(ctx, msg.ExecutionId)
+	}
+
+	if err != nil {
+		s.logger.Errorw("Error requesting withdraw", "execution_id", msg.ExecutionId, "err", err)
+		return nil, err
+	}
+
+	return resp, s.publish(ctx, resp)
+}
+
+func (s *apexServiceImpl) WithdrawStatus(ctx context.Context, executionID string) (*pb.ApexWithdrawResponse, error) {
+	resp, err := s.client.GetWithdrawStatus(ctx, executionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, s.publish(ctx, resp)
+}
+
+func (s *apexServiceImpl) CancelWithdraw(ctx context.Context, executionID string) (*pb.ApexWithdrawResponse, error) {
+	resp, err := s.client.CancelWithdraw(ctx, executionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, s.publish(ctx, resp)
+}
+
+func (s *apexServiceImpl) publish(ctx context.Context, resp *pb.ApexWithdrawResponse) error {
+	err := broker.ProduceStruct(ctx, s.broker, resp)
+	if err != nil {
+		s.logger.Errorw("Error publishing apex response", "execution_id", resp.ExecutionId, "err", err)
+	}
+
+	return err
+}
+
+// apexServiceClient is the Apex client seen through ApexService, so every
+// response it gets is published as well.
+type apexServiceClient struct {
+	service ApexService
+}
+
+func (c apexServiceClient) RequestWithdraw(ctx context.Context, msg *pb.ApexWithdrawMessage) (*pb.ApexWithdrawResponse, error) {
+	return c.service.Withdraw(ctx, msg)
+}
+
+func (c apexServiceClient) GetWithdrawStatus(ctx context.Context, executionID string) (*pb.ApexWithdrawResponse, error) {
+	return c.service.WithdrawStatus(ctx, executionID)
+}
+
+func (c apexServiceClient) CancelWithdraw(ctx context.Context, executionID string) (*pb.ApexWithdrawResponse, error) {
+	return c.service.CancelWithdraw(ctx, executionID)
+}
@@ -61,7 +61,7 @@ func (s *balanceServiceImpl) createBalance(acc *pb.AccountInformation) error {
 		return err
 	}
 
-	status := s.redis.GetConn().Set(context.Background(), fmt.Sprintf("balance_%s", acc.AccountUsId), strUs, time.Duration(1000*time.Hour))
+	status := s.redis.GetConn().Set(context.Background(), balanceKey(acc.AccountUsId), strUs, time.Duration(1000*time.Hour))
 	if status != nil && status.Err() != nil {
 		return status.Err()
 	}
@@ -77,7 +77,7 @@ func (s *balanceServiceImpl) createBalance(acc *pb.AccountInformation) error {
 		return err
 	}
 
-	status = s.redis.GetConn().Set(context.Background(), fmt.Sprintf("balance_%s", acc.AccountBankId), strBank, time.Duration(1000*time.Hour))
+	status = s.redis.GetConn().Set(context.Background(), balanceKey(acc.AccountBankId), strBank, time.Duration(1000*time.Hour))
 	if status != nil && status.Err() != nil {
 		return status.Err()
 	}
@@ -86,7 +86,11 @@ func (s *balanceServiceImpl) createBalance(acc *pb.AccountInformation) error {
 }
 
 func (s *balanceServiceImpl) GetBalance(id string) (*pb.BalanceInformation, error) {
-	result, err := s.redis.GetConn().Get(context.Background(), fmt.Sprintf("balance_%s", id)).Result()
+	result, err := s.redis.GetConn().Get(context.Background(), balanceKey(id)).Result()
+	if s.redis.NoKeyError(err) {
+		return nil, ErrAccountNotFound
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -110,10 +114,14 @@ func (s *balanceServiceImpl) UpdateBalace(id string, value float64) error {
 		return err
 	}
 
-	status := s.redis.GetConn().Set(context.Background(), fmt.Sprintf("balance_%s", balance.AccountId), str, time.Duration(1000*time.Hour))
+	status := s.redis.GetConn().Set(context.Background(), balanceKey(balance.AccountId), str, time.Duration(1000*time.Hour))
 	if status != nil && status.Err() != nil {
 		return status.Err()
 	}
 
 	return nil
 }
+
+func balanceKey(id string) string {
+	return fmt.Sprintf("balance_%s", id)
+}
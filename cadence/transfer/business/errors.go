@@ -0,0 +1,132 @@
+package business
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/resilience"
+	"avenuesec/workflow-poc/cadence/transfer/validation"
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/cadence"
+)
+
+// ErrorCode is a stable name for what went wrong, for clients to branch
+// on. It is the code of API errors and the reason of activity errors.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest       ErrorCode = "invalid_request"
+	CodeUnauthenticated      ErrorCode = "unauthenticated"
+	CodeForbidden            ErrorCode = "forbidden"
+	CodeTransferNotFound     ErrorCode = "transfer_not_found"
+	CodeAccountNotFound      ErrorCode = "account_not_found"
+	CodePaymentNotFound      ErrorCode = "payment_not_found"
+	CodeHoldNotFound         ErrorCode = "hold_not_found"
+	CodeReportNotFound       ErrorCode = "report_not_found"
+	CodeCaseNotFound         ErrorCode = "case_not_found"
+	CodeCaseResolved         ErrorCode = "case_already_resolved"
+	CodeInvalidSignature     ErrorCode = "invalid_signature"
+	CodeReplayedRequest      ErrorCode = "replayed_request"
+	CodeInProgress           ErrorCode = "in_progress"
+	CodeInsufficientFunds    ErrorCode = "insufficient_funds"
+	CodeLimitExceeded        ErrorCode = "limit_exceeded"
+	CodeTransferState        ErrorCode = "transfer_state_conflict"
+	CodeIdempotencyKeyReused ErrorCode = "idempotency_key_reused"
+	CodeNotCancelable        ErrorCode = "payment_not_cancelable"
+	CodeUnsupported          ErrorCode = "unsupported_operation"
+	CodeNoProvider           ErrorCode = "no_provider"
+	CodeProviderUnavailable  ErrorCode = resilience.ReasonProviderUnavailable
+	CodeBrokerUnavailable    ErrorCode = "broker_unavailable"
+	CodeTimeout              ErrorCode = "timeout"
+	CodeInternal             ErrorCode = "internal"
+)
+
+var (
+	ErrInsufficientFunds = NewError(CodeInsufficientFunds, "insufficient funds")
+	// ErrLimitExceeded is returned when a transfer goes over a limit of
+	// its account.
+	ErrLimitExceeded = NewError(CodeLimitExceeded, "limit exceeded")
+)
+
+// Error is a failure with a code. Errors with the same code match with
+// errors.Is, so the sentinels stand for every error of their code.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Err     error
+}
+
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Errorf builds an Error with a formatted message, wrapping the %w
+// argument if any.
+func Errorf(code ErrorCode, format string, args ...interface{}) *Error {
+	err := fmt.Errorf(format, args...)
+
+	return &Error{Code: code, Message: err.Error(), Err: errors.Unwrap(err)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// CodeOf finds the code of err, which may be an Error, a validation or
+// provider failure, or the cadence.CustomError of a failed activity. Other
+// errors are internal.
+func CodeOf(err error) ErrorCode {
+	var coded *Error
+	var invalid validation.Errors
+	var custom *cadence.CustomError
+
+	_, unavailable := resilience.IsUnavailable(err)
+
+	switch {
+	case err == nil:
+		return ""
+	case unavailable:
+		return CodeProviderUnavailable
+	case errors.As(err, &coded):
+		return coded.Code
+	case errors.As(err, &invalid):
+		return CodeInvalidRequest
+	case errors.As(err, &custom):
+		return ErrorCode(custom.Reason())
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeTimeout
+	}
+
+	return CodeInternal
+}
+
+// ActivityError turns the error of an activity into a cadence.CustomError
+// whose reason is its code, so the workflow and its callers can tell
+// failures apart. Provider failures keep the wait before the next try as
+// details; other coded errors keep their message. Uncoded errors are
+// returned as they are.
+func ActivityError(err error) error {
+	if unavailable, ok := resilience.IsUnavailable(err); ok {
+		return cadence.NewCustomError(string(CodeProviderUnavailable), unavailable.RetryAfter.Seconds())
+	}
+
+	code := CodeOf(err)
+	if code == "" || code == CodeInternal {
+		return err
+	}
+
+	if _, ok := err.(*cadence.CustomError); ok {
+		return err
+	}
+
+	return cadence.NewCustomError(string(code), err.Error())
+}
@@ -1,24 +1,248 @@
 package business
 
 import (
-	"avenuesec/workflow-poc/cadence/transfer/rabbitmq"
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"context"
+	"fmt"
+	"time"
 
+	goredis "github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 )
 
-type MoneyBinService interface{}
+// entryRequires is the entry each kind must follow within an execution:
+// funds are blocked, released and debited, and only then credited.
+var entryRequires = map[pb.EntryKind]pb.EntryKind{
+	pb.EntryKind_Unblock: pb.EntryKind_Block,
+	pb.EntryKind_Debit:   pb.EntryKind_Unblock,
+	pb.EntryKind_Credit:  pb.EntryKind_Debit,
+}
+
+// entrySign is how each kind moves the available balance.
+var entrySign = map[pb.EntryKind]float64{
+	pb.EntryKind_Block:   -1,
+	pb.EntryKind_Unblock: 1,
+	pb.EntryKind_Debit:   -1,
+	pb.EntryKind_Credit:  1,
+}
+
+// Hold is an amount a transfer blocked on the ledger and has not unblocked
+// yet.
+type Hold struct {
+	AccountID   string  `json:"account_id"`
+	ExecutionID string  `json:"execution_id"`
+	Amount      float64 `json:"amount"`
+}
+
+var ErrHoldNotFound = NewError(CodeHoldNotFound, "hold not found")
+
+type MoneyBinService interface {
+	// AddEntry posts a ledger entry and publishes an EntryAck for it. Each
+	// kind is posted at most once per execution: a repeated entry is
+	// acknowledged again without touching the balance. Entries out of
+	// sequence are rejected in the ack, not with an error.
+	AddEntry(ctx context.Context, entry *pb.AddEntry) (*pb.EntryAck, error)
+	Entries(ctx context.Context, executionID string) ([]*pb.AddEntry, error)
+	// ReleaseHold posts the Unblock entry of a transfer that blocked funds,
+	// giving them back. Like every entry it is posted once, so the funds
+	// of a transfer can't be given back twice.
+	ReleaseHold(ctx context.Context, executionID string) (*Hold, error)
+}
 
 type moneyBinServiceImpl struct {
-	rabbit rabbitmq.AmqpConnection
+	broker broker.Broker
+	redis  redis.RedisConnection
 	logger *zap.SugaredLogger
 }
 
-func MoneyBinBinService(rabbit rabbitmq.AmqpConnection) MoneyBinService {
+func MoneyBinBinService(broker broker.Broker, redis redis.RedisConnection) MoneyBinService {
 	logger, _ := zap.NewProduction()
 	logger = logger.Named("moneyBin_service")
 
 	return &moneyBinServiceImpl{
-		rabbit: rabbit,
+		broker: broker,
+		redis:  redis,
 		logger: logger.Sugar(),
 	}
 }
+
+func (s *moneyBinServiceImpl) AddEntry(ctx context.Context, entry *pb.AddEntry) (*pb.EntryAck, error) {
+	ack, err := s.post(ctx, entry)
+	if err != nil {
+		s.logger.Errorw("Error posting entry", "execution_id", entry.ExecutionId, "kind", entry.Kind, "err", err)
+		return nil, err
+	}
+
+	if !ack.Accepted {
+		s.logger.Warnw("Entry rejected", "execution_id", entry.ExecutionId, "kind", entry.Kind, "reason", ack.Reason)
+	}
+
+	err = broker.ProduceStruct(ctx, s.broker, ack)
+	if err != nil {
+		s.logger.Errorw("Error publishing entry ack", "execution_id", entry.ExecutionId, "kind", entry.Kind, "err", err)
+		return nil, err
+	}
+
+	return ack, nil
+}
+
+func (s *moneyBinServiceImpl) post(ctx context.Context, entry *pb.AddEntry) (*pb.EntryAck, error) {
+	ack := &pb.EntryAck{
+		ExecutionId: entry.ExecutionId,
+		Kind:        entry.Kind,
+		Amount:      entry.Amount,
+		AccId:       entry.AccId,
+	}
+
+	if entry.ExecutionId == "" || entry.AccId == "" || entry.Amount <= 0 {
+		ack.Reason = "entry needs an execution id, an account and a positive amount"
+		return ack, nil
+	}
+
+	ledgerKey := moneyBinKey(entry.ExecutionId)
+	bKey := balanceKey(entry.AccId)
+
+	err := s.redis.GetConn().Watch(ctx, func(tx *goredis.Tx) error {
+		posted, err := s.entries(ctx, tx, entry.ExecutionId)
+		if err != nil {
+			return err
+		}
+
+		if previous, ok := posted[entry.Kind]; ok {
+			if previous.AccId != entry.AccId || previous.Amount != entry.Amount {
+				ack.Reason = fmt.Sprintf("%s already posted with different values", entry.Kind)
+				return nil
+			}
+
+			ack.Accepted = true
+			return nil
+		}
+
+		if required, ok := entryRequires[entry.Kind]; ok {
+			if _, ok := posted[required]; !ok {
+				ack.Reason = fmt.Sprintf("%s requires %s", entry.Kind, required)
+				return nil
+			}
+		}
+
+		result, err := tx.Get(ctx, bKey).Result()
+		if s.redis.NoKeyError(err) {
+			ack.Reason = "account has no balance"
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var balance pb.BalanceInformation
+		err = proto.Unmarshal([]byte(result), &balance)
+		if err != nil {
+			return err
+		}
+
+		balance.Available += entrySign[entry.Kind] * entry.Amount
+		if balance.Available < 0 {
+			ack.Reason = "not enough balance"
+			return nil
+		}
+
+		balanceData, err := proto.Marshal(&balance)
+		if err != nil {
+			return err
+		}
+
+		entryData, err := proto.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.HSet(ctx, ledgerKey, entry.Kind.String(), entryData)
+			pipe.Expire(ctx, ledgerKey, time.Duration(1000*time.Hour))
+			pipe.Set(ctx, bKey, balanceData, time.Duration(1000*time.Hour))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		ack.Accepted = true
+		return nil
+	}, ledgerKey, bKey)
+
+	return ack, err
+}
+
+func (s *moneyBinServiceImpl) Entries(ctx context.Context, executionID string) ([]*pb.AddEntry, error) {
+	posted, err := s.entries(ctx, s.redis.GetConn(), executionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*pb.AddEntry
+	for _, kind := range []pb.EntryKind{pb.EntryKind_Block, pb.EntryKind_Unblock, pb.EntryKind_Debit, pb.EntryKind_Credit} {
+		if entry, ok := posted[kind]; ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *moneyBinServiceImpl) ReleaseHold(ctx context.Context, executionID string) (*Hold, error) {
+	posted, err := s.entries(ctx, s.redis.GetConn(), executionID)
+	if err != nil {
+		return nil, err
+	}
+
+	block, blocked := posted[pb.EntryKind_Block]
+	if _, unblocked := posted[pb.EntryKind_Unblock]; !blocked || unblocked {
+		return nil, ErrHoldNotFound
+	}
+
+	ack, err := s.AddEntry(ctx, &pb.AddEntry{
+		Amount:      block.Amount,
+		AccId:       block.AccId,
+		Kind:        pb.EntryKind_Unblock,
+		ExecutionId: executionID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !ack.Accepted {
+		return nil, Errorf(CodeTransferState, "release hold of %s: %s", executionID, ack.Reason)
+	}
+
+	s.logger.Infow("Hold released", "execution_id", executionID, "account", block.AccId, "amount", block.Amount)
+
+	return &Hold{AccountID: block.AccId, ExecutionID: executionID, Amount: block.Amount}, nil
+}
+
+func (s *moneyBinServiceImpl) entries(ctx context.Context, cmd goredis.Cmdable, executionID string) (map[pb.EntryKind]*pb.AddEntry, error) {
+	fields, err := cmd.HGetAll(ctx, moneyBinKey(executionID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	posted := map[pb.EntryKind]*pb.AddEntry{}
+	for _, data := range fields {
+		var entry pb.AddEntry
+		err = proto.Unmarshal([]byte(data), &entry)
+		if err != nil {
+			return nil, err
+		}
+
+		posted[entry.Kind] = &entry
+	}
+
+	return posted, nil
+}
+
+func moneyBinKey(executionID string) string {
+	return fmt.Sprintf("moneybin_%s", executionID)
+}
@@ -0,0 +1,317 @@
+package business
+
+import (
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Capability string
+
+const (
+	CapabilityWithdraw Capability = "withdraw"
+	CapabilityDeposit  Capability = "deposit"
+	CapabilityStatus   Capability = "status"
+	CapabilityCancel   Capability = "cancel"
+
+	CurrencyUSD = "USD"
+)
+
+// Capabilities lists every capability a provider may declare.
+var Capabilities = []Capability{CapabilityWithdraw, CapabilityDeposit, CapabilityStatus, CapabilityCancel}
+
+type PaymentStatus string
+
+const (
+	PaymentPending   PaymentStatus = "pending"
+	PaymentCompleted PaymentStatus = "completed"
+	PaymentCanceled  PaymentStatus = "canceled"
+	PaymentFailed    PaymentStatus = "failed"
+)
+
+var (
+	ErrUnsupportedCapability = NewError(CodeUnsupported, "provider does not support this operation")
+	ErrPaymentNotFound       = NewError(CodePaymentNotFound, "payment not found at provider")
+	ErrPaymentNotCancelable  = NewError(CodeNotCancelable, "payment can no longer be canceled")
+	ErrNoProvider            = NewError(CodeNoProvider, "no provider routes this payment")
+)
+
+// PaymentRequest is what every provider is asked to move. ExecutionID is
+// the idempotency key: sending the same request twice must not move money
+// twice.
+type PaymentRequest struct {
+	ExecutionID string
+	AccountID   string
+	Amount      float64
+	Currency    string
+	Direction   pb.Direction
+}
+
+type PaymentResult struct {
+	ExecutionID string
+	Provider    string
+	Status      PaymentStatus
+	// ProviderStatus is the provider's own status, kept for logs and
+	// support.
+	ProviderStatus string
+}
+
+// Provider is an adapter to a payment partner. Operations outside its
+// capabilities return ErrUnsupportedCapability; unknown payments return
+// ErrPaymentNotFound. Every adapter must pass providertest.Check.
+type Provider interface {
+	Name() string
+	Capabilities() []Capability
+	Withdraw(ctx context.Context, req PaymentRequest) (*PaymentResult, error)
+	Deposit(ctx context.Context, req PaymentRequest) (*PaymentResult, error)
+	Status(ctx context.Context, executionID string) (*PaymentResult, error)
+	Cancel(ctx context.Context, executionID string) (*PaymentResult, error)
+}
+
+func HasCapability(p Provider, capability Capability) bool {
+	for _, c := range p.Capabilities() {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ProviderConfig configures one provider. Kind picks the adapter; Settings
+// are passed to it as they are.
+type ProviderConfig struct {
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	Disabled   bool              `json:"disabled"`
+	Currencies []string          `json:"currencies"`
+	Settings   map[string]string `json:"settings"`
+}
+
+// Route sends payments of a direction and currency to a provider. An empty
+// currency matches any. Routes are tried by ascending priority.
+type Route struct {
+	Direction pb.Direction `json:"direction"`
+	Currency  string       `json:"currency"`
+	Provider  string       `json:"provider"`
+	Priority  int          `json:"priority"`
+}
+
+type ProvidersConfig struct {
+	Providers []ProviderConfig `json:"providers"`
+	Routes    []Route          `json:"routes"`
+}
+
+func LoadProvidersConfig(path string) (ProvidersConfig, error) {
+	var config ProvidersConfig
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	err = json.Unmarshal(data, &config)
+
+	return config, err
+}
+
+type ProviderFactory func(config ProviderConfig) (Provider, error)
+
+// ProviderInfo describes a registered provider for operators.
+type ProviderInfo struct {
+	Name         string       `json:"name"`
+	Kind         string       `json:"kind"`
+	Disabled     bool         `json:"disabled"`
+	Currencies   []string     `json:"currencies"`
+	Capabilities []Capability `json:"capabilities"`
+}
+
+type ProviderRegistry interface {
+	RegisterFactory(kind string, factory ProviderFactory)
+	// Load builds every configured provider with the factory of its kind
+	// and adds the configured routes.
+	Load(config ProvidersConfig) error
+	Register(provider Provider, config ProviderConfig) error
+	AddRoute(route Route) error
+	Provider(name string) (Provider, error)
+	// Route picks the provider for a payment that needs capability.
+	Route(direction pb.Direction, currency string, capability Capability) (Provider, error)
+	Providers() []ProviderInfo
+	Routes() []Route
+}
+
+type registeredProvider struct {
+	provider Provider
+	config   ProviderConfig
+}
+
+type providerRegistryImpl struct {
+	mu        sync.RWMutex
+	factories map[string]ProviderFactory
+	providers map[string]registeredProvider
+	routes    []Route
+}
+
+func NewProviderRegistry() ProviderRegistry {
+	return &providerRegistryImpl{
+		factories: map[string]ProviderFactory{},
+		providers: map[string]registeredProvider{},
+	}
+}
+
+func (r *providerRegistryImpl) RegisterFactory(kind string, factory ProviderFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.factories[kind] = factory
+}
+
+func (r *providerRegistryImpl) Load(config ProvidersConfig) error {
+	for _, providerConfig := range config.Providers {
+		r.mu.RLock()
+		factory, ok := r.factories[providerConfig.Kind]
+		r.mu.RUnlock()
+
+		if !ok {
+			return fmt.Errorf("provider %s: unknown kind %q", providerConfig.Name, providerConfig.Kind)
+		}
+
+		provider, err := factory(providerConfig)
+		if err != nil {
+			return fmt.Errorf("provider %s: %v", providerConfig.Name, err)
+		}
+
+		err = r.Register(provider, providerConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, route := range config.Routes {
+		err := r.AddRoute(route)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *providerRegistryImpl) Register(provider Provider, config ProviderConfig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if config.Name == "" {
+		config.Name = provider.Name()
+	}
+
+	if _, ok := r.providers[config.Name]; ok {
+		return fmt.Errorf("provider %s already registered", config.Name)
+	}
+
+	r.providers[config.Name] = registeredProvider{provider: provider, config: config}
+
+	return nil
+}
+
+func (r *providerRegistryImpl) AddRoute(route Route) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.providers[route.Provider]; !ok {
+		return fmt.Errorf("route to unknown provider %s", route.Provider)
+	}
+
+	r.routes = append(r.routes, route)
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return r.routes[i].Priority < r.routes[j].Priority
+	})
+
+	return nil
+}
+
+func (r *providerRegistryImpl) Provider(name string) (Provider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	registered, ok := r.providers[name]
+	if !ok || registered.config.Disabled {
+		return nil, fmt.Errorf("provider %s: %w", name, ErrNoProvider)
+	}
+
+	return registered.provider, nil
+}
+
+func (r *providerRegistryImpl) Route(direction pb.Direction, currency string, capability Capability) (Provider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, route := range r.routes {
+		if route.Direction != direction {
+			continue
+		}
+
+		if route.Currency != "" && !strings.EqualFold(route.Currency, currency) {
+			continue
+		}
+
+		registered := r.providers[route.Provider]
+		if registered.config.Disabled || !supportsCurrency(registered.config, currency) || !HasCapability(registered.provider, capability) {
+			continue
+		}
+
+		return registered.provider, nil
+	}
+
+	return nil, fmt.Errorf("%s %s in %s: %w", capability, direction, currency, ErrNoProvider)
+}
+
+func (r *providerRegistryImpl) Providers() []ProviderInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var infos []ProviderInfo
+	for name, registered := range r.providers {
+		infos = append(infos, ProviderInfo{
+			Name:         name,
+			Kind:         registered.config.Kind,
+			Disabled:     registered.config.Disabled,
+			Currencies:   registered.config.Currencies,
+			Capabilities: registered.provider.Capabilities(),
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos
+}
+
+func (r *providerRegistryImpl) Routes() []Route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]Route(nil), r.routes...)
+}
+
+// supportsCurrency treats a provider without configured currencies as
+// accepting any.
+func supportsCurrency(config ProviderConfig, currency string) bool {
+	if len(config.Currencies) == 0 {
+		return true
+	}
+
+	for _, c := range config.Currencies {
+		if strings.EqualFold(c, currency) {
+			return true
+		}
+	}
+
+	return false
+}
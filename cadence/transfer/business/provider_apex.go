@@ -0,0 +1,117 @@
+package business
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/apex"
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"context"
+	"fmt"
+	"time"
+)
+
+const ProviderKindApex = "apex"
+
+var apexPaymentStatuses = map[pb.ApexStatus]PaymentStatus{
+	pb.ApexStatus_Requested:   PaymentPending,
+	pb.ApexStatus_Postponed:   PaymentPending,
+	pb.ApexStatus_Concluded:   PaymentCompleted,
+	pb.ApexStatus_Fundsposted: PaymentCompleted,
+	pb.ApexStatus_Canceled:    PaymentCanceled,
+}
+
+type apexProviderImpl struct {
+	name   string
+	client apex.WithdrawClient
+}
+
+// NewApexProvider adapts the Apex withdraw API. Apex only takes
+// withdrawals in USD.
+func NewApexProvider(name string, client apex.WithdrawClient) Provider {
+	return &apexProviderImpl{name: name, client: client}
+}
+
+// ApexProviderFactory builds Apex providers from the base_url, key, secret
+// and timeout settings. Their responses are published on the broker, which
+// is how Apex statuses reach the workflow.
+func ApexProviderFactory(b broker.Broker) ProviderFactory {
+	return func(config ProviderConfig) (Provider, error) {
+		if config.Settings["base_url"] == "" {
+			return nil, fmt.Errorf("apex needs a base_url setting")
+		}
+
+		var timeout time.Duration
+		if s := config.Settings["timeout"]; s != "" {
+			var err error
+			timeout, err = time.ParseDuration(s)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		client := apex.NewHTTPWithdrawClient(apex.Config{
+			BaseURL: config.Settings["base_url"],
+			KeyID:   config.Settings["key"],
+			Secret:  config.Settings["secret"],
+			Timeout: timeout,
+		})
+
+		return NewApexProvider(config.Name, apexServiceClient{ApexBinService(b, client)}), nil
+	}
+}
+
+func (p *apexProviderImpl) Name() string {
+	return p.name
+}
+
+func (p *apexProviderImpl) Capabilities() []Capability {
+	return []Capability{CapabilityWithdraw, CapabilityStatus, CapabilityCancel}
+}
+
+func (p *apexProviderImpl) Withdraw(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	if req.Currency != "" && req.Currency != CurrencyUSD {
+		return nil, fmt.Errorf("apex only withdraws %s, not %s", CurrencyUSD, req.Currency)
+	}
+
+	resp, err := p.client.RequestWithdraw(ctx, &pb.ApexWithdrawMessage{
+		Amount:      req.Amount,
+		ApexAccId:   req.AccountID,
+		ExecutionId: req.ExecutionID,
+		Direction:   req.Direction,
+	})
+
+	if apex.IsCode(err, apex.ErrorDuplicate) {
+		return p.Status(ctx, req.ExecutionID)
+	}
+
+	return p.result(resp, err)
+}
+
+func (p *apexProviderImpl) Deposit(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	return nil, ErrUnsupportedCapability
+}
+
+func (p *apexProviderImpl) Status(ctx context.Context, executionID string) (*PaymentResult, error) {
+	return p.result(p.client.GetWithdrawStatus(ctx, executionID))
+}
+
+func (p *apexProviderImpl) Cancel(ctx context.Context, executionID string) (*PaymentResult, error) {
+	return p.result(p.client.CancelWithdraw(ctx, executionID))
+}
+
+func (p *apexProviderImpl) result(resp *pb.ApexWithdrawResponse, err error) (*PaymentResult, error) {
+	switch {
+	case apex.IsCode(err, apex.ErrorNotFound):
+		return nil, fmt.Errorf("%v: %w", err, ErrPaymentNotFound)
+	case apex.IsCode(err, apex.ErrorNotCancelable):
+		return nil, fmt.Errorf("%v: %w", err, ErrPaymentNotCancelable)
+	case err != nil:
+		return nil, err
+	}
+
+	return &PaymentResult{
+		ExecutionID:    resp.ExecutionId,
+		Provider:       p.name,
+		Status:         apexPaymentStatuses[resp.Status],
+		ProviderStatus: resp.Status.String(),
+	}, nil
+}
@@ -0,0 +1,43 @@
+package business_test
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/apex"
+	"avenuesec/workflow-poc/cadence/transfer/broker/memory"
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"avenuesec/workflow-poc/cadence/transfer/business/providertest"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestApexProviderConformance(t *testing.T) {
+	stub := httptest.NewServer(apex.NewStubHandler(apex.Signer{
+		KeyID:   "test",
+		Secret:  []byte("secret"),
+		MaxSkew: time.Minute,
+	}))
+	defer stub.Close()
+
+	provider, err := business.ApexProviderFactory(memory.NewBroker())(business.ProviderConfig{
+		Name: "apex",
+		Kind: business.ProviderKindApex,
+		Settings: map[string]string{
+			"base_url": stub.URL,
+			"key":      "test",
+			"secret":   "secret",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providertest.Run(t, provider, providertest.Fixture{
+		Request: business.PaymentRequest{
+			AccountID: "apex_conformance",
+			Amount:    125.50,
+			Currency:  business.CurrencyUSD,
+			Direction: pb.Direction_SdToBank,
+		},
+	})
+}
@@ -0,0 +1,84 @@
+package business
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/resilience"
+	"context"
+	"errors"
+)
+
+type resilientProviderImpl struct {
+	Provider
+	policy resilience.Policy
+}
+
+// NewResilientProvider guards every call to a provider with its policy.
+func NewResilientProvider(provider Provider, policy resilience.Policy) Provider {
+	return &resilientProviderImpl{Provider: provider, policy: policy}
+}
+
+// ResilientProviderFactory wraps the providers a factory builds with the
+// group's policy of the same name.
+func ResilientProviderFactory(factory ProviderFactory, group resilience.Group) ProviderFactory {
+	return func(config ProviderConfig) (Provider, error) {
+		provider, err := factory(config)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewResilientProvider(provider, group.Policy(config.Name)), nil
+	}
+}
+
+func (p *resilientProviderImpl) Withdraw(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	return p.execute(ctx, "withdraw", func(ctx context.Context) (*PaymentResult, error) {
+		return p.Provider.Withdraw(ctx, req)
+	})
+}
+
+func (p *resilientProviderImpl) Deposit(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	return p.execute(ctx, "deposit", func(ctx context.Context) (*PaymentResult, error) {
+		return p.Provider.Deposit(ctx, req)
+	})
+}
+
+func (p *resilientProviderImpl) Status(ctx context.Context, executionID string) (*PaymentResult, error) {
+	return p.execute(ctx, "status", func(ctx context.Context) (*PaymentResult, error) {
+		return p.Provider.Status(ctx, executionID)
+	})
+}
+
+func (p *resilientProviderImpl) Cancel(ctx context.Context, executionID string) (*PaymentResult, error) {
+	return p.execute(ctx, "cancel", func(ctx context.Context) (*PaymentResult, error) {
+		return p.Provider.Cancel(ctx, executionID)
+	})
+}
+
+func (p *resilientProviderImpl) execute(ctx context.Context, operation string, call func(ctx context.Context) (*PaymentResult, error)) (*PaymentResult, error) {
+	var result *PaymentResult
+
+	err := p.policy.Execute(ctx, operation, func(ctx context.Context) error {
+		var err error
+		result, err = call(ctx)
+
+		if err != nil && !providerFault(err) {
+			return resilience.ClientError(err)
+		}
+
+		return err
+	})
+
+	return result, err
+}
+
+// providerFault tells whether an error says the provider is unwell rather
+// than that it turned the request down.
+func providerFault(err error) bool {
+	var retryable interface{ Retryable() bool }
+	if errors.As(err, &retryable) {
+		return retryable.Retryable()
+	}
+
+	return !errors.Is(err, ErrUnsupportedCapability) &&
+		!errors.Is(err, ErrPaymentNotFound) &&
+		!errors.Is(err, ErrPaymentNotCancelable)
+}
@@ -0,0 +1,68 @@
+package business
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"context"
+)
+
+const ProviderKindSimulator = "simulator"
+
+type simulatorProviderImpl struct {
+	name   string
+	broker broker.Broker
+}
+
+// NewSimulatorProvider publishes withdrawals for the Apex simulator, which
+// answers them on the broker as Apex would. It is the provider of local
+// runs without an Apex sandbox.
+func NewSimulatorProvider(name string, b broker.Broker) Provider {
+	return &simulatorProviderImpl{name: name, broker: b}
+}
+
+// SimulatorProviderFactory builds simulator providers. They take no
+// settings.
+func SimulatorProviderFactory(b broker.Broker) ProviderFactory {
+	return func(config ProviderConfig) (Provider, error) {
+		return NewSimulatorProvider(config.Name, b), nil
+	}
+}
+
+func (p *simulatorProviderImpl) Name() string {
+	return p.name
+}
+
+func (p *simulatorProviderImpl) Capabilities() []Capability {
+	return []Capability{CapabilityWithdraw}
+}
+
+func (p *simulatorProviderImpl) Withdraw(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	err := broker.ProduceStruct(ctx, p.broker, &pb.ApexWithdrawMessage{
+		Amount:      req.Amount,
+		ApexAccId:   req.AccountID,
+		ExecutionId: req.ExecutionID,
+		Direction:   req.Direction,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaymentResult{
+		ExecutionID:    req.ExecutionID,
+		Provider:       p.name,
+		Status:         PaymentPending,
+		ProviderStatus: pb.ApexStatus_Requested.String(),
+	}, nil
+}
+
+func (p *simulatorProviderImpl) Deposit(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	return nil, ErrUnsupportedCapability
+}
+
+func (p *simulatorProviderImpl) Status(ctx context.Context, executionID string) (*PaymentResult, error) {
+	return nil, ErrUnsupportedCapability
+}
+
+func (p *simulatorProviderImpl) Cancel(ctx context.Context, executionID string) (*PaymentResult, error) {
+	return nil, ErrUnsupportedCapability
+}
@@ -0,0 +1,201 @@
+// Package providertest is the conformance kit for payment provider
+// adapters. Run it against a sandbox or stub of the partner before routing
+// real payments to a new adapter.
+package providertest
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pborman/uuid"
+)
+
+// Fixture is the payment the kit sends. ExecutionID is replaced by a fresh
+// id on every run.
+type Fixture struct {
+	Request business.PaymentRequest
+}
+
+// TestingT is the part of *testing.T the kit needs.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Run reports every conformance failure to t.
+func Run(t TestingT, provider business.Provider, fixture Fixture) {
+	t.Helper()
+
+	for _, err := range Check(context.Background(), provider, fixture) {
+		t.Errorf("%s: %v", provider.Name(), err)
+	}
+}
+
+// Check runs the kit and returns what failed, nothing when the provider
+// conforms.
+func Check(ctx context.Context, provider business.Provider, fixture Fixture) []error {
+	c := &checker{ctx: ctx, provider: provider, fixture: fixture}
+
+	c.declaration()
+	c.unsupported()
+	c.payment(business.CapabilityWithdraw, provider.Withdraw)
+	c.payment(business.CapabilityDeposit, provider.Deposit)
+	c.unknown()
+
+	return c.errs
+}
+
+type checker struct {
+	ctx      context.Context
+	provider business.Provider
+	fixture  Fixture
+	errs     []error
+}
+
+func (c *checker) fail(format string, args ...interface{}) {
+	c.errs = append(c.errs, fmt.Errorf(format, args...))
+}
+
+func (c *checker) request() business.PaymentRequest {
+	req := c.fixture.Request
+	req.ExecutionID = "conformance_" + strings.Replace(uuid.New(), "-", "", -1)
+
+	return req
+}
+
+func (c *checker) declaration() {
+	if c.provider.Name() == "" {
+		c.fail("provider has no name")
+	}
+
+	if len(c.provider.Capabilities()) == 0 {
+		c.fail("provider declares no capabilities")
+	}
+
+	for _, declared := range c.provider.Capabilities() {
+		known := false
+		for _, capability := range business.Capabilities {
+			known = known || declared == capability
+		}
+
+		if !known {
+			c.fail("unknown capability %q", declared)
+		}
+	}
+}
+
+// unsupported checks that undeclared operations refuse with
+// ErrUnsupportedCapability instead of doing something.
+func (c *checker) unsupported() {
+	calls := map[business.Capability]func() error{
+		business.CapabilityWithdraw: func() error {
+			_, err := c.provider.Withdraw(c.ctx, c.request())
+			return err
+		},
+		business.CapabilityDeposit: func() error {
+			_, err := c.provider.Deposit(c.ctx, c.request())
+			return err
+		},
+		business.CapabilityStatus: func() error {
+			_, err := c.provider.Status(c.ctx, c.request().ExecutionID)
+			return err
+		},
+		business.CapabilityCancel: func() error {
+			_, err := c.provider.Cancel(c.ctx, c.request().ExecutionID)
+			return err
+		},
+	}
+
+	for capability, call := range calls {
+		if business.HasCapability(c.provider, capability) {
+			continue
+		}
+
+		if err := call(); !errors.Is(err, business.ErrUnsupportedCapability) {
+			c.fail("%s is not declared but returned %v instead of ErrUnsupportedCapability", capability, err)
+		}
+	}
+}
+
+// payment sends the fixture through a money-moving operation and checks
+// the result, idempotency, status and cancel on it.
+func (c *checker) payment(capability business.Capability, call func(context.Context, business.PaymentRequest) (*business.PaymentResult, error)) {
+	if !business.HasCapability(c.provider, capability) {
+		return
+	}
+
+	req := c.request()
+
+	first, err := call(c.ctx, req)
+	if err != nil {
+		c.fail("%s: %v", capability, err)
+		return
+	}
+
+	c.result(string(capability), req.ExecutionID, first)
+
+	second, err := call(c.ctx, req)
+	if err != nil {
+		c.fail("%s is not idempotent, repeating it failed: %v", capability, err)
+	} else {
+		c.result(string(capability)+" repeated", req.ExecutionID, second)
+	}
+
+	if business.HasCapability(c.provider, business.CapabilityStatus) {
+		status, err := c.provider.Status(c.ctx, req.ExecutionID)
+		if err != nil {
+			c.fail("status after %s: %v", capability, err)
+		} else {
+			c.result("status after "+string(capability), req.ExecutionID, status)
+		}
+	}
+
+	if business.HasCapability(c.provider, business.CapabilityCancel) {
+		canceled, err := c.provider.Cancel(c.ctx, req.ExecutionID)
+		switch {
+		case errors.Is(err, business.ErrPaymentNotCancelable):
+		case err != nil:
+			c.fail("cancel after %s returned %v instead of a result or ErrPaymentNotCancelable", capability, err)
+		case canceled.Status != business.PaymentCanceled:
+			c.fail("cancel after %s left the payment %s", capability, canceled.Status)
+		}
+	}
+}
+
+func (c *checker) result(operation, executionID string, result *business.PaymentResult) {
+	if result == nil {
+		c.fail("%s returned no result", operation)
+		return
+	}
+
+	if result.ExecutionID != executionID {
+		c.fail("%s returned execution id %q, want %q", operation, result.ExecutionID, executionID)
+	}
+
+	switch result.Status {
+	case business.PaymentPending, business.PaymentCompleted, business.PaymentCanceled, business.PaymentFailed:
+	default:
+		c.fail("%s returned unknown status %q", operation, result.Status)
+	}
+}
+
+// unknown checks that payments the provider never saw are reported as
+// ErrPaymentNotFound.
+func (c *checker) unknown() {
+	id := c.request().ExecutionID
+
+	if business.HasCapability(c.provider, business.CapabilityStatus) {
+		if _, err := c.provider.Status(c.ctx, id); !errors.Is(err, business.ErrPaymentNotFound) {
+			c.fail("status of an unknown payment returned %v instead of ErrPaymentNotFound", err)
+		}
+	}
+
+	if business.HasCapability(c.provider, business.CapabilityCancel) {
+		if _, err := c.provider.Cancel(c.ctx, id); !errors.Is(err, business.ErrPaymentNotFound) {
+			c.fail("cancel of an unknown payment returned %v instead of ErrPaymentNotFound", err)
+		}
+	}
+}
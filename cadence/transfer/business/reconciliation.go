@@ -0,0 +1,145 @@
+package business
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"avenuesec/workflow-poc/cadence/transfer/reconciliation"
+	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"context"
+	"math"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
+	"go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	ReconciliationWorkflowName = "dailyReconciliationWorkflow"
+	// ReconciliationWorkflowID is the single cron execution reconciling
+	// the settlement files.
+	ReconciliationWorkflowID = "daily_reconciliation"
+)
+
+type ReconciliationService interface {
+	// StartDaily schedules the reconciliation workflow on a cron schedule
+	// such as "0 6 * * *". It is a no-op when it is already scheduled.
+	StartDaily(ctx context.Context, schedule string) error
+}
+
+type reconciliationServiceImpl struct {
+	wf     workflowserviceclient.Interface
+	logger *zap.SugaredLogger
+	domain string
+}
+
+func NewReconciliationService(wf workflowserviceclient.Interface, domain string) ReconciliationService {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("reconciliation_service")
+
+	return &reconciliationServiceImpl{
+		wf:     wf,
+		domain: domain,
+		logger: logger.Sugar(),
+	}
+}
+
+func (s *reconciliationServiceImpl) StartDaily(ctx context.Context, schedule string) error {
+	workflowOptions := client.StartWorkflowOptions{
+		ID:                              ReconciliationWorkflowID,
+		TaskList:                        SdToBankApplicationName,
+		ExecutionStartToCloseTimeout:    time.Hour,
+		DecisionTaskStartToCloseTimeout: time.Minute,
+		CronSchedule:                    schedule,
+	}
+
+	var workflowClient client.Client = client.NewClient(
+		s.wf, s.domain, &client.Options{Identity: "local-mac-vinny", MetricsScope: tally.NoopScope, ContextPropagators: []workflow.ContextPropagator{broker.NewEnvelopePropagator()}})
+
+	we, err := workflowClient.StartWorkflow(ctx, workflowOptions, ReconciliationWorkflowName)
+	if _, ok := err.(*shared.WorkflowExecutionAlreadyStartedError); ok {
+		s.logger.Info("Reconciliation already scheduled", zap.String("WorkflowID", ReconciliationWorkflowID))
+		return nil
+	}
+
+	if err != nil {
+		s.logger.Error("Failed to schedule reconciliation", zap.Error(err))
+		return err
+	}
+
+	s.logger.Info("Scheduled reconciliation", zap.String("WorkflowID", we.ID), zap.String("RunID", we.RunID), zap.String("Schedule", schedule))
+
+	return nil
+}
+
+type reconciliationLedgerImpl struct {
+	redis redis.RedisConnection
+}
+
+// NewReconciliationLedger is our side of the settlement reconciliation: the
+// SdToBank transfers kept in Redis, indexed by the day they started.
+func NewReconciliationLedger(redis redis.RedisConnection) reconciliation.Ledger {
+	return &reconciliationLedgerImpl{redis: redis}
+}
+
+// TransfersOn only lists transfers whose withdrawal was sent, which is done
+// by the time they are blocked. The others never reach Apex.
+func (l *reconciliationLedgerImpl) TransfersOn(ctx context.Context, date string) ([]reconciliation.Transfer, error) {
+	ids, err := l.redis.GetConn().SMembers(ctx, transferDayKey(date)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := make([]reconciliation.Transfer, 0, len(ids))
+
+	for _, id := range ids {
+		msg, err := loadTransfer(ctx, l.redis, id)
+		if err == ErrTransferNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if withdrawalSent(msg.Status) {
+			transfers = append(transfers, reconciliationTransfer(msg))
+		}
+	}
+
+	return transfers, nil
+}
+
+func (l *reconciliationLedgerImpl) Transfer(ctx context.Context, executionID string) (reconciliation.Transfer, bool, error) {
+	msg, err := loadTransfer(ctx, l.redis, executionID)
+	if err == ErrTransferNotFound {
+		return reconciliation.Transfer{}, false, nil
+	}
+
+	if err != nil {
+		return reconciliation.Transfer{}, false, err
+	}
+
+	return reconciliationTransfer(msg), true, nil
+}
+
+func reconciliationTransfer(msg *pb.Transfer) reconciliation.Transfer {
+	return reconciliation.Transfer{
+		ExecutionID: msg.ExecutionId,
+		AmountCents: int64(math.Round(msg.Amount * 100)),
+	}
+}
+
+// withdrawalSent tells whether a transfer in the status asked Apex for its
+// withdrawal.
+func withdrawalSent(status string) bool {
+	switch status {
+	case TransferStatusBlocked, TransferStatusDebited, TransferStatusCreditQueued, TransferStatusCompleted, TransferStatusReturned:
+		return true
+	}
+
+	return false
+}
@@ -1,16 +1,24 @@
 package business
 
 import (
+	"avenuesec/workflow-poc/cadence/transfer/apex"
+	"avenuesec/workflow-poc/cadence/transfer/broker"
 	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
-	"avenuesec/workflow-poc/cadence/transfer/rabbitmq"
+	"avenuesec/workflow-poc/cadence/transfer/iso20022"
 	"avenuesec/workflow-poc/cadence/transfer/redis"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	goredis "github.com/go-redis/redis/v8"
 	"github.com/pborman/uuid"
 	"github.com/uber-go/tally"
 	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
+	"go.uber.org/cadence/.gen/go/shared"
 	"go.uber.org/cadence/client"
 	"go.uber.org/cadence/workflow"
 	"go.uber.org/zap"
@@ -19,6 +27,33 @@ import (
 
 type SignalTrigger string
 
+// Transfer statuses, as kept with the transfer and in the TransferStatus
+// search attribute.
+const (
+	TransferStatusStarting     = "starting"
+	TransferStatusValidated    = "validated"
+	TransferStatusBlocked      = "blocked"
+	TransferStatusDebited      = "debited"
+	TransferStatusCreditQueued = "credit_queued"
+	TransferStatusCompleted    = "completed"
+	TransferStatusReturned     = "returned"
+	TransferStatusFailed       = "failed"
+	TransferStatusCanceled     = "canceled"
+	// TransferStatusTerminated is set by operators terminating a stuck
+	// workflow.
+	TransferStatusTerminated = "terminated"
+)
+
+// IsFinalTransferStatus tells whether a transfer in the status is done.
+func IsFinalTransferStatus(status string) bool {
+	switch status {
+	case TransferStatusCompleted, TransferStatusReturned, TransferStatusFailed, TransferStatusCanceled, TransferStatusTerminated:
+		return true
+	}
+
+	return false
+}
+
 const (
 	SdToBankSignalStartValidate     SignalTrigger = "trigger-sdtobank-start-validate"
 	SdToBankSignalStartBlock        SignalTrigger = "trigger-sdtobank-start-block"
@@ -26,32 +61,143 @@ const (
 	SdToBankSignalStartUnblockDebit SignalTrigger = "trigger-sdtobank-start-unblock-debit"
 	SdToBankSignalStartCredit       SignalTrigger = "trigger-sdtobank-start-credit"
 	SdToBankSignalDone              SignalTrigger = "trigger-sdtobank-done"
+	SdToBankSignalBankReturned      SignalTrigger = "trigger-sdtobank-bank-returned"
+	SdToBankSignalCancel            SignalTrigger = "trigger-sdtobank-cancel"
+	// SdToBankSignalWithdrawCanceled tells the workflow the provider
+	// canceled the withdrawal, so the blocked funds go back.
+	SdToBankSignalWithdrawCanceled SignalTrigger = "trigger-sdtobank-withdraw-canceled"
 
 	SdToBankApplicationName = "sdToBankTransferGroup"
 	SdToBankWorkflowName    = "sdToBankTransferWorkflow"
 	SdToBankSignalName      = "sdToBankSignal"
+	// SdToBankEntryAckSignalName carries the MoneyBin EntryAck of each
+	// ledger entry the workflow posted.
+	SdToBankEntryAckSignalName = "sdToBankEntryAck"
+
+	sdToBankExecutionPrefix = "sdtobank_"
+	// endToEndPrefix marks the end to end ids of our bank payments.
+	endToEndPrefix = "SB"
+
+	// SdToBankExecutionTimeout bounds a transfer workflow. Validated
+	// transfers wait for an approver, and Apex and the bank take business
+	// days to settle, so it spans a long weekend on top of a working week.
+	// It must also stay well above the five minutes the workflow backs off
+	// for an unavailable provider.
+	SdToBankExecutionTimeout = 10 * 24 * time.Hour
+
+	transferTTL = 1000 * time.Hour
+	transferDay = "2006-01-02"
+)
+
+var (
+	ErrTransferNotFound = NewError(CodeTransferNotFound, "transfer not found")
+	// ErrTransferState is returned when a transfer is asked to do
+	// something its status does not allow.
+	ErrTransferState = NewError(CodeTransferState, "transfer status does not allow it")
+	// ErrIdempotencyKeyReused is returned when an idempotency key comes
+	// back with a different transfer than the one it was first used for.
+	ErrIdempotencyKeyReused = NewError(CodeIdempotencyKeyReused, "idempotency key already used for another transfer")
 )
 
+// NewSdToBankExecutionID creates the id of a new SdToBank workflow. It is
+// also the correlation id of every message about that transfer.
+func NewSdToBankExecutionID() string {
+	return sdToBankExecutionPrefix + uuid.New()
+}
+
+// idempotencyNamespace names the execution ids derived from idempotency
+// keys.
+var idempotencyNamespace = uuid.Parse("5d0a1e52-8f3c-4c1e-9a57-2b6a3f0c7e11")
+
+// IdempotentExecutionID derives the execution id of the transfer a caller
+// creates with an idempotency key, so that retries of the request find the
+// transfer the first one started. Keys are scoped by caller.
+func IdempotentExecutionID(subject, key string) string {
+	return sdToBankExecutionPrefix + uuid.NewSHA1(idempotencyNamespace, []byte(subject+"\n"+key)).String()
+}
+
+// SameTransfer tells whether transfer is the one message asks for, so a
+// request repeating an idempotency key can be told from one reusing it.
+func SameTransfer(message *pb.NewTransferMessage, transfer *pb.Transfer) bool {
+	return transfer.AccId == message.AccId && transfer.Amount == message.Amount && transfer.Direction == message.Direction
+}
+
+// messageNamespace names the execution ids derived from broker message ids.
+var messageNamespace = uuid.Parse("9b3e7c40-2d51-4f8a-b6e9-0c4d7a1f5e23")
+
+// messageExecutionID derives the execution id of the transfer a broker
+// message starts. Message ids need not be UUIDs, so they are hashed into
+// one.
+func messageExecutionID(messageID string) string {
+	return sdToBankExecutionPrefix + uuid.NewSHA1(messageNamespace, []byte(messageID)).String()
+}
+
+// EndToEndID is the id the bank payment of a transfer carries. ISO 20022
+// caps it at 35 characters, fewer than an execution id has, so it is the
+// uuid of the execution id in hex; ExecutionIDFromEndToEndID reverses it.
+func EndToEndID(executionID string) (string, error) {
+	id := uuid.Parse(strings.TrimPrefix(executionID, sdToBankExecutionPrefix))
+	if id == nil || !strings.HasPrefix(executionID, sdToBankExecutionPrefix) {
+		return "", fmt.Errorf("execution id %q does not end in a uuid", executionID)
+	}
+
+	return endToEndPrefix + strings.ToUpper(hex.EncodeToString(id)), nil
+}
+
+// ExecutionIDFromEndToEndID finds the execution id of a bank payment. ok is
+// false for end to end ids that are not ours.
+func ExecutionIDFromEndToEndID(endToEndID string) (string, bool) {
+	if !strings.HasPrefix(endToEndID, endToEndPrefix) {
+		return "", false
+	}
+
+	id, err := hex.DecodeString(strings.TrimPrefix(endToEndID, endToEndPrefix))
+	if err != nil || len(id) != 16 {
+		return "", false
+	}
+
+	return sdToBankExecutionPrefix + uuid.UUID(id).String(), true
+}
+
 type SdToBankService interface {
-	StartTransfer(ctx context.Context, message *pb.NewTransferMessage) error
+	// StartTransfer starts the transfer workflow and returns its execution
+	// id, which is also the transfer id.
+	StartTransfer(ctx context.Context, message *pb.NewTransferMessage) (string, error)
 	Block(ctx context.Context, message *pb.Transfer) error
+	// Approve lets a validated transfer go on to block the funds.
+	Approve(ctx context.Context, workflowID string) error
+	// Cancel stops a transfer before any funds are blocked.
+	Cancel(ctx context.Context, workflowID, reason string) error
 	GetTransferInformation(ctx context.Context, workflowID string) (*pb.Transfer, error)
+	SetTransferStatus(ctx context.Context, workflowID, status string) error
+	// ApexStatusChanged moves the workflow on when its Apex withdrawal
+	// settles. It is an apex.EventHandler.
+	ApexStatusChanged(ctx context.Context, event apex.StatusEvent) error
+	EntryAcknowledged(ctx context.Context, ack *pb.EntryAck) error
+	// BankStatusChanged finishes the workflow once the bank books its
+	// payment, or credits the ledger back and fails it when the bank
+	// returns it. It is an iso20022.StatusHandler.
+	BankStatusChanged(ctx context.Context, update iso20022.StatusUpdate) error
+	// CreditReturned fails the workflow when the bank returns its ACH
+	// credit, or marks the transfer returned when the workflow already
+	// finished. It is an ach.ReturnHandler.
+	CreditReturned(ctx context.Context, executionID, code string) error
 }
 
 type sdToBankServiceImpl struct {
-	rabbit rabbitmq.AmqpConnection
+	broker broker.Broker
 	redis  redis.RedisConnection
 	wf     workflowserviceclient.Interface
 	logger *zap.SugaredLogger
 	domain string
 }
 
-func NewSdToBankService(rabbit rabbitmq.AmqpConnection, redis redis.RedisConnection, wf workflowserviceclient.Interface, domain string) SdToBankService {
+func NewSdToBankService(broker broker.Broker, redis redis.RedisConnection, wf workflowserviceclient.Interface, domain string) SdToBankService {
 	logger, _ := zap.NewProduction()
 	logger = logger.Named("sdtobank_service")
 
 	return &sdToBankServiceImpl{
-		rabbit: rabbit,
+		broker: broker,
 		redis:  redis,
 		wf:     wf,
 		domain: domain,
@@ -59,41 +205,242 @@ func NewSdToBankService(rabbit rabbitmq.AmqpConnection, redis redis.RedisConnect
 	}
 }
 
-func (s *sdToBankServiceImpl) StartTransfer(ctx context.Context, message *pb.NewTransferMessage) error {
+func (s *sdToBankServiceImpl) StartTransfer(ctx context.Context, message *pb.NewTransferMessage) (string, error) {
+	// The HTTP handler picks the execution id up front and sends it as the
+	// correlation id, so the caller can follow the transfer from the start.
+	// Other producers get an id derived from the message id. Either way a
+	// redelivered message maps to the same workflow id, which is never
+	// started again, not even after its run failed or was terminated.
+	executionID := NewSdToBankExecutionID()
+	if envelope, ok := broker.EnvelopeFromContext(ctx); ok {
+		if strings.HasPrefix(envelope.CorrelationID, sdToBankExecutionPrefix) {
+			executionID = envelope.CorrelationID
+		} else if envelope.MessageID != "" {
+			executionID = messageExecutionID(envelope.MessageID)
+		}
+	}
+
+	// The transfer is stored before its workflow starts, so a redelivery
+	// after a failure anywhere below finds it and finishes the job.
+	transfer, err := s.newTransfer(ctx, message, executionID)
+	if err != nil {
+		s.logger.Error("Failed to store transfer", zap.Error(err))
+		return "", err
+	}
+
 	workflowOptions := client.StartWorkflowOptions{
-		ID:                              "sdtobank_" + uuid.New(),
+		ID:                              executionID,
 		TaskList:                        SdToBankApplicationName,
-		ExecutionStartToCloseTimeout:    time.Minute,
+		ExecutionStartToCloseTimeout:    SdToBankExecutionTimeout,
 		DecisionTaskStartToCloseTimeout: time.Minute,
+		WorkflowIDReusePolicy:           client.WorkflowIDReusePolicyRejectDuplicate,
 	}
 
 	var workflowClient client.Client = client.NewClient(
-		s.wf, s.domain, &client.Options{Identity: "local-mac-vinny", MetricsScope: tally.NoopScope, ContextPropagators: []workflow.ContextPropagator{}})
+		s.wf, s.domain, &client.Options{Identity: "local-mac-vinny", MetricsScope: tally.NoopScope, ContextPropagators: []workflow.ContextPropagator{broker.NewEnvelopePropagator()}})
 
 	we, err := workflowClient.StartWorkflow(ctx, workflowOptions, SdToBankWorkflowName, "SdToBank")
-	if err != nil {
-		s.logger.Error("Failed to create SdToBankWorkflow", zap.Error(err))
-		panic("Failed to create SdToBankWorkflow.")
-	} else {
+	switch err.(type) {
+	case nil:
 		s.logger.Info("Started SdToBankWorkflow", zap.String("WorkflowID", we.ID), zap.String("RunID", we.RunID))
+	case *shared.WorkflowExecutionAlreadyStartedError:
+		s.logger.Info("SdToBankWorkflow already started", zap.String("WorkflowID", executionID))
+	default:
+		s.logger.Error("Failed to create SdToBankWorkflow", zap.Error(err))
+		return "", err
 	}
 
-	// store transfer message information
-	err = s.newTransfer(ctx, message, we.ID)
-	if err != nil {
-		s.logger.Error("Failed to store transfer", zap.Error(err))
-		return workflowClient.CancelWorkflow(ctx, we.ID, we.RunID)
+	// An earlier attempt may have died before signaling. Once the workflow
+	// moved on, the signal it got is not sent again.
+	if transfer.Status != TransferStatusStarting {
+		return executionID, nil
 	}
 
-	return s.sendSignal(ctx, we.ID, string(SdToBankSignalStartValidate))
+	return executionID, s.sendSignal(ctx, executionID, string(SdToBankSignalStartValidate))
 }
 
 func (s *sdToBankServiceImpl) Block(ctx context.Context, message *pb.Transfer) error {
 	return s.sendSignal(ctx, message.ExecutionId, string(SdToBankSignalStartBlock))
 }
 
+func (s *sdToBankServiceImpl) Approve(ctx context.Context, workflowID string) error {
+	transfer, err := s.GetTransferInformation(ctx, workflowID)
+	if err != nil {
+		return err
+	}
+
+	if transfer.Status != TransferStatusValidated {
+		return fmt.Errorf("approve %s transfer: %w", transfer.Status, ErrTransferState)
+	}
+
+	return s.Block(ctx, transfer)
+}
+
+func (s *sdToBankServiceImpl) Cancel(ctx context.Context, workflowID, reason string) error {
+	transfer, err := s.GetTransferInformation(ctx, workflowID)
+	if err != nil {
+		return err
+	}
+
+	if transfer.Status != TransferStatusStarting && transfer.Status != TransferStatusValidated {
+		return fmt.Errorf("cancel %s transfer: %w", transfer.Status, ErrTransferState)
+	}
+
+	s.logger.Info("Canceling transfer", zap.String("WorkflowID", workflowID), zap.String("Reason", reason))
+
+	return s.sendSignal(ctx, workflowID, string(SdToBankSignalCancel))
+}
+
+func (s *sdToBankServiceImpl) ApexStatusChanged(ctx context.Context, event apex.StatusEvent) error {
+	switch event.To {
+	case pb.ApexStatus_Concluded, pb.ApexStatus_Fundsposted:
+		return s.sendSignal(ctx, event.ExecutionID, string(SdToBankSignalStartUnblockDebit))
+	case pb.ApexStatus_Canceled:
+		s.logger.Warn("Apex canceled withdrawal", zap.String("WorkflowID", event.ExecutionID))
+		return s.sendSignal(ctx, event.ExecutionID, string(SdToBankSignalWithdrawCanceled))
+	default:
+		s.logger.Info("Apex status has no workflow step", zap.String("WorkflowID", event.ExecutionID), zap.String("Status", event.To.String()))
+		return nil
+	}
+}
+
+func (s *sdToBankServiceImpl) EntryAcknowledged(ctx context.Context, ack *pb.EntryAck) error {
+	err := s.signal(ctx, ack.ExecutionId, SdToBankEntryAckSignalName, ack)
+
+	// Entries posted by operators, or late acks, may be for workflows that
+	// are no longer running and wait for nothing.
+	var notExists *shared.EntityNotExistsError
+	if errors.As(err, &notExists) {
+		s.logger.Info("Entry ack for a closed workflow", zap.String("WorkflowID", ack.ExecutionId), zap.String("Kind", ack.Kind.String()))
+		return nil
+	}
+
+	return err
+}
+
+func (s *sdToBankServiceImpl) BankStatusChanged(ctx context.Context, update iso20022.StatusUpdate) error {
+	// Notifications cover the whole account, not only our payments.
+	executionID, ok := ExecutionIDFromEndToEndID(update.EndToEndID)
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case update.Returned:
+		s.logger.Warn("Bank returned payment", zap.String("WorkflowID", executionID), zap.String("Reason", update.ReasonCode))
+
+		err := s.reverse(ctx, executionID)
+		if err != nil {
+			return err
+		}
+
+		return s.returned(ctx, executionID)
+	case update.Debit && update.Status == iso20022.StatusBooked:
+		return s.sendSignal(ctx, executionID, string(SdToBankSignalDone))
+	default:
+		s.logger.Info("Bank status has no workflow step", zap.String("WorkflowID", executionID), zap.String("Status", update.Status))
+		return nil
+	}
+}
+
+func (s *sdToBankServiceImpl) CreditReturned(ctx context.Context, executionID, code string) error {
+	s.logger.Warn("Bank returned ACH credit", zap.String("WorkflowID", executionID), zap.String("Code", code))
+
+	return s.returned(ctx, executionID)
+}
+
+// reverse gives the money of a returned bank payment back on the ledger,
+// as the ACH originator does for its returns. MoneyBin posts the credit
+// entry once, so a notification can be processed again.
+func (s *sdToBankServiceImpl) reverse(ctx context.Context, executionID string) error {
+	transfer, err := s.GetTransferInformation(ctx, executionID)
+	if err != nil {
+		return err
+	}
+
+	account, err := loadAccount(ctx, s.redis, transfer.AccId)
+	if err != nil {
+		return err
+	}
+
+	ctx = broker.WithCorrelationID(ctx, executionID)
+
+	return broker.ProduceStruct(ctx, s.broker, &pb.AddEntry{
+		Amount:      transfer.Amount,
+		AccId:       account.AccountUsId,
+		Kind:        pb.EntryKind_Credit,
+		ExecutionId: executionID,
+	})
+}
+
+// returned fails the workflow of a returned bank payment, or marks the
+// transfer returned when the workflow already finished.
+func (s *sdToBankServiceImpl) returned(ctx context.Context, executionID string) error {
+	err := s.sendSignal(ctx, executionID, string(SdToBankSignalBankReturned))
+
+	var notExists *shared.EntityNotExistsError
+	if errors.As(err, &notExists) {
+		return s.SetTransferStatus(ctx, executionID, TransferStatusReturned)
+	}
+
+	return err
+}
+
 func (s *sdToBankServiceImpl) GetTransferInformation(ctx context.Context, workflowID string) (*pb.Transfer, error) {
-	result, err := s.redis.GetConn().Get(ctx, fmt.Sprintf("sdtobank_%s", workflowID)).Result()
+	return loadTransfer(ctx, s.redis, workflowID)
+}
+
+func (s *sdToBankServiceImpl) SetTransferStatus(ctx context.Context, workflowID, status string) error {
+	key := transferKey(workflowID)
+
+	return s.redis.GetConn().Watch(ctx, func(tx *goredis.Tx) error {
+		result, err := tx.Get(ctx, key).Result()
+		if s.redis.NoKeyError(err) {
+			return ErrTransferNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var msg pb.Transfer
+		err = proto.Unmarshal([]byte(result), &msg)
+		if err != nil {
+			return err
+		}
+
+		if msg.Status == status {
+			return nil
+		}
+
+		msg.Status = status
+
+		str, err := proto.Marshal(&msg)
+		if err != nil {
+			return err
+		}
+
+		ttl, err := tx.TTL(ctx, key).Result()
+		if err != nil || ttl <= 0 {
+			ttl = transferTTL
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.Set(ctx, key, str, ttl)
+			publishTransferEvent(ctx, pipe, workflowID, status)
+			return nil
+		})
+
+		return err
+	}, key)
+}
+
+func loadTransfer(ctx context.Context, rd redis.RedisConnection, workflowID string) (*pb.Transfer, error) {
+	result, err := rd.GetConn().Get(ctx, transferKey(workflowID)).Result()
+	if rd.NoKeyError(err) {
+		return nil, ErrTransferNotFound
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -104,39 +451,88 @@ func (s *sdToBankServiceImpl) GetTransferInformation(ctx context.Context, workfl
 	return &msg, err
 }
 
-func (s *sdToBankServiceImpl) newTransfer(ctx context.Context, message *pb.NewTransferMessage, workflowID string) error {
+// newTransfer stores a transfer, unless one with the id is stored already,
+// and returns the stored one.
+func (s *sdToBankServiceImpl) newTransfer(ctx context.Context, message *pb.NewTransferMessage, workflowID string) (*pb.Transfer, error) {
 	transfer := &pb.Transfer{
 		Amount:      message.Amount,
 		AccId:       message.AccId,
 		ExecutionId: workflowID,
 		Direction:   message.Direction,
-		Status:      "starting",
+		Status:      TransferStatusStarting,
 	}
 
 	str, err := proto.Marshal(transfer)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	status := s.redis.GetConn().Set(ctx, fmt.Sprintf("sdtobank_%s", workflowID), str, time.Duration(1000*time.Hour))
-	if status != nil && status.Err() != nil {
-		return status.Err()
+	now := time.Now().UTC()
+
+	// Transfers are indexed by the day they start on, which is the day the
+	// daily reconciliation expects to see them settled, and by start time
+	// for listings. Index entries older than the transfers are dropped.
+	key := transferKey(workflowID)
+	dayKey := transferDayKey(now.Format(transferDay))
+	accountKey := transferAccountIndexKey(message.AccId)
+	entry := &goredis.Z{Score: float64(timeScore(now)), Member: workflowID}
+	expired := strconv.FormatInt(timeScore(now.Add(-transferTTL)), 10)
+
+	err = s.redis.GetConn().Watch(ctx, func(tx *goredis.Tx) error {
+		stored, err := tx.Get(ctx, key).Result()
+		if err == nil {
+			return proto.Unmarshal([]byte(stored), transfer)
+		}
+
+		if !s.redis.NoKeyError(err) {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.Set(ctx, key, str, transferTTL)
+			pipe.SAdd(ctx, dayKey, workflowID)
+			pipe.Expire(ctx, dayKey, transferTTL)
+			pipe.ZAdd(ctx, transferIndexKey, entry)
+			pipe.ZRemRangeByScore(ctx, transferIndexKey, "-inf", "("+expired)
+			pipe.ZAdd(ctx, accountKey, entry)
+			pipe.ZRemRangeByScore(ctx, accountKey, "-inf", "("+expired)
+			pipe.Expire(ctx, accountKey, transferTTL)
+			publishTransferEvent(ctx, pipe, workflowID, TransferStatusStarting)
+			return nil
+		})
+
+		return err
+	}, key)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return transfer, nil
 }
 
 func (s *sdToBankServiceImpl) sendSignal(ctx context.Context, executionID, text string) error {
+	return s.signal(ctx, executionID, SdToBankSignalName, text)
+}
+
+func (s *sdToBankServiceImpl) signal(ctx context.Context, executionID, signalName string, arg interface{}) error {
 	var workflowClient client.Client = client.NewClient(
-		s.wf, s.domain, &client.Options{Identity: "local-mac-vinny", MetricsScope: tally.NoopScope, ContextPropagators: []workflow.ContextPropagator{}})
+		s.wf, s.domain, &client.Options{Identity: "local-mac-vinny", MetricsScope: tally.NoopScope, ContextPropagators: []workflow.ContextPropagator{broker.NewEnvelopePropagator()}})
 
-	err := workflowClient.SignalWorkflow(ctx, executionID, "", SdToBankSignalName, text)
+	err := workflowClient.SignalWorkflow(ctx, executionID, "", signalName, arg)
 
 	if err != nil {
 		s.logger.Error("Failed to send signal", zap.Error(err))
 		return err
 	}
 
-	s.logger.Info("Signal sent", zap.String("WorkflowID", executionID), zap.String("Text", text))
+	s.logger.Info("Signal sent", zap.String("WorkflowID", executionID), zap.String("Signal", signalName), zap.Any("Arg", arg))
 	return nil
 }
+
+func transferKey(workflowID string) string {
+	return fmt.Sprintf("sdtobank_%s", workflowID)
+}
+
+func transferDayKey(day string) string {
+	return fmt.Sprintf("sdtobank_day_%s", day)
+}
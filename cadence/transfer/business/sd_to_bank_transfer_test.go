@@ -0,0 +1,32 @@
+package business_test
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"testing"
+)
+
+func TestEndToEndIDRoundTrip(t *testing.T) {
+	executionID := business.NewSdToBankExecutionID()
+
+	endToEndID, err := business.EndToEndID(executionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(endToEndID) > 35 {
+		t.Errorf("end to end id %q has %d characters, ISO 20022 allows 35", endToEndID, len(endToEndID))
+	}
+
+	got, ok := business.ExecutionIDFromEndToEndID(endToEndID)
+	if !ok || got != executionID {
+		t.Errorf("got %q, %v; want %q", got, ok, executionID)
+	}
+}
+
+func TestExecutionIDFromEndToEndIDIgnoresOthers(t *testing.T) {
+	for _, endToEndID := range []string{"", "NOTPROVIDED", "SB1234", "sdtobank_7f0c2a9e"} {
+		if _, ok := business.ExecutionIDFromEndToEndID(endToEndID); ok {
+			t.Errorf("%q was taken for one of ours", endToEndID)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package business
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// transferEventsMax is about how many events are kept per transfer.
+const transferEventsMax = 100
+
+var (
+	ErrInvalidEventID = NewError(CodeInvalidRequest, "invalid event id")
+
+	eventID = regexp.MustCompile(`^[0-9]+-[0-9]+$`)
+)
+
+// TransferEvent is a status change of a transfer. Event ids grow with
+// each change, and are what a reconnecting client resumes from.
+type TransferEvent struct {
+	ID          string    `json:"id"`
+	ExecutionID string    `json:"execution_id"`
+	Status      string    `json:"status"`
+	At          time.Time `json:"at"`
+}
+
+// TransferEvents reads the status changes the workflow activities publish
+// for each transfer.
+type TransferEvents interface {
+	// Read returns the events after lastID, or all of them when it is
+	// empty. When there are none it waits up to wait for the next one,
+	// returning none if it does not come.
+	Read(ctx context.Context, executionID, lastID string, wait time.Duration) ([]TransferEvent, error)
+}
+
+type transferEventsImpl struct {
+	redis redis.RedisConnection
+}
+
+func NewTransferEvents(redis redis.RedisConnection) TransferEvents {
+	return &transferEventsImpl{redis: redis}
+}
+
+func (e *transferEventsImpl) Read(ctx context.Context, executionID, lastID string, wait time.Duration) ([]TransferEvent, error) {
+	if lastID == "" {
+		lastID = "0"
+	} else if !eventID.MatchString(lastID) {
+		return nil, ErrInvalidEventID
+	}
+
+	// XREAD blocks forever on a zero block.
+	block := wait
+	if block <= 0 {
+		block = -1
+	}
+
+	streams, err := e.redis.GetConn().XRead(ctx, &goredis.XReadArgs{
+		Streams: []string{transferEventsKey(executionID), lastID},
+		Count:   transferEventsMax,
+		Block:   block,
+	}).Result()
+	if e.redis.NoKeyError(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var events []TransferEvent
+
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			events = append(events, transferEvent(executionID, message))
+		}
+	}
+
+	return events, nil
+}
+
+func transferEvent(executionID string, message goredis.XMessage) TransferEvent {
+	event := TransferEvent{ID: message.ID, ExecutionID: executionID}
+	event.Status, _ = message.Values["status"].(string)
+
+	at, _ := message.Values["at"].(string)
+	nanos, _ := strconv.ParseInt(at, 10, 64)
+	event.At = time.Unix(0, nanos).UTC()
+
+	return event
+}
+
+// publishTransferEvent queues a status change on the pipeline writing the
+// status, so the event is published with it.
+func publishTransferEvent(ctx context.Context, pipe goredis.Pipeliner, executionID, status string) {
+	key := transferEventsKey(executionID)
+
+	pipe.XAdd(ctx, &goredis.XAddArgs{
+		Stream:       key,
+		MaxLenApprox: transferEventsMax,
+		Values:       map[string]interface{}{"status": status, "at": time.Now().UnixNano()},
+	})
+	pipe.Expire(ctx, key, transferTTL)
+}
+
+func transferEventsKey(workflowID string) string {
+	return fmt.Sprintf("sdtobank_events_%s", workflowID)
+}
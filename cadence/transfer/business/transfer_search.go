@@ -0,0 +1,432 @@
+package business
+
+import (
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
+	"go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/client"
+	"go.uber.org/zap"
+)
+
+// Search attributes of SdToBankWorkflow. They must be added to the cluster
+// before advanced visibility is turned on, e.g. with
+// `cadence adm cluster add-search-attr --search_attr_key TransferStatus --search_attr_type 0`
+// (AccountId, TransferStatus and Direction are keywords, Amount a double).
+const (
+	SearchAttributeAccountID = "AccountId"
+	SearchAttributeStatus    = "TransferStatus"
+	SearchAttributeAmount    = "Amount"
+	SearchAttributeDirection = "Direction"
+
+	DefaultTransferPageSize = 50
+	MaxTransferPageSize     = 500
+
+	cursorCadence = "cadence"
+	cursorRedis   = "redis"
+
+	transferIndexKey = "sdtobank_index"
+	redisScanBatch   = 200
+)
+
+var (
+	ErrInvalidFilter = NewError(CodeInvalidRequest, "invalid transfer filter")
+	ErrInvalidCursor = NewError(CodeInvalidRequest, "invalid cursor")
+
+	filterValue = regexp.MustCompile(`^[A-Za-z0-9_.:@-]+$`)
+)
+
+// TransferFilter narrows a transfer listing. Zero fields match anything;
+// From is inclusive and To exclusive.
+type TransferFilter struct {
+	AccID     string
+	Status    string
+	Direction string
+	From      time.Time
+	To        time.Time
+	MinAmount *float64
+	MaxAmount *float64
+}
+
+func (f TransferFilter) validate() error {
+	for name, value := range map[string]string{"acc_id": f.AccID, "status": f.Status, "direction": f.Direction} {
+		if value != "" && !filterValue.MatchString(value) {
+			return fmt.Errorf("%s: %w", name, ErrInvalidFilter)
+		}
+	}
+
+	if f.Direction != "" {
+		if _, ok := pb.Direction_value[f.Direction]; !ok {
+			return fmt.Errorf("direction %s: %w", f.Direction, ErrInvalidFilter)
+		}
+	}
+
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return fmt.Errorf("date range: %w", ErrInvalidFilter)
+	}
+
+	if f.MinAmount != nil && f.MaxAmount != nil && *f.MinAmount > *f.MaxAmount {
+		return fmt.Errorf("amount range: %w", ErrInvalidFilter)
+	}
+
+	return nil
+}
+
+func (f TransferFilter) matches(t TransferSummary) bool {
+	switch {
+	case f.AccID != "" && t.AccID != f.AccID:
+		return false
+	case f.Status != "" && t.Status != f.Status:
+		return false
+	case f.Direction != "" && t.Direction != f.Direction:
+		return false
+	case !f.From.IsZero() && t.CreatedAt.Before(f.From):
+		return false
+	case !f.To.IsZero() && !t.CreatedAt.Before(f.To):
+		return false
+	case f.MinAmount != nil && t.Amount < *f.MinAmount:
+		return false
+	case f.MaxAmount != nil && t.Amount > *f.MaxAmount:
+		return false
+	}
+
+	return true
+}
+
+type TransferSummary struct {
+	ExecutionID string    `json:"execution_id"`
+	AccID       string    `json:"acc_id"`
+	Status      string    `json:"status"`
+	Direction   string    `json:"direction"`
+	Amount      float64   `json:"amount"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type TransferPage struct {
+	Transfers []TransferSummary `json:"transfers"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// TransferSearch lists transfers, newest first.
+type TransferSearch interface {
+	List(ctx context.Context, filter TransferFilter, cursor string, limit int) (*TransferPage, error)
+}
+
+type transferSearchImpl struct {
+	redis    redis.RedisConnection
+	wf       workflowserviceclient.Interface
+	domain   string
+	advanced bool
+	logger   *zap.SugaredLogger
+}
+
+// NewTransferSearch queries Cadence visibility when advanced is set, and
+// the Redis transfer index otherwise or when the cluster turns the query
+// down.
+func NewTransferSearch(redis redis.RedisConnection, wf workflowserviceclient.Interface, domain string, advanced bool) TransferSearch {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("transfer_search")
+
+	return &transferSearchImpl{
+		redis:    redis,
+		wf:       wf,
+		domain:   domain,
+		advanced: advanced,
+		logger:   logger.Sugar(),
+	}
+}
+
+// transferCursor is where a page ended. Cursors are opaque to callers and
+// only valid with the backend that made them.
+type transferCursor struct {
+	Backend string `json:"b"`
+	Token   []byte `json:"t,omitempty"`
+	Score   int64  `json:"s,omitempty"`
+	ID      string `json:"i,omitempty"`
+}
+
+func (c transferCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*transferCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor transferCursor
+	if json.Unmarshal(data, &cursor) != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+func (s *transferSearchImpl) List(ctx context.Context, filter TransferFilter, cursor string, limit int) (*TransferPage, error) {
+	err := filter.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultTransferPageSize
+	}
+
+	if limit > MaxTransferPageSize {
+		limit = MaxTransferPageSize
+	}
+
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if after != nil && after.Backend == cursorRedis {
+		return s.listRedis(ctx, filter, after, limit)
+	}
+
+	if s.advanced {
+		page, err := s.listCadence(ctx, filter, after, limit)
+
+		var badRequest *shared.BadRequestError
+		if !errors.As(err, &badRequest) || after != nil {
+			return page, err
+		}
+
+		s.logger.Warnw("Cadence visibility refused the query, using the Redis index", "err", err)
+	}
+
+	if after != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return s.listRedis(ctx, filter, nil, limit)
+}
+
+func (s *transferSearchImpl) listCadence(ctx context.Context, filter TransferFilter, after *transferCursor, limit int) (*TransferPage, error) {
+	var workflowClient client.Client = client.NewClient(
+		s.wf, s.domain, &client.Options{Identity: "local-mac-vinny", MetricsScope: tally.NoopScope})
+
+	request := &shared.ListWorkflowExecutionsRequest{
+		Domain:   &s.domain,
+		PageSize: int32Ptr(int32(limit)),
+		Query:    stringPtr(visibilityQuery(filter)),
+	}
+
+	if after != nil {
+		request.NextPageToken = after.Token
+	}
+
+	resp, err := workflowClient.ListWorkflow(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &TransferPage{Transfers: make([]TransferSummary, 0, len(resp.Executions))}
+
+	for _, execution := range resp.Executions {
+		page.Transfers = append(page.Transfers, summaryFromExecution(execution))
+	}
+
+	if len(resp.NextPageToken) > 0 {
+		page.NextCursor = transferCursor{Backend: cursorCadence, Token: resp.NextPageToken}.encode()
+	}
+
+	return page, nil
+}
+
+// visibilityQuery turns a filter into a Cadence list query. Values were
+// validated, so they need no escaping.
+func visibilityQuery(filter TransferFilter) string {
+	clauses := []string{fmt.Sprintf("WorkflowType = '%s'", SdToBankWorkflowName)}
+
+	if filter.AccID != "" {
+		clauses = append(clauses, fmt.Sprintf("%s = '%s'", SearchAttributeAccountID, filter.AccID))
+	}
+
+	if filter.Status != "" {
+		clauses = append(clauses, fmt.Sprintf("%s = '%s'", SearchAttributeStatus, filter.Status))
+	}
+
+	if filter.Direction != "" {
+		clauses = append(clauses, fmt.Sprintf("%s = '%s'", SearchAttributeDirection, filter.Direction))
+	}
+
+	if !filter.From.IsZero() {
+		clauses = append(clauses, fmt.Sprintf("StartTime >= %d", filter.From.UnixNano()))
+	}
+
+	if !filter.To.IsZero() {
+		clauses = append(clauses, fmt.Sprintf("StartTime < %d", filter.To.UnixNano()))
+	}
+
+	if filter.MinAmount != nil {
+		clauses = append(clauses, fmt.Sprintf("%s >= %s", SearchAttributeAmount, strconv.FormatFloat(*filter.MinAmount, 'f', -1, 64)))
+	}
+
+	if filter.MaxAmount != nil {
+		clauses = append(clauses, fmt.Sprintf("%s <= %s", SearchAttributeAmount, strconv.FormatFloat(*filter.MaxAmount, 'f', -1, 64)))
+	}
+
+	return strings.Join(clauses, " AND ") + " ORDER BY StartTime DESC"
+}
+
+func summaryFromExecution(execution *shared.WorkflowExecutionInfo) TransferSummary {
+	summary := TransferSummary{
+		ExecutionID: execution.Execution.GetWorkflowId(),
+		CreatedAt:   time.Unix(0, execution.GetStartTime()).UTC(),
+	}
+
+	if execution.SearchAttributes == nil {
+		return summary
+	}
+
+	fields := execution.SearchAttributes.IndexedFields
+	json.Unmarshal(fields[SearchAttributeAccountID], &summary.AccID)
+	json.Unmarshal(fields[SearchAttributeStatus], &summary.Status)
+	json.Unmarshal(fields[SearchAttributeDirection], &summary.Direction)
+	json.Unmarshal(fields[SearchAttributeAmount], &summary.Amount)
+
+	return summary
+}
+
+// listRedis walks the index from the newest transfer, or from the cursor,
+// loading each transfer to apply the filter. The account index is walked
+// instead when the filter names an account.
+func (s *transferSearchImpl) listRedis(ctx context.Context, filter TransferFilter, after *transferCursor, limit int) (*TransferPage, error) {
+	key := transferIndexKey
+	if filter.AccID != "" {
+		key = transferAccountIndexKey(filter.AccID)
+	}
+
+	max := "+inf"
+	if !filter.To.IsZero() {
+		max = "(" + strconv.FormatInt(timeScore(filter.To), 10)
+	}
+
+	if after != nil {
+		max = strconv.FormatInt(after.Score, 10)
+	}
+
+	min := "-inf"
+	if !filter.From.IsZero() {
+		min = strconv.FormatInt(timeScore(filter.From), 10)
+	}
+
+	page := &TransferPage{Transfers: []TransferSummary{}}
+
+	// Each batch starts at the score the last one ended on, past the
+	// entries with that score it already went through, so transfers
+	// started on the same millisecond never fill a batch forever.
+	var offset int64
+	var boundary int64
+
+	for {
+		entries, err := s.redis.GetConn().ZRevRangeByScoreWithScores(ctx, key, &goredis.ZRangeBy{
+			Max:    max,
+			Min:    min,
+			Offset: offset,
+			Count:  redisScanBatch,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			id := entry.Member.(string)
+			score := int64(entry.Score)
+
+			if score == boundary {
+				offset++
+			} else {
+				boundary, offset = score, 1
+			}
+
+			// Entries with the cursor's score come in descending id order;
+			// the ones up to the cursor were on the previous page.
+			if after != nil && score == after.Score && id >= after.ID {
+				continue
+			}
+
+			after = &transferCursor{Backend: cursorRedis, Score: score, ID: id}
+
+			summary, ok, err := s.redisSummary(ctx, id, score)
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok || !filter.matches(summary) {
+				continue
+			}
+
+			page.Transfers = append(page.Transfers, summary)
+
+			if len(page.Transfers) == limit {
+				page.NextCursor = after.encode()
+				return page, nil
+			}
+		}
+
+		if len(entries) < redisScanBatch {
+			return page, nil
+		}
+
+		max = strconv.FormatInt(boundary, 10)
+	}
+}
+
+func (s *transferSearchImpl) redisSummary(ctx context.Context, id string, score int64) (TransferSummary, bool, error) {
+	transfer, err := loadTransfer(ctx, s.redis, id)
+	if err == ErrTransferNotFound {
+		// The transfer expired before its index entry.
+		return TransferSummary{}, false, nil
+	}
+
+	if err != nil {
+		return TransferSummary{}, false, err
+	}
+
+	return TransferSummary{
+		ExecutionID: transfer.ExecutionId,
+		AccID:       transfer.AccId,
+		Status:      transfer.Status,
+		Direction:   transfer.Direction.String(),
+		Amount:      transfer.Amount,
+		CreatedAt:   time.Unix(0, score*int64(time.Millisecond)).UTC(),
+	}, true, nil
+}
+
+func timeScore(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func transferAccountIndexKey(accID string) string {
+	return fmt.Sprintf("sdtobank_index_acc_%s", accID)
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
+
+func stringPtr(v string) *string {
+	return &v
+}
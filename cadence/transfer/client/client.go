@@ -0,0 +1,411 @@
+// Package client calls the transfer HTTP API, as described by its OpenAPI
+// document at /api/openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+const (
+	defaultMaxRetries   = 3
+	defaultRetryWait    = 500 * time.Millisecond
+	defaultMaxRetryWait = 10 * time.Second
+)
+
+type Client interface {
+	// CreateTransfer starts a transfer. Sending it again with the same
+	// idempotency key answers the transfer the key started; an empty key
+	// gets one generated, which still makes the client's own retries safe.
+	CreateTransfer(ctx context.Context, transfer NewTransfer, idempotencyKey string) (*Transfer, error)
+	// QueueTransfer queues a transfer for the consumer, for bulk producers.
+	QueueTransfer(ctx context.Context, transfer NewTransfer) (*QueuedTransfer, error)
+	GetTransfer(ctx context.Context, id string) (*Transfer, error)
+	// ListTransfers lists transfers newest first. The next page is asked
+	// for with the NextCursor of the last one.
+	ListTransfers(ctx context.Context, filter TransferFilter, cursor string, limit int) (*TransferPage, error)
+	ApproveTransfer(ctx context.Context, id string) (*Transfer, error)
+	CancelTransfer(ctx context.Context, id, reason string) (*Transfer, error)
+
+	// The admin operations need the admin role. Those acting on workflows
+	// answer a nil transfer for workflows without one.
+	SignalTransfer(ctx context.Context, id, trigger, reason string) (*Transfer, error)
+	RetryTransferActivity(ctx context.Context, id, reason string) (*Transfer, error)
+	ResetTransfer(ctx context.Context, id string, reset Reset) (*Transfer, error)
+	TerminateTransfer(ctx context.Context, id, reason string) (*Transfer, error)
+	ReleaseTransferHold(ctx context.Context, id, reason string) (*Hold, error)
+	TransferHistory(ctx context.Context, id string, pageToken []byte) (*HistoryPage, error)
+}
+
+// Config is where the API is and how to authenticate. Set Token or APIKey.
+type Config struct {
+	// BaseURL is the API root, such as https://transfers.example.com/api.
+	BaseURL string
+	// Token is a bearer token.
+	Token   string
+	APIKey  string
+	Timeout time.Duration
+	// MaxRetries is how many times reads and idempotent requests are sent
+	// again when the API is unavailable, 3 by default. Negative turns
+	// retries off.
+	MaxRetries int
+	// RetryWait is the first wait between tries, doubling up to
+	// MaxRetryWait. A longer Retry-After from the API wins.
+	RetryWait    time.Duration
+	MaxRetryWait time.Duration
+}
+
+type clientImpl struct {
+	baseURL      string
+	token        string
+	apiKey       string
+	http         *http.Client
+	maxRetries   int
+	retryWait    time.Duration
+	maxRetryWait time.Duration
+}
+
+func NewClient(config Config) Client {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	maxRetries := config.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	}
+
+	retryWait := config.RetryWait
+	if retryWait <= 0 {
+		retryWait = defaultRetryWait
+	}
+
+	maxRetryWait := config.MaxRetryWait
+	if maxRetryWait <= 0 {
+		maxRetryWait = defaultMaxRetryWait
+	}
+
+	return &clientImpl{
+		baseURL:      config.BaseURL,
+		token:        config.Token,
+		apiKey:       config.APIKey,
+		http:         &http.Client{Timeout: timeout},
+		maxRetries:   maxRetries,
+		retryWait:    retryWait,
+		maxRetryWait: maxRetryWait,
+	}
+}
+
+// request is one API call. Only idempotent ones are retried.
+type request struct {
+	method         string
+	path           string
+	query          url.Values
+	body           interface{}
+	idempotencyKey string
+	idempotent     bool
+}
+
+func (c *clientImpl) CreateTransfer(ctx context.Context, transfer NewTransfer, idempotencyKey string) (*Transfer, error) {
+	if idempotencyKey == "" {
+		idempotencyKey = uuid.New()
+	}
+
+	var created Transfer
+	err := c.do(ctx, request{method: http.MethodPost, path: "/transfers", body: transfer, idempotencyKey: idempotencyKey, idempotent: true}, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+func (c *clientImpl) QueueTransfer(ctx context.Context, transfer NewTransfer) (*QueuedTransfer, error) {
+	var queued QueuedTransfer
+	err := c.do(ctx, request{method: http.MethodPost, path: "/transfers/new", body: transfer}, &queued)
+	if err != nil {
+		return nil, err
+	}
+
+	return &queued, nil
+}
+
+func (c *clientImpl) GetTransfer(ctx context.Context, id string) (*Transfer, error) {
+	var transfer Transfer
+	err := c.do(ctx, request{method: http.MethodGet, path: transferPath(id, ""), idempotent: true}, &transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+func (c *clientImpl) ListTransfers(ctx context.Context, filter TransferFilter, cursor string, limit int) (*TransferPage, error) {
+	query := url.Values{}
+	set := func(name, value string) {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	set("acc_id", filter.AccID)
+	set("status", filter.Status)
+	set("direction", filter.Direction)
+	set("cursor", cursor)
+
+	if !filter.CreatedFrom.IsZero() {
+		set("created_from", filter.CreatedFrom.Format(time.RFC3339))
+	}
+
+	if !filter.CreatedTo.IsZero() {
+		set("created_to", filter.CreatedTo.Format(time.RFC3339))
+	}
+
+	if filter.MinAmount != nil {
+		set("min_amount", strconv.FormatFloat(*filter.MinAmount, 'f', -1, 64))
+	}
+
+	if filter.MaxAmount != nil {
+		set("max_amount", strconv.FormatFloat(*filter.MaxAmount, 'f', -1, 64))
+	}
+
+	if limit > 0 {
+		set("limit", strconv.Itoa(limit))
+	}
+
+	var page TransferPage
+	err := c.do(ctx, request{method: http.MethodGet, path: "/transfers", query: query, idempotent: true}, &page)
+	if err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+func (c *clientImpl) ApproveTransfer(ctx context.Context, id string) (*Transfer, error) {
+	return c.transition(ctx, transferPath(id, "/approve"), nil)
+}
+
+func (c *clientImpl) CancelTransfer(ctx context.Context, id, reason string) (*Transfer, error) {
+	return c.transition(ctx, transferPath(id, "/cancel"), reasonBody(reason))
+}
+
+func (c *clientImpl) SignalTransfer(ctx context.Context, id, trigger, reason string) (*Transfer, error) {
+	body := map[string]string{"trigger": trigger}
+	if reason != "" {
+		body["reason"] = reason
+	}
+
+	return c.transition(ctx, adminPath(id, "/signal"), body)
+}
+
+func (c *clientImpl) RetryTransferActivity(ctx context.Context, id, reason string) (*Transfer, error) {
+	return c.transition(ctx, adminPath(id, "/retry"), reasonBody(reason))
+}
+
+func (c *clientImpl) ResetTransfer(ctx context.Context, id string, reset Reset) (*Transfer, error) {
+	return c.transition(ctx, adminPath(id, "/reset"), reset)
+}
+
+func (c *clientImpl) TerminateTransfer(ctx context.Context, id, reason string) (*Transfer, error) {
+	return c.transition(ctx, adminPath(id, "/terminate"), map[string]string{"reason": reason})
+}
+
+func (c *clientImpl) ReleaseTransferHold(ctx context.Context, id, reason string) (*Hold, error) {
+	var hold Hold
+	err := c.do(ctx, request{method: http.MethodPost, path: adminPath(id, "/release-hold"), body: reasonBody(reason)}, &hold)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hold, nil
+}
+
+func (c *clientImpl) TransferHistory(ctx context.Context, id string, pageToken []byte) (*HistoryPage, error) {
+	query := url.Values{}
+	if len(pageToken) > 0 {
+		query.Set("page_token", base64.StdEncoding.EncodeToString(pageToken))
+	}
+
+	var page HistoryPage
+	err := c.do(ctx, request{method: http.MethodGet, path: adminPath(id, "/history"), query: query, idempotent: true}, &page)
+	if err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// transition posts an operation answering the transfer it acted on, if
+// any.
+func (c *clientImpl) transition(ctx context.Context, path string, body interface{}) (*Transfer, error) {
+	var transfer *Transfer
+	err := c.do(ctx, request{method: http.MethodPost, path: path, body: body}, &transfer)
+
+	return transfer, err
+}
+
+// do sends the request, again while it is idempotent and fails in a way
+// that may pass, and decodes the answer into result.
+func (c *clientImpl) do(ctx context.Context, req request, result interface{}) error {
+	var body []byte
+	if req.body != nil {
+		var err error
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return err
+		}
+	}
+
+	wait := c.retryWait
+
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, req, body, result)
+		if err == nil || !req.idempotent || attempt >= c.maxRetries || !IsRetryable(err) {
+			return err
+		}
+
+		if apiErr := err.(*Error); apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if wait > c.maxRetryWait {
+			wait = c.maxRetryWait
+		}
+	}
+}
+
+func (c *clientImpl) send(ctx context.Context, req request, body []byte, result interface{}) error {
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	httpReq, err := http.NewRequest(req.method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	if req.idempotencyKey != "" {
+		httpReq.Header.Set("Idempotency-Key", req.idempotencyKey)
+	}
+
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	if c.apiKey != "" {
+		httpReq.Header.Set("X-API-Key", c.apiKey)
+	}
+
+	res, err := c.http.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return &Error{Code: CodeUnavailable, Message: err.Error()}
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return &Error{Code: CodeUnavailable, StatusCode: res.StatusCode, Message: err.Error()}
+	}
+
+	if res.StatusCode >= 300 {
+		return decodeError(res, data)
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return &Error{Code: CodeInternal, StatusCode: res.StatusCode, Message: err.Error()}
+	}
+
+	return nil
+}
+
+// decodeError reads the problem the API answered with. Answers without a
+// code, such as those of proxies, get one from their status.
+func decodeError(res *http.Response, data []byte) *Error {
+	var apiErr *Error
+
+	var body problem
+	if json.Unmarshal(data, &body) == nil && body.Code != "" {
+		message := body.Detail
+		if message == "" {
+			message = body.Title
+		}
+
+		apiErr = &Error{Code: body.Code, StatusCode: res.StatusCode, Message: message, Fields: body.Errors}
+	} else {
+		apiErr = errorFromStatus(res.StatusCode, string(bytes.TrimSpace(data)))
+	}
+
+	apiErr.RetryAfter = retryAfter(res.Header.Get("Retry-After"))
+
+	return apiErr
+}
+
+// retryAfter reads a Retry-After header, in seconds or as a date.
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
+
+func transferPath(id, action string) string {
+	return "/transfers/" + url.PathEscape(id) + action
+}
+
+func adminPath(id, action string) string {
+	return "/admin/transfers/" + url.PathEscape(id) + action
+}
+
+func reasonBody(reason string) interface{} {
+	if reason == "" {
+		return nil
+	}
+
+	return map[string]string{"reason": reason}
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrorCode is the stable code the API answers errors with. Branch on it
+// rather than on statuses or messages.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest       ErrorCode = "invalid_request"
+	CodeUnauthenticated      ErrorCode = "unauthenticated"
+	CodeForbidden            ErrorCode = "forbidden"
+	CodeTransferNotFound     ErrorCode = "transfer_not_found"
+	CodeAccountNotFound      ErrorCode = "account_not_found"
+	CodePaymentNotFound      ErrorCode = "payment_not_found"
+	CodeHoldNotFound         ErrorCode = "hold_not_found"
+	CodeInsufficientFunds    ErrorCode = "insufficient_funds"
+	CodeLimitExceeded        ErrorCode = "limit_exceeded"
+	CodeTransferState        ErrorCode = "transfer_state_conflict"
+	CodeIdempotencyKeyReused ErrorCode = "idempotency_key_reused"
+	CodeNotCancelable        ErrorCode = "payment_not_cancelable"
+	CodeUnsupported          ErrorCode = "unsupported_operation"
+	CodeNoProvider           ErrorCode = "no_provider"
+	CodeProviderUnavailable  ErrorCode = "provider_unavailable"
+	CodeTimeout              ErrorCode = "timeout"
+	CodeInternal             ErrorCode = "internal"
+
+	// The API never answers these; the client uses them for failures on
+	// the way to it and for answers without a code.
+	CodeNotFound    ErrorCode = "not_found"
+	CodeConflict    ErrorCode = "conflict"
+	CodeRateLimited ErrorCode = "rate_limited"
+	CodeUnavailable ErrorCode = "unavailable"
+)
+
+// FieldError is why one field of an invalid request was turned down.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error is returned for every failure the API reports or on the way to it.
+type Error struct {
+	Code       ErrorCode
+	StatusCode int
+	Message    string
+	// Fields are the broken fields of an invalid request.
+	Fields []FieldError
+	// RetryAfter is how long the API asked to wait before trying again.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) == 0 {
+		return fmt.Sprintf("transfer api %s (%d): %s", e.Code, e.StatusCode, e.Message)
+	}
+
+	fields := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		fields[i] = fmt.Sprintf("%s: %s", field.Field, field.Message)
+	}
+
+	return fmt.Sprintf("transfer api %s (%d): %s", e.Code, e.StatusCode, strings.Join(fields, ", "))
+}
+
+// Is matches errors with the same code, so that
+// errors.Is(err, &client.Error{Code: client.CodeInsufficientFunds}) works.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Retryable tells whether the same request can succeed if sent again later.
+func (e *Error) Retryable() bool {
+	switch e.Code {
+	case CodeRateLimited, CodeUnavailable, CodeProviderUnavailable, CodeTimeout:
+		return true
+	}
+
+	return false
+}
+
+func IsRetryable(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.Retryable()
+}
+
+// CodeOf returns the code of an API error, or an empty code for other
+// errors, such as a canceled context.
+func CodeOf(err error) ErrorCode {
+	apiErr, ok := err.(*Error)
+	if !ok {
+		return ""
+	}
+
+	return apiErr.Code
+}
+
+func IsCode(err error, code ErrorCode) bool {
+	return CodeOf(err) == code
+}
+
+// problem is the application/problem+json body of API errors.
+type problem struct {
+	Title  string       `json:"title"`
+	Detail string       `json:"detail"`
+	Code   ErrorCode    `json:"code"`
+	Errors []FieldError `json:"errors"`
+}
+
+// errorFromStatus maps an HTTP status to an error when the body carries no
+// code of its own.
+func errorFromStatus(status int, message string) *Error {
+	code := CodeInternal
+
+	switch {
+	case status == http.StatusBadRequest:
+		code = CodeInvalidRequest
+	case status == http.StatusUnauthorized:
+		code = CodeUnauthenticated
+	case status == http.StatusForbidden:
+		code = CodeForbidden
+	case status == http.StatusNotFound:
+		code = CodeNotFound
+	case status == http.StatusConflict:
+		code = CodeConflict
+	case status == http.StatusTooManyRequests:
+		code = CodeRateLimited
+	case status == http.StatusBadGateway || status == http.StatusServiceUnavailable:
+		code = CodeUnavailable
+	case status == http.StatusGatewayTimeout:
+		code = CodeTimeout
+	}
+
+	return &Error{Code: code, StatusCode: status, Message: message}
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DirectionSdToBank moves funds from the SD account to the bank, and is
+// the only direction so far.
+const DirectionSdToBank = 0
+
+// Transfer statuses.
+const (
+	StatusStarting     = "starting"
+	StatusValidated    = "validated"
+	StatusBlocked      = "blocked"
+	StatusDebited      = "debited"
+	StatusCreditQueued = "credit_queued"
+	StatusCompleted    = "completed"
+	StatusReturned     = "returned"
+	StatusFailed       = "failed"
+	StatusCanceled     = "canceled"
+	StatusTerminated   = "terminated"
+)
+
+// Reset steps.
+const (
+	StepValidate     = "validate"
+	StepBlock        = "block"
+	StepUnblockDebit = "unblock_debit"
+	StepCredit       = "credit"
+)
+
+// NewTransfer is a transfer to start. Amounts are USD, with at most two
+// decimal places.
+type NewTransfer struct {
+	AccID     string  `json:"acc_id"`
+	Amount    float64 `json:"amount"`
+	Direction int32   `json:"direction"`
+}
+
+type Transfer struct {
+	// ExecutionID is the transfer id.
+	ExecutionID string  `json:"execution_id"`
+	Status      string  `json:"status"`
+	AccID       string  `json:"acc_id"`
+	Amount      float64 `json:"amount"`
+	Direction   string  `json:"direction"`
+}
+
+type QueuedTransfer struct {
+	Status string `json:"status"`
+	// CorrelationID is the id the transfer will have.
+	CorrelationID string `json:"correlation_id"`
+}
+
+// TransferFilter narrows ListTransfers. Zero fields do not filter.
+type TransferFilter struct {
+	AccID       string
+	Status      string
+	Direction   string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	MinAmount   *float64
+	MaxAmount   *float64
+}
+
+type TransferSummary struct {
+	ExecutionID string    `json:"execution_id"`
+	AccID       string    `json:"acc_id"`
+	Status      string    `json:"status"`
+	Direction   string    `json:"direction"`
+	Amount      float64   `json:"amount"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type TransferPage struct {
+	Transfers []TransferSummary `json:"transfers"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Reset picks the step ResetTransfer resets a workflow to. Signals received
+// after the step are sent again unless SkipSignals is set.
+type Reset struct {
+	Step        string `json:"step"`
+	Reason      string `json:"reason,omitempty"`
+	SkipSignals bool   `json:"skip_signals,omitempty"`
+}
+
+type Hold struct {
+	AccountID   string  `json:"account_id"`
+	ExecutionID string  `json:"execution_id"`
+	Amount      float64 `json:"amount"`
+}
+
+// HistoryPage is a page of raw Cadence history events. NextPageToken is
+// empty on the last page.
+type HistoryPage struct {
+	Events        []json.RawMessage `json:"events"`
+	NextPageToken []byte            `json:"next_page_token,omitempty"`
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+type AdminHandler interface {
+	Signal() http.Handler
+	RetryActivity() http.Handler
+	Reset() http.Handler
+	Terminate() http.Handler
+	ReleaseHold() http.Handler
+	History() http.Handler
+}
+
+type adminHandlerImpl struct {
+	router    *mux.Router
+	admin     business.TransferAdmin
+	transfers business.SdToBankService
+	logger    *zap.SugaredLogger
+}
+
+// adminRequest is the body of the admin operations. Each one reads the
+// fields it needs.
+type adminRequest struct {
+	Reason      string                 `json:"reason"`
+	Trigger     business.SignalTrigger `json:"trigger"`
+	Step        string                 `json:"step"`
+	SkipSignals bool                   `json:"skip_signals"`
+}
+
+// NewAdminHandler serves the operations that move stuck transfers along,
+// under /admin. They are for admins only and every one of them is logged
+// with who did it and why.
+func NewAdminHandler(router *mux.Router, admin business.TransferAdmin, transfers business.SdToBankService) {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("admin_handler")
+
+	handler := &adminHandlerImpl{router, admin, transfers, logger.Sugar()}
+	handler.buildRoutes()
+}
+
+func (p *adminHandlerImpl) buildRoutes() {
+	router := p.router.PathPrefix("/admin/transfers/{id}").Subrouter()
+	router.Use(adminMiddleware)
+
+	router.Handle("/signal", p.Signal()).Methods("POST")
+	router.Handle("/retry", p.RetryActivity()).Methods("POST")
+	router.Handle("/reset", p.Reset()).Methods("POST")
+	router.Handle("/terminate", p.Terminate()).Methods("POST")
+	router.Handle("/release-hold", p.ReleaseHold()).Methods("POST")
+	router.Handle("/history", p.History()).Methods("GET")
+}
+
+// Signal sends the trigger of the body to the workflow.
+func (p *adminHandlerImpl) Signal() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := p.decode(w, r)
+		if !ok {
+			return
+		}
+
+		err := p.admin.Signal(r.Context(), mux.Vars(r)["id"], body.Trigger)
+		p.audit(r, "signal", body, err)
+		p.transition(w, r, err)
+	})
+}
+
+// RetryActivity runs the last failed activity of the workflow again.
+func (p *adminHandlerImpl) RetryActivity() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := p.decode(w, r)
+		if !ok {
+			return
+		}
+
+		err := p.admin.RetryActivity(r.Context(), mux.Vars(r)["id"], body.Reason)
+		p.audit(r, "retry", body, err)
+		p.transition(w, r, err)
+	})
+}
+
+// Reset resets the workflow to the step of the body: validate, block,
+// unblock_debit or credit.
+func (p *adminHandlerImpl) Reset() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := p.decode(w, r)
+		if !ok {
+			return
+		}
+
+		err := p.admin.Reset(r.Context(), mux.Vars(r)["id"], body.Step, body.Reason, body.SkipSignals)
+		p.audit(r, "reset", body, err)
+		p.transition(w, r, err)
+	})
+}
+
+// Terminate stops the workflow. The body needs a reason.
+func (p *adminHandlerImpl) Terminate() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := p.decode(w, r)
+		if !ok {
+			return
+		}
+
+		err := p.admin.Terminate(r.Context(), mux.Vars(r)["id"], body.Reason)
+		p.audit(r, "terminate", body, err)
+		p.transition(w, r, err)
+	})
+}
+
+// ReleaseHold gives the funds held for the transfer back to its account,
+// answering the released hold. The workflow must be closed and the body
+// needs a reason.
+func (p *adminHandlerImpl) ReleaseHold() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := p.decode(w, r)
+		if !ok {
+			return
+		}
+
+		hold, err := p.admin.ReleaseHold(r.Context(), mux.Vars(r)["id"], body.Reason)
+		p.audit(r, "release_hold", body, err)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, 200, hold)
+	})
+}
+
+// History answers a page of the raw workflow history. The next page is
+// asked for with the next_page_token of the last one.
+func (p *adminHandlerImpl) History() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pageToken []byte
+
+		if value := r.URL.Query().Get("page_token"); value != "" {
+			var err error
+
+			pageToken, err = base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				writeError(w, business.NewError(business.CodeInvalidRequest, "invalid page_token"))
+				return
+			}
+		}
+
+		page, err := p.admin.History(r.Context(), mux.Vars(r)["id"], pageToken)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, 200, page)
+	})
+}
+
+// decode reads the optional body of an admin operation.
+func (p *adminHandlerImpl) decode(w http.ResponseWriter, r *http.Request) (adminRequest, bool) {
+	var body adminRequest
+
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			writeError(w, invalidRequest(err))
+			return body, false
+		}
+	}
+
+	return body, true
+}
+
+// audit logs the operation with the admin who asked for it.
+func (p *adminHandlerImpl) audit(r *http.Request, action string, body adminRequest, err error) {
+	principal, _ := auth.FromContext(r.Context())
+
+	fields := []interface{}{
+		"action", action,
+		"execution_id", mux.Vars(r)["id"],
+		"operator", principal.Subject,
+		"reason", body.Reason,
+	}
+
+	switch {
+	case body.Trigger != "":
+		fields = append(fields, "trigger", body.Trigger)
+	case body.Step != "":
+		fields = append(fields, "step", body.Step, "skip_signals", body.SkipSignals)
+	}
+
+	if err != nil {
+		p.logger.Warnw("Admin operation failed", append(fields, "err", err)...)
+		return
+	}
+
+	p.logger.Infow("Admin operation", fields...)
+}
+
+// transition answers an operation on the workflow with its transfer, or
+// with an empty 202 for workflows without one.
+func (p *adminHandlerImpl) transition(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	transfer, err := p.transfers.GetTransferInformation(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(202)
+		return
+	}
+
+	writeJSON(w, 202, newTransferResponse(transfer))
+}
+
+// adminMiddleware answers 403 to callers who are not admins.
+func adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, auth.RoleAdmin) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// authMiddleware authenticates every request, answering 401 to those that
+// fail. Handlers find the caller with auth.FromContext.
+func authMiddleware(authenticator auth.Authenticator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := authenticator.Authenticate(r.Context(), auth.HTTPCredentials(r))
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				writeError(w, business.Errorf(business.CodeUnauthenticated, "%w", err))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// readAnyAccount are the roles that may see the transfers of any account.
+var readAnyAccount = []auth.Role{auth.RoleSupport, auth.RoleApprover}
+
+// allow answers 403 unless the caller has one of the roles.
+func allow(w http.ResponseWriter, r *http.Request, roles ...auth.Role) bool {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		writeError(w, errUnauthenticated)
+		return false
+	}
+
+	if !principal.HasRole(roles...) {
+		writeError(w, errForbidden)
+		return false
+	}
+
+	return true
+}
+
+// allowAccount answers 403 unless the caller may act for the account or
+// has one of the roles.
+func allowAccount(w http.ResponseWriter, r *http.Request, accID string, roles ...auth.Role) bool {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		writeError(w, errUnauthenticated)
+		return false
+	}
+
+	if !principal.CanAccess(accID) && !principal.HasRole(roles...) {
+		writeError(w, errForbidden)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/resilience"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type breakerHandlerImpl struct {
+	router   *mux.Router
+	breakers resilience.Group
+}
+
+// NewBreakerHandler shows operators the breaker and bulkhead of every
+// provider this process has called.
+func NewBreakerHandler(router *mux.Router, breakers resilience.Group) {
+	handler := &breakerHandlerImpl{router, breakers}
+	handler.buildRoutes()
+}
+
+func (p *breakerHandlerImpl) buildRoutes() {
+	p.router.Handle("/admin/breakers", p.ListBreakers()).Methods("GET")
+}
+
+func (p *breakerHandlerImpl) ListBreakers() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, auth.RoleSupport) {
+			return
+		}
+
+		writeJSON(w, 200, map[string]interface{}{"breakers": p.breakers.States()})
+	})
+}
@@ -1,93 +1,108 @@
 package handlers
 
 import (
+	"avenuesec/workflow-poc/cadence/transfer/apex"
+	"avenuesec/workflow-poc/cadence/transfer/broker"
 	"avenuesec/workflow-poc/cadence/transfer/business"
 	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
-	"avenuesec/workflow-poc/cadence/transfer/rabbitmq"
+	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"avenuesec/workflow-poc/cadence/transfer/validation"
 	"context"
-	"fmt"
-	"log"
-	"reflect"
+	"errors"
+	"time"
 
-	"github.com/golang/protobuf/proto"
+	"github.com/uber-go/tally"
 	"go.uber.org/zap"
 )
 
+const (
+	consumerConcurrency = 4
+	consumerPrefetch    = 8
+	inboxLease          = time.Minute
+	inboxTTL            = 7 * 24 * time.Hour
+	apexHistoryTTL      = 30 * 24 * time.Hour
+)
+
 type Consumer interface {
 }
 
 type consumerImpl struct {
-	rabbit      rabbitmq.AmqpConnection
+	dispatcher  broker.Dispatcher
 	sdToBankSvc business.SdToBankService
+	apexState   apex.StateMachine
 	logger      *zap.SugaredLogger
 }
 
-func NewConsumer(rabbit rabbitmq.AmqpConnection, sdToBankSvc business.SdToBankService) Consumer {
+func NewConsumer(broker broker.Broker, rd redis.RedisConnection, sdToBankSvc business.SdToBankService, scope tally.Scope) Consumer {
 	logger, _ := zap.NewProduction()
 	logger = logger.Named("consumer")
 
 	consumer := &consumerImpl{
-		rabbit:      rabbit,
+		dispatcher:  newDispatcher(broker, rd, "consumer", logger.Sugar(), scope),
 		sdToBankSvc: sdToBankSvc,
+		apexState:   apex.NewStateMachine(redis.NewApexHistory(rd, apexHistoryTTL), sdToBankSvc.ApexStatusChanged),
 		logger:      logger.Sugar(),
 	}
 
-	go consumer.installHandlers()
-
-	return consumer
-}
-
-func (c *consumerImpl) installHandlers() {
-	consumer := c.rabbit.GetChannel()
-
-	msgs, err := consumer.Consume(
-		c.rabbit.GetQueue(), // queue
-		"",                  // consumer
-		true,                // auto-ack
-		false,               // exclusive
-		false,               // no-local
-		false,               // no-wait
-		nil,                 // args
-	)
+	consumer.dispatcher.MustRegister(consumer.handleNewTransfer)
+	consumer.dispatcher.MustRegister(consumer.handleApexWithdrawResponse)
+	consumer.dispatcher.MustRegister(consumer.handleEntryAck)
 
+	err := consumer.dispatcher.Start()
 	if err != nil {
-		c.logger.DPanicw("Deu ruim", "err", err)
+		consumer.logger.DPanicw("Failed to start consumer", "err", err)
 	}
 
-	forever := make(chan bool)
-
-	go func() {
-		for d := range msgs {
-			log.Printf("Received a message: %s. Type: %s", d.Body, d.Type)
+	return consumer
+}
 
-			messageType := proto.MessageType(d.Type)
+func (c *consumerImpl) handleNewTransfer(ctx context.Context, message *pb.NewTransferMessage) error {
+	// An invalid message won't get better by redelivery.
+	var errs validation.Errors
+	if err := validation.Validate(message); errors.As(err, &errs) {
+		c.logger.Warnw("Invalid new transfer dropped", "message_key", broker.MessageKey(ctx, message), "fields", errs)
+		return nil
+	}
 
-			if messageType == nil {
-				errorMsg := fmt.Sprintf("Unknown message type: %s - did you initialize protobuf?", d.Type)
+	_, err := c.sdToBankSvc.StartTransfer(ctx, message)
 
-				c.logger.Errorw("error deserializing proto message", "err", errorMsg)
+	return err
+}
 
-				return
-			}
+func (c *consumerImpl) handleApexWithdrawResponse(ctx context.Context, message *pb.ApexWithdrawResponse) error {
+	c.logger.Infow("Apex withdraw response received", "execution_id", message.ExecutionId, "status", message.Status)
 
-			v := reflect.New(messageType.Elem())
-			message := v.Interface().(proto.Message)
+	_, err := c.apexState.Apply(ctx, message)
 
-			err = proto.Unmarshal(d.Body, message)
-			if err != nil {
-				c.logger.Errorw("error unmarshalling message", "err", err)
-				return
-			}
+	// Refused updates won't get better by redelivery; the state machine
+	// already logged them.
+	var transitionErr *apex.TransitionError
+	if errors.As(err, &transitionErr) {
+		return nil
+	}
 
-			switch messageType {
-			case reflect.TypeOf(&pb.NewTransferMessage{}):
-				c.sdToBankSvc.StartTransfer(context.Background(), message.(*pb.NewTransferMessage))
-			case reflect.TypeOf(&pb.ApexWithdrawResponse{}):
+	return err
+}
 
-			}
-		}
-	}()
+func (c *consumerImpl) handleEntryAck(ctx context.Context, message *pb.EntryAck) error {
+	return c.sdToBankSvc.EntryAcknowledged(ctx, message)
+}
 
-	c.logger.Info(" [*] Waiting for messages. To exit press CTRL+C")
-	<-forever
+// newDispatcher builds a dispatcher with the middleware stack shared by all
+// consumers: panic recovery outermost, then logging, metrics and the Redis
+// inbox, which is scoped by consumer name so each consumer sees every
+// message once.
+func newDispatcher(b broker.Broker, rd redis.RedisConnection, name string, logger *zap.SugaredLogger, scope tally.Scope) broker.Dispatcher {
+	return broker.NewDispatcher(b, broker.DispatcherOptions{
+		Name:        name,
+		Concurrency: consumerConcurrency,
+		Prefetch:    consumerPrefetch,
+		UnknownType: broker.UnknownTypeDrop,
+		Middlewares: []broker.Middleware{
+			broker.RecoveryMiddleware(logger),
+			broker.LoggingMiddleware(logger),
+			broker.MetricsMiddleware(scope.SubScope(name)),
+			broker.DeduplicationMiddleware(redis.NewInbox(rd, name, inboxLease, inboxTTL)),
+		},
+	})
 }
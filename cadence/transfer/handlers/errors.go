@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"avenuesec/workflow-poc/cadence/transfer/resilience"
+	"avenuesec/workflow-poc/cadence/transfer/validation"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+var (
+	errUnauthenticated = business.NewError(business.CodeUnauthenticated, "unauthenticated")
+	errForbidden       = business.NewError(business.CodeForbidden, "forbidden")
+)
+
+// codeStatus is the HTTP status of each error code. Codes missing here are
+// answered with 500.
+var codeStatus = map[business.ErrorCode]int{
+	business.CodeInvalidRequest:       400,
+	business.CodeUnauthenticated:      401,
+	business.CodeForbidden:            403,
+	business.CodeTransferNotFound:     404,
+	business.CodeAccountNotFound:      404,
+	business.CodePaymentNotFound:      404,
+	business.CodeHoldNotFound:         404,
+	business.CodeReportNotFound:       404,
+	business.CodeCaseNotFound:         404,
+	business.CodeInvalidSignature:     401,
+	business.CodeReplayedRequest:      401,
+	business.CodeTransferState:        409,
+	business.CodeNotCancelable:        409,
+	business.CodeCaseResolved:         409,
+	business.CodeInProgress:           409,
+	business.CodeIdempotencyKeyReused: 422,
+	business.CodeInsufficientFunds:    422,
+	business.CodeLimitExceeded:        422,
+	business.CodeNoProvider:           422,
+	business.CodeUnsupported:          501,
+	business.CodeProviderUnavailable:  503,
+	business.CodeBrokerUnavailable:    503,
+	business.CodeTimeout:              504,
+}
+
+// problem is an RFC 7807 problem details body. Code is the stable error
+// code clients branch on, and Errors the broken fields of an invalid
+// request.
+type problem struct {
+	Type   string             `json:"type"`
+	Title  string             `json:"title"`
+	Status int                `json:"status"`
+	Detail string             `json:"detail,omitempty"`
+	Code   business.ErrorCode `json:"code"`
+	Errors validation.Errors  `json:"errors,omitempty"`
+}
+
+// writeError answers with err as application/problem+json, its status
+// picked by its code.
+func writeError(w http.ResponseWriter, err error) {
+	code := business.CodeOf(err)
+
+	status, ok := codeStatus[code]
+	if !ok {
+		status = 500
+	}
+
+	body := problem{
+		Type:   "urn:problem-type:" + string(code),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: err.Error(),
+		Code:   code,
+	}
+
+	errors.As(err, &body.Errors)
+
+	if unavailable, ok := resilience.IsUnavailable(err); ok && unavailable.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(unavailable.RetryAfter.Seconds()))))
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// invalidRequest marks err as the caller's fault.
+func invalidRequest(err error) error {
+	return &business.Error{Code: business.CodeInvalidRequest, Message: err.Error(), Err: err}
+}
@@ -1,29 +1,84 @@
 package handlers
 
 import (
-	"avenuesec/workflow-poc/cadence/transfer/rabbitmq"
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"encoding/json"
+	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
 type Handler interface {
+	// GetRouter is the /api router, which authenticates every request.
 	GetRouter() *mux.Router
+	// GetPublicRouter is the /api router for callers proving who they are
+	// some other way, such as signed webhooks.
+	GetPublicRouter() *mux.Router
+	// GetRootRouter serves both.
+	GetRootRouter() *mux.Router
 }
 
 type handleImpl struct {
+	root   *mux.Router
 	router *mux.Router
+	public *mux.Router
 }
 
-func NewHandler(rabbit rabbitmq.AmqpConnection) Handler {
-	router := mux.NewRouter().PathPrefix("/api").Subrouter()
+func NewHandler(broker broker.Broker, authenticator auth.Authenticator, transfers business.SdToBankService, search business.TransferSearch, events business.TransferEvents) Handler {
+	root := mux.NewRouter()
 
-	NewTransferHandler(router, rabbit)
+	// Public routes are matched first; everything else falls through to
+	// the authenticated router.
+	document := APIDocument()
+
+	public := root.PathPrefix(apiPrefix).Subrouter()
+	public.Use(envelopeMiddleware, openAPIMiddleware(document))
+
+	router := root.PathPrefix(apiPrefix).Subrouter()
+	router.Use(envelopeMiddleware, authMiddleware(authenticator), openAPIMiddleware(document))
+
+	NewOpenAPIHandler(public, document)
+	NewTransferHandler(router, broker, transfers, search)
+	NewTransferEventsHandler(router, transfers, events)
 
 	return &handleImpl{
-		router,
+		root:   root,
+		router: router,
+		public: public,
 	}
 }
 
 func (h *handleImpl) GetRouter() *mux.Router {
 	return h.router
 }
+
+func (h *handleImpl) GetPublicRouter() *mux.Router {
+	return h.public
+}
+
+func (h *handleImpl) GetRootRouter() *mux.Router {
+	return h.root
+}
+
+// envelopeMiddleware starts the message envelope at the HTTP hop, so
+// messages published while serving a request keep the caller's trace
+// context and correlation id.
+func envelopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		envelope := broker.Envelope{
+			CorrelationID: r.Header.Get("X-Correlation-ID"),
+			TraceParent:   r.Header.Get("traceparent"),
+			TraceState:    r.Header.Get("tracestate"),
+		}
+
+		next.ServeHTTP(w, r.WithContext(broker.WithEnvelope(r.Context(), envelope)))
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
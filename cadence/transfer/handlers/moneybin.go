@@ -1,14 +1,13 @@
 package handlers
 
 import (
+	"avenuesec/workflow-poc/cadence/transfer/broker"
 	"avenuesec/workflow-poc/cadence/transfer/business"
 	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
-	"avenuesec/workflow-poc/cadence/transfer/rabbitmq"
-	"fmt"
-	"log"
-	"reflect"
+	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"context"
 
-	"github.com/golang/protobuf/proto"
+	"github.com/uber-go/tally"
 	"go.uber.org/zap"
 )
 
@@ -16,75 +15,35 @@ type MoneyBinConsumer interface {
 }
 
 type moneyBinConsumerImpl struct {
-	rabbit  rabbitmq.AmqpConnection
-	service business.MoneyBinService
-	logger  *zap.SugaredLogger
+	dispatcher broker.Dispatcher
+	service    business.MoneyBinService
+	logger     *zap.SugaredLogger
 }
 
-func NewMoneyBinConsumer(rabbit rabbitmq.AmqpConnection, service business.AccountService) MoneyBinConsumer {
+func NewMoneyBinConsumer(broker broker.Broker, rd redis.RedisConnection, service business.MoneyBinService, scope tally.Scope) MoneyBinConsumer {
 	logger, _ := zap.NewProduction()
 	logger = logger.Named("moneybin_consumer")
 
 	consumer := &moneyBinConsumerImpl{
-		rabbit:  rabbit,
-		service: service,
-		logger:  logger.Sugar(),
+		dispatcher: newDispatcher(broker, rd, "moneybin_consumer", logger.Sugar(), scope),
+		service:    service,
+		logger:     logger.Sugar(),
 	}
 
-	go consumer.installHandlers()
-
-	return consumer
-}
-
-func (c *moneyBinConsumerImpl) installHandlers() {
-	consumer := c.rabbit.GetChannel()
-
-	msgs, err := consumer.Consume(
-		c.rabbit.GetQueue(), // queue
-		"",                  // consumer
-		true,                // auto-ack
-		false,               // exclusive
-		false,               // no-local
-		false,               // no-wait
-		nil,                 // args
-	)
+	consumer.dispatcher.MustRegister(consumer.handleAddEntry)
 
+	err := consumer.dispatcher.Start()
 	if err != nil {
-		c.logger.DPanicw("Deu ruim", "err", err)
+		consumer.logger.DPanicw("Failed to start moneybin consumer", "err", err)
 	}
 
-	forever := make(chan bool)
-
-	go func() {
-		for d := range msgs {
-			log.Printf("Received a message: %s. Type: %s", d.Body, d.Type)
-
-			messageType := proto.MessageType(d.Type)
-
-			if messageType == nil {
-				errorMsg := fmt.Sprintf("Unknown message type: %s - did you initialize protobuf?", d.Type)
-
-				c.logger.Errorw("error deserializing proto message", "err", errorMsg)
-
-				return
-			}
-
-			v := reflect.New(messageType.Elem())
-			message := v.Interface().(proto.Message)
-
-			err = proto.Unmarshal(d.Body, message)
-			if err != nil {
-				c.logger.Errorw("error unmarshalling message", "err", err)
-				return
-			}
+	return consumer
+}
 
-			switch messageType {
-			case reflect.TypeOf(&pb.AddEntry{}):
+func (c *moneyBinConsumerImpl) handleAddEntry(ctx context.Context, message *pb.AddEntry) error {
+	c.logger.Infow("Entry received", "execution_id", message.ExecutionId, "kind", message.Kind)
 
-			}
-		}
-	}()
+	_, err := c.service.AddEntry(ctx, message)
 
-	c.logger.Info(" [*] MoneyBin Waiting for messages. To exit press CTRL+C")
-	<-forever
+	return err
 }
@@ -0,0 +1,626 @@
+package handlers
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"avenuesec/workflow-poc/cadence/transfer/openapi"
+	"avenuesec/workflow-poc/cadence/transfer/validation"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// apiPrefix is where the API is served, the server of the document.
+const apiPrefix = "/api"
+
+type OpenAPIHandler interface {
+	Document() http.Handler
+}
+
+type openAPIHandlerImpl struct {
+	router   *mux.Router
+	document *openapi.Document
+}
+
+// NewOpenAPIHandler serves the API document at /openapi.json. It describes
+// the API to callers, so it needs no credentials.
+func NewOpenAPIHandler(router *mux.Router, document *openapi.Document) {
+	handler := &openAPIHandlerImpl{router, document}
+	handler.buildRoutes()
+}
+
+func (p *openAPIHandlerImpl) buildRoutes() {
+	p.router.Handle("/openapi.json", p.Document()).Methods("GET")
+}
+
+func (p *openAPIHandlerImpl) Document() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, 200, p.document)
+	})
+}
+
+// openAPIMiddleware checks requests against the operation of their route
+// in the document, answering 400 with the broken fields. Routes the
+// document leaves out are let through.
+func openAPIMiddleware(document *openapi.Document) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			template, err := route.GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			operation, ok := document.Operation(r.Method, strings.TrimPrefix(template, apiPrefix))
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			err = document.ValidateRequest(r, operation, mux.Vars(r))
+
+			var errs validation.Errors
+			if errors.As(err, &errs) {
+				writeError(w, invalidRequest(err))
+				return
+			}
+
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIDocument describes the HTTP API. Accounts and balances are served
+// over gRPC only, by the AccountService.
+func APIDocument() *openapi.Document {
+	return &openapi.Document{
+		OpenAPI: "3.0.3",
+		Info: openapi.Info{
+			Title:       "Transfer API",
+			Description: "Starts and follows SD to bank transfers, and lets operators reconcile and move them along. Errors are application/problem+json with a stable code.",
+			Version:     "1.0.0",
+		},
+		Servers:  []openapi.Server{{URL: apiPrefix}},
+		Security: []openapi.SecurityRequirement{{"bearerAuth": {}}, {"apiKey": {}}},
+		Tags: []openapi.Tag{
+			{Name: "transfers", Description: "Transfers and their status changes."},
+			{Name: "providers", Description: "Payment providers and the routes between them."},
+			{Name: "reconciliation", Description: "Settlement reports and the exception cases they open."},
+			{Name: "admin", Description: "Operations on stuck transfers, for admins only."},
+		},
+		Paths: map[string]openapi.PathItem{
+			"/transfers": {
+				"post": {
+					OperationID: "createTransfer",
+					Summary:     "Start a transfer",
+					Description: "Starts the transfer workflow before answering. Requests with an Idempotency-Key may be sent again and get the transfer the key started.",
+					Tags:        []string{"transfers"},
+					Parameters:  []*openapi.Parameter{idempotencyKeyParam()},
+					RequestBody: jsonBody(openapi.Ref("NewTransfer")),
+					Responses: responses(map[string]*openapi.Response{
+						"201": jsonResponse("The transfer was started.", openapi.Ref("Transfer")),
+						"200": jsonResponse("The transfer the idempotency key started.", openapi.Ref("Transfer")),
+					}, 400, 401, 403, 422),
+				},
+				"get": {
+					OperationID: "listTransfers",
+					Summary:     "List transfers, newest first",
+					Description: "Listing every account needs the support or approver role.",
+					Tags:        []string{"transfers"},
+					Parameters: []*openapi.Parameter{
+						queryParam("acc_id", "Account of the transfers.", &openapi.Schema{Type: "string"}),
+						queryParam("status", "Status of the transfers.", transferStatusSchema()),
+						queryParam("direction", "Direction of the transfers.", &openapi.Schema{Type: "string"}),
+						queryParam("created_from", "Transfers started at or after.", &openapi.Schema{Type: "string", Format: "date-time"}),
+						queryParam("created_to", "Transfers started before.", &openapi.Schema{Type: "string", Format: "date-time"}),
+						queryParam("min_amount", "Smallest amount.", &openapi.Schema{Type: "number"}),
+						queryParam("max_amount", "Largest amount.", &openapi.Schema{Type: "number"}),
+						queryParam("limit", "Page size.", &openapi.Schema{Type: "integer", Minimum: float64Ptr(0)}),
+						queryParam("cursor", "next_cursor of the last page.", &openapi.Schema{Type: "string"}),
+					},
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("A page of transfers.", openapi.Ref("TransferPage")),
+					}, 400, 401, 403),
+				},
+			},
+			"/transfers/new": {
+				"post": {
+					OperationID: "queueTransfer",
+					Summary:     "Queue a transfer",
+					Description: "Queues the transfer for the consumer, for bulk producers. Follow it by its correlation id.",
+					Tags:        []string{"transfers"},
+					RequestBody: jsonBody(openapi.Ref("NewTransfer")),
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("The transfer was queued.", openapi.Ref("QueuedTransfer")),
+					}, 400, 401, 403),
+				},
+			},
+			"/transfers/{id}": {
+				"get": {
+					OperationID: "getTransfer",
+					Summary:     "Get a transfer",
+					Tags:        []string{"transfers"},
+					Parameters:  []*openapi.Parameter{transferIDParam()},
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("The transfer.", openapi.Ref("Transfer")),
+					}, 400, 401, 403, 404),
+				},
+			},
+			"/transfers/{id}/approve": {
+				"post": {
+					OperationID: "approveTransfer",
+					Summary:     "Approve a validated transfer",
+					Description: "Needs the approver role.",
+					Tags:        []string{"transfers"},
+					Parameters:  []*openapi.Parameter{transferIDParam()},
+					Responses: responses(map[string]*openapi.Response{
+						"202": jsonResponse("The transfer goes on to block the funds.", openapi.Ref("Transfer")),
+					}, 400, 401, 403, 404, 409),
+				},
+			},
+			"/transfers/{id}/cancel": {
+				"post": {
+					OperationID: "cancelTransfer",
+					Summary:     "Cancel a transfer before its funds are blocked",
+					Description: "Callers cancel their own transfers, approvers any.",
+					Tags:        []string{"transfers"},
+					Parameters:  []*openapi.Parameter{transferIDParam()},
+					RequestBody: optionalJSONBody(openapi.Ref("ReasonRequest")),
+					Responses: responses(map[string]*openapi.Response{
+						"202": jsonResponse("The transfer is being canceled.", openapi.Ref("Transfer")),
+					}, 400, 401, 403, 404, 409),
+				},
+			},
+			"/transfers/{id}/events": {
+				"get": {
+					OperationID: "transferEvents",
+					Summary:     "Stream the status changes of a transfer",
+					Description: "Server-Sent Events of type status until the transfer is done, or a WebSocket of TransferEvent messages on an upgrade request. Browsers may pass their token as access_token.",
+					Tags:        []string{"transfers"},
+					Parameters: []*openapi.Parameter{
+						transferIDParam(),
+						{Name: "Last-Event-ID", In: openapi.InHeader, Description: "Resume after this event.", Schema: &openapi.Schema{Type: "string"}},
+						queryParam("last_event_id", "Resume after this event.", &openapi.Schema{Type: "string"}),
+						queryParam("access_token", "Bearer token, for clients that cannot set headers.", &openapi.Schema{Type: "string"}),
+					},
+					Responses: responses(map[string]*openapi.Response{
+						"200": {
+							Description: "The stream of events.",
+							Content:     map[string]*openapi.MediaType{"text/event-stream": {Schema: openapi.Ref("TransferEvent")}},
+						},
+						"204": {Description: "The transfer is done and has no events left."},
+					}, 400, 401, 403, 404),
+				},
+			},
+			"/providers": {
+				"get": {
+					OperationID: "listProviders",
+					Summary:     "List payment providers and routes",
+					Description: "Needs the support role.",
+					Tags:        []string{"providers"},
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("The providers and routes.", openapi.Ref("Providers")),
+					}, 401, 403),
+				},
+			},
+			"/reconciliation/reports": {
+				"get": {
+					OperationID: "listReports",
+					Summary:     "List reconciliation report ids",
+					Description: "Needs the support role.",
+					Tags:        []string{"reconciliation"},
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("The report ids.", object(nil, map[string]*openapi.Schema{
+							"reports": array(&openapi.Schema{Type: "string"}),
+						})),
+					}, 401, 403),
+				},
+			},
+			"/reconciliation/reports/{id}": {
+				"get": {
+					OperationID: "getReport",
+					Summary:     "Get a reconciliation report",
+					Description: "Needs the support role.",
+					Tags:        []string{"reconciliation"},
+					Parameters:  []*openapi.Parameter{pathParam("id", "Report id.")},
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("The report.", openapi.Ref("Report")),
+					}, 401, 403, 404),
+				},
+			},
+			"/reconciliation/cases": {
+				"get": {
+					OperationID: "listCases",
+					Summary:     "List exception cases",
+					Description: "Needs the support role.",
+					Tags:        []string{"reconciliation"},
+					Parameters: []*openapi.Parameter{
+						queryParam("status", "Status of the cases.", enum("string", "open", "resolved")),
+					},
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("The cases.", object(nil, map[string]*openapi.Schema{
+							"cases": array(openapi.Ref("Case")),
+						})),
+					}, 400, 401, 403),
+				},
+				"post": {
+					OperationID: "openCase",
+					Summary:     "Open a case by hand",
+					Description: "For a mismatch found outside the daily reconciliation. Needs the support role.",
+					Tags:        []string{"reconciliation"},
+					RequestBody: jsonBody(openapi.Ref("NewCase")),
+					Responses: responses(map[string]*openapi.Response{
+						"201": jsonResponse("The case was opened.", openapi.Ref("Case")),
+					}, 400, 401, 403),
+				},
+			},
+			"/reconciliation/cases/{id}": {
+				"get": {
+					OperationID: "getCase",
+					Summary:     "Get an exception case",
+					Description: "Needs the support role.",
+					Tags:        []string{"reconciliation"},
+					Parameters:  []*openapi.Parameter{pathParam("id", "Case id.")},
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("The case.", openapi.Ref("Case")),
+					}, 401, 403, 404),
+				},
+			},
+			"/reconciliation/cases/{id}/resolve": {
+				"post": {
+					OperationID: "resolveCase",
+					Summary:     "Resolve an exception case",
+					Description: "Needs the admin role.",
+					Tags:        []string{"reconciliation"},
+					Parameters:  []*openapi.Parameter{pathParam("id", "Case id.")},
+					RequestBody: jsonBody(object([]string{"resolution"}, map[string]*openapi.Schema{
+						"resolution": {Type: "string", MinLength: intPtr(1)},
+					})),
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("The resolved case.", openapi.Ref("Case")),
+					}, 400, 401, 403, 404, 409),
+				},
+			},
+			"/admin/breakers": {
+				"get": {
+					OperationID: "listBreakers",
+					Summary:     "Show the breaker and bulkhead of every provider",
+					Description: "Needs the support role.",
+					Tags:        []string{"admin"},
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("The breakers.", object(nil, map[string]*openapi.Schema{
+							"breakers": array(openapi.Ref("Breaker")),
+						})),
+					}, 401, 403),
+				},
+			},
+			"/admin/transfers/{id}/signal": adminOperation("signalTransfer", "Send a trigger to the workflow",
+				jsonBody(openapi.Ref("SignalRequest"))),
+			"/admin/transfers/{id}/retry": adminOperation("retryTransferActivity", "Run the last failed activity again",
+				optionalJSONBody(openapi.Ref("ReasonRequest"))),
+			"/admin/transfers/{id}/reset": adminOperation("resetTransfer", "Reset the workflow to a step",
+				jsonBody(openapi.Ref("ResetRequest"))),
+			"/admin/transfers/{id}/terminate": adminOperation("terminateTransfer", "Terminate the workflow",
+				jsonBody(openapi.Ref("TerminateRequest"))),
+			"/admin/transfers/{id}/release-hold": {
+				"post": {
+					OperationID: "releaseTransferHold",
+					Summary:     "Give the funds held for the transfer back",
+					Description: "Needs the admin role. The workflow must be closed, terminated if need be, and the body needs a reason.",
+					Tags:        []string{"admin"},
+					Parameters:  []*openapi.Parameter{pathParam("id", "Execution id of the workflow.")},
+					RequestBody: jsonBody(openapi.Ref("TerminateRequest")),
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("The released hold.", openapi.Ref("Hold")),
+					}, 400, 401, 403, 404, 409),
+				},
+			},
+			"/admin/transfers/{id}/history": {
+				"get": {
+					OperationID: "transferHistory",
+					Summary:     "Read the raw workflow history",
+					Description: "Needs the admin role.",
+					Tags:        []string{"admin"},
+					Parameters: []*openapi.Parameter{
+						pathParam("id", "Execution id of the workflow."),
+						queryParam("page_token", "next_page_token of the last page.", &openapi.Schema{Type: "string", Format: "byte"}),
+					},
+					Responses: responses(map[string]*openapi.Response{
+						"200": jsonResponse("A page of history events.", openapi.Ref("HistoryPage")),
+					}, 400, 401, 403, 404),
+				},
+			},
+		},
+		Components: openapi.Components{
+			Schemas: apiSchemas(),
+			Responses: map[string]*openapi.Response{
+				"Problem": {
+					Description: "The request failed. The code tells why.",
+					Content:     map[string]*openapi.MediaType{"application/problem+json": {Schema: openapi.Ref("Problem")}},
+				},
+			},
+			SecuritySchemes: map[string]*openapi.SecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+				"apiKey":     {Type: "apiKey", In: "header", Name: "X-API-Key"},
+			},
+		},
+	}
+}
+
+func apiSchemas() map[string]*openapi.Schema {
+	return map[string]*openapi.Schema{
+		"NewTransfer": object([]string{"acc_id", "amount"}, map[string]*openapi.Schema{
+			"acc_id": {Type: "string", Format: "uuid"},
+			"amount": {
+				Type:       "number",
+				Minimum:    float64Ptr(validation.MinTransferAmount),
+				Maximum:    float64Ptr(validation.MaxTransferAmount),
+				MultipleOf: float64Ptr(0.01),
+				Example:    100.5,
+			},
+			"direction": {Type: "integer", Enum: []interface{}{0}, Description: "0 is SdToBank, the default."},
+		}),
+		"Transfer": object(nil, map[string]*openapi.Schema{
+			"execution_id": {Type: "string", Description: "The transfer id."},
+			"status":       transferStatusSchema(),
+			"acc_id":       {Type: "string"},
+			"amount":       {Type: "number"},
+			"direction":    {Type: "string"},
+		}),
+		"TransferSummary": object(nil, map[string]*openapi.Schema{
+			"execution_id": {Type: "string"},
+			"acc_id":       {Type: "string"},
+			"status":       transferStatusSchema(),
+			"direction":    {Type: "string"},
+			"amount":       {Type: "number"},
+			"created_at":   {Type: "string", Format: "date-time"},
+		}),
+		"TransferPage": object(nil, map[string]*openapi.Schema{
+			"transfers":   array(openapi.Ref("TransferSummary")),
+			"next_cursor": {Type: "string", Description: "Missing on the last page."},
+		}),
+		"QueuedTransfer": object(nil, map[string]*openapi.Schema{
+			"status":         {Type: "string"},
+			"correlation_id": {Type: "string", Description: "The id the transfer will have."},
+		}),
+		"TransferEvent": object(nil, map[string]*openapi.Schema{
+			"id":           {Type: "string"},
+			"execution_id": {Type: "string"},
+			"status":       transferStatusSchema(),
+			"at":           {Type: "string", Format: "date-time"},
+		}),
+		"ReasonRequest": object(nil, map[string]*openapi.Schema{
+			"reason": {Type: "string"},
+		}),
+		"SignalRequest": object([]string{"trigger"}, map[string]*openapi.Schema{
+			"trigger": signalTriggerSchema(),
+			"reason":  {Type: "string"},
+		}),
+		"ResetRequest": object([]string{"step"}, map[string]*openapi.Schema{
+			"step":         resetStepSchema(),
+			"reason":       {Type: "string"},
+			"skip_signals": {Type: "boolean", Description: "Leave out the signals received after the step."},
+		}),
+		"TerminateRequest": object([]string{"reason"}, map[string]*openapi.Schema{
+			"reason": {Type: "string", MinLength: intPtr(1)},
+		}),
+		"Hold": object(nil, map[string]*openapi.Schema{
+			"account_id":   {Type: "string"},
+			"execution_id": {Type: "string"},
+			"amount":       {Type: "number"},
+		}),
+		"HistoryPage": object(nil, map[string]*openapi.Schema{
+			"events":          array(&openapi.Schema{Type: "object", Description: "A Cadence history event."}),
+			"next_page_token": {Type: "string", Format: "byte", Description: "Missing on the last page."},
+		}),
+		"Providers": object(nil, map[string]*openapi.Schema{
+			"providers": array(&openapi.Schema{Type: "object"}),
+			"routes":    array(&openapi.Schema{Type: "object"}),
+		}),
+		"Breaker": object(nil, map[string]*openapi.Schema{
+			"provider":       {Type: "string"},
+			"state":          {Type: "string"},
+			"failures":       {Type: "integer"},
+			"retry_at":       {Type: "string", Format: "date-time"},
+			"in_flight":      {Type: "integer"},
+			"max_concurrent": {Type: "integer"},
+		}),
+		"Report": object(nil, map[string]*openapi.Schema{
+			"id":              {Type: "string"},
+			"file":            {Type: "string"},
+			"settlement_date": {Type: "string"},
+			"lines":           {Type: "integer"},
+			"transfers":       {Type: "integer"},
+			"matched":         {Type: "integer"},
+			"mismatches":      array(&openapi.Schema{Type: "object"}),
+			"cases":           array(&openapi.Schema{Type: "string"}),
+			"created_at":      {Type: "string", Format: "date-time"},
+		}),
+		"NewCase": object([]string{"kind", "execution_id"}, map[string]*openapi.Schema{
+			"id":                 {Type: "string", Description: "Picked by the server when missing."},
+			"kind":               {Type: "string", MinLength: intPtr(1)},
+			"execution_id":       {Type: "string", MinLength: intPtr(1)},
+			"our_amount_cents":   {Type: "integer"},
+			"their_amount_cents": {Type: "integer"},
+			"note":               {Type: "string"},
+		}),
+		"Case": object(nil, map[string]*openapi.Schema{
+			"id":                 {Type: "string"},
+			"report":             {Type: "string"},
+			"settlement_date":    {Type: "string"},
+			"kind":               {Type: "string"},
+			"execution_id":       {Type: "string"},
+			"our_amount_cents":   {Type: "integer"},
+			"their_amount_cents": {Type: "integer"},
+			"note":               {Type: "string"},
+			"status":             enum("string", "open", "resolved"),
+			"resolution":         {Type: "string"},
+			"opened_at":          {Type: "string", Format: "date-time"},
+			"resolved_at":        {Type: "string", Format: "date-time"},
+		}),
+		"Problem": object([]string{"type", "title", "status", "code"}, map[string]*openapi.Schema{
+			"type":   {Type: "string", Description: "urn:problem-type: followed by the code."},
+			"title":  {Type: "string"},
+			"status": {Type: "integer"},
+			"detail": {Type: "string"},
+			"code":   errorCodeSchema(),
+			"errors": array(openapi.Ref("FieldError")),
+		}),
+		"FieldError": object(nil, map[string]*openapi.Schema{
+			"field":   {Type: "string"},
+			"rule":    {Type: "string"},
+			"message": {Type: "string"},
+		}),
+	}
+}
+
+func adminOperation(operationID, summary string, body *openapi.RequestBody) openapi.PathItem {
+	return openapi.PathItem{
+		"post": {
+			OperationID: operationID,
+			Summary:     summary,
+			Description: "Needs the admin role. Answers the transfer, or nothing for workflows without one.",
+			Tags:        []string{"admin"},
+			Parameters:  []*openapi.Parameter{pathParam("id", "Execution id of the workflow.")},
+			RequestBody: body,
+			Responses: responses(map[string]*openapi.Response{
+				"202": jsonResponse("The workflow was acted on.", openapi.Ref("Transfer")),
+			}, 400, 401, 403, 404, 409),
+		},
+	}
+}
+
+// responses adds the problem responses of the statuses to the others.
+func responses(others map[string]*openapi.Response, statuses ...int) map[string]*openapi.Response {
+	for _, status := range statuses {
+		others[strconv.Itoa(status)] = openapi.ResponseRef("Problem")
+	}
+
+	others["default"] = openapi.ResponseRef("Problem")
+
+	return others
+}
+
+func jsonResponse(description string, schema *openapi.Schema) *openapi.Response {
+	return &openapi.Response{
+		Description: description,
+		Content:     map[string]*openapi.MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+func jsonBody(schema *openapi.Schema) *openapi.RequestBody {
+	return &openapi.RequestBody{
+		Required: true,
+		Content:  map[string]*openapi.MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+func optionalJSONBody(schema *openapi.Schema) *openapi.RequestBody {
+	body := jsonBody(schema)
+	body.Required = false
+
+	return body
+}
+
+func transferIDParam() *openapi.Parameter {
+	param := pathParam("id", "The transfer id.")
+	param.Schema.Pattern = "^sdtobank_"
+
+	return param
+}
+
+func idempotencyKeyParam() *openapi.Parameter {
+	return &openapi.Parameter{
+		Name:        idempotencyKeyHeader,
+		In:          openapi.InHeader,
+		Description: "Makes the request safe to send again. Keys are scoped by caller.",
+		Schema:      &openapi.Schema{Type: "string", MaxLength: intPtr(255)},
+	}
+}
+
+func pathParam(name, description string) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: openapi.InPath, Description: description, Required: true, Schema: &openapi.Schema{Type: "string"}}
+}
+
+func queryParam(name, description string, schema *openapi.Schema) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: openapi.InQuery, Description: description, Schema: schema}
+}
+
+func object(required []string, properties map[string]*openapi.Schema) *openapi.Schema {
+	return &openapi.Schema{Type: "object", Required: required, Properties: properties}
+}
+
+func array(items *openapi.Schema) *openapi.Schema {
+	return &openapi.Schema{Type: "array", Items: items}
+}
+
+func enum(kind string, values ...string) *openapi.Schema {
+	schema := &openapi.Schema{Type: kind}
+	for _, value := range values {
+		schema.Enum = append(schema.Enum, value)
+	}
+
+	return schema
+}
+
+func transferStatusSchema() *openapi.Schema {
+	return enum("string",
+		business.TransferStatusStarting,
+		business.TransferStatusValidated,
+		business.TransferStatusBlocked,
+		business.TransferStatusDebited,
+		business.TransferStatusCreditQueued,
+		business.TransferStatusCompleted,
+		business.TransferStatusReturned,
+		business.TransferStatusFailed,
+		business.TransferStatusCanceled,
+		business.TransferStatusTerminated,
+	)
+}
+
+func signalTriggerSchema() *openapi.Schema {
+	var triggers []string
+	for _, trigger := range business.SignalTriggers() {
+		triggers = append(triggers, string(trigger))
+	}
+
+	return enum("string", triggers...)
+}
+
+func resetStepSchema() *openapi.Schema {
+	return enum("string", business.ResetSteps()...)
+}
+
+// errorCodeSchema lists the codes errors are answered with.
+func errorCodeSchema() *openapi.Schema {
+	codes := []string{string(business.CodeInternal)}
+	for code := range codeStatus {
+		codes = append(codes, string(code))
+	}
+
+	sort.Strings(codes)
+
+	return enum("string", codes...)
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func intPtr(v int) *int {
+	return &v
+}
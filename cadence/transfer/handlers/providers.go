@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type providerHandlerImpl struct {
+	router   *mux.Router
+	registry business.ProviderRegistry
+}
+
+// NewProviderHandler lists the registered payment providers and the routes
+// between them.
+func NewProviderHandler(router *mux.Router, registry business.ProviderRegistry) {
+	handler := &providerHandlerImpl{router, registry}
+	handler.buildRoutes()
+}
+
+func (p *providerHandlerImpl) buildRoutes() {
+	p.router.Handle("/providers", p.ListProviders()).Methods("GET")
+}
+
+func (p *providerHandlerImpl) ListProviders() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, auth.RoleSupport) {
+			return
+		}
+
+		data := map[string]interface{}{
+			"providers": p.registry.Providers(),
+			"routes":    p.registry.Routes(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	})
+}
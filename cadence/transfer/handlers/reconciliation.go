@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"avenuesec/workflow-poc/cadence/transfer/reconciliation"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+)
+
+type reconciliationHandlerImpl struct {
+	router *mux.Router
+	store  reconciliation.Store
+}
+
+// NewReconciliationHandler serves the settlement reconciliation reports and
+// the exception cases they open, which operators work through and resolve.
+func NewReconciliationHandler(router *mux.Router, store reconciliation.Store) {
+	handler := &reconciliationHandlerImpl{router, store}
+	handler.buildRoutes()
+}
+
+func (p *reconciliationHandlerImpl) buildRoutes() {
+	router := p.router.PathPrefix("/reconciliation").Subrouter()
+
+	router.Handle("/reports", p.ListReports()).Methods("GET")
+	router.Handle("/reports/{id}", p.GetReport()).Methods("GET")
+	router.Handle("/cases", p.ListCases()).Methods("GET")
+	router.Handle("/cases", p.OpenCase()).Methods("POST")
+	router.Handle("/cases/{id}", p.GetCase()).Methods("GET")
+	router.Handle("/cases/{id}/resolve", p.ResolveCase()).Methods("POST")
+}
+
+func (p *reconciliationHandlerImpl) ListReports() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, auth.RoleSupport) {
+			return
+		}
+
+		ids, err := p.store.Reports(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, 200, map[string]interface{}{"reports": ids})
+	})
+}
+
+func (p *reconciliationHandlerImpl) GetReport() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, auth.RoleSupport) {
+			return
+		}
+
+		report, err := p.store.Report(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, reconciliationError(err))
+			return
+		}
+
+		writeJSON(w, 200, report)
+	})
+}
+
+func (p *reconciliationHandlerImpl) ListCases() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, auth.RoleSupport) {
+			return
+		}
+
+		status := r.URL.Query().Get("status")
+		if status != "" && status != reconciliation.CaseOpen && status != reconciliation.CaseResolved {
+			writeError(w, business.NewError(business.CodeInvalidRequest, "status must be open or resolved"))
+			return
+		}
+
+		cases, err := p.store.Cases(r.Context(), status)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if cases == nil {
+			cases = []*reconciliation.Case{}
+		}
+
+		writeJSON(w, 200, map[string]interface{}{"cases": cases})
+	})
+}
+
+// OpenCase opens a case by hand, for a mismatch found outside the daily
+// reconciliation.
+func (p *reconciliationHandlerImpl) OpenCase() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, auth.RoleSupport) {
+			return
+		}
+
+		var c reconciliation.Case
+
+		err := json.NewDecoder(r.Body).Decode(&c)
+		if err != nil {
+			writeError(w, invalidRequest(err))
+			return
+		}
+
+		if c.Kind == "" || c.ExecutionID == "" {
+			writeError(w, business.NewError(business.CodeInvalidRequest, "kind and execution_id are required"))
+			return
+		}
+
+		if c.ID == "" {
+			c.ID = "manual_" + uuid.New()
+		}
+
+		opened, err := p.store.OpenCase(r.Context(), c)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, 201, opened)
+	})
+}
+
+func (p *reconciliationHandlerImpl) GetCase() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, auth.RoleSupport) {
+			return
+		}
+
+		c, err := p.store.Case(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, reconciliationError(err))
+			return
+		}
+
+		writeJSON(w, 200, c)
+	})
+}
+
+func (p *reconciliationHandlerImpl) ResolveCase() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, auth.RoleAdmin) {
+			return
+		}
+
+		var body struct {
+			Resolution string `json:"resolution"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil || body.Resolution == "" {
+			writeError(w, business.NewError(business.CodeInvalidRequest, "resolution is required"))
+			return
+		}
+
+		c, err := p.store.ResolveCase(r.Context(), mux.Vars(r)["id"], body.Resolution)
+		if err != nil {
+			writeError(w, reconciliationError(err))
+			return
+		}
+
+		writeJSON(w, 200, c)
+	})
+}
+
+// reconciliationError gives the errors of the store their codes.
+func reconciliationError(err error) error {
+	switch {
+	case errors.Is(err, reconciliation.ErrReportNotFound):
+		return business.Errorf(business.CodeReportNotFound, "%w", err)
+	case errors.Is(err, reconciliation.ErrCaseNotFound):
+		return business.Errorf(business.CodeCaseNotFound, "%w", err)
+	case errors.Is(err, reconciliation.ErrCaseResolved):
+		return business.Errorf(business.CodeCaseResolved, "%w", err)
+	}
+
+	return err
+}
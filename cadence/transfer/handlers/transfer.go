@@ -1,76 +1,355 @@
 package handlers
 
 import (
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	"avenuesec/workflow-poc/cadence/transfer/business"
 	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
-	"avenuesec/workflow-poc/cadence/transfer/rabbitmq"
-	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+const (
+	transferRoute = "transfer"
+	// idempotencyKeyHeader makes creating a transfer safe to retry.
+	idempotencyKeyHeader = "Idempotency-Key"
+)
+
 type TransferHandler interface {
+	CreateTransfer() http.Handler
 	StartTransfer() http.Handler
+	GetTransfer() http.Handler
+	ListTransfers() http.Handler
+	ApproveTransfer() http.Handler
+	CancelTransfer() http.Handler
 }
 
 type transferHandlerImpl struct {
-	router *mux.Router
-	rabbit rabbitmq.AmqpConnection
+	router  *mux.Router
+	broker  broker.Broker
+	service business.SdToBankService
+	search  business.TransferSearch
+}
+
+// transferResponse is a transfer as the API shows it.
+type transferResponse struct {
+	ExecutionID string  `json:"execution_id"`
+	Status      string  `json:"status"`
+	AccID       string  `json:"acc_id"`
+	Amount      float64 `json:"amount"`
+	Direction   string  `json:"direction"`
 }
 
-func NewTransferHandler(router *mux.Router, rabbit rabbitmq.AmqpConnection) {
-	handler := &transferHandlerImpl{router, rabbit}
+func NewTransferHandler(router *mux.Router, broker broker.Broker, service business.SdToBankService, search business.TransferSearch) {
+	handler := &transferHandlerImpl{router, broker, service, search}
 	handler.buildRoutes()
 }
 
 func (p *transferHandlerImpl) buildRoutes() {
 	router := p.router.PathPrefix("/transfers").Subrouter()
 
+	router.Handle("", p.CreateTransfer()).Methods("POST")
+	router.Handle("", p.ListTransfers()).Methods("GET")
 	router.Handle("/new", p.StartTransfer()).Methods("POST")
+	router.Handle("/{id}", p.GetTransfer()).Methods("GET").Name(transferRoute)
+	router.Handle("/{id}/approve", p.ApproveTransfer()).Methods("POST")
+	router.Handle("/{id}/cancel", p.CancelTransfer()).Methods("POST")
 }
 
-func (p *transferHandlerImpl) StartTransfer() http.Handler {
+// CreateTransfer starts the transfer workflow before answering, so the
+// caller gets the transfer id and where to follow it. Bulk producers keep
+// using StartTransfer, which only queues the message.
+//
+// Requests with an Idempotency-Key header may be sent again: the transfer
+// the key started is answered with 200, or 422 when the request differs.
+func (p *transferHandlerImpl) CreateTransfer() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message pb.NewTransferMessage
+
+		if !decode(w, r, &message) || !allowAccount(w, r, message.AccId) {
+			return
+		}
+
+		executionID := business.NewSdToBankExecutionID()
+		key := r.Header.Get(idempotencyKeyHeader)
+
+		if key != "" {
+			principal, _ := auth.FromContext(r.Context())
+			executionID = business.IdempotentExecutionID(principal.Subject, key)
+
+			transfer, err := p.service.GetTransferInformation(r.Context(), executionID)
+			if err == nil {
+				p.replay(w, &message, transfer)
+				return
+			}
+
+			if !errors.Is(err, business.ErrTransferNotFound) {
+				writeError(w, err)
+				return
+			}
+		}
+
+		ctx := broker.WithCorrelationID(r.Context(), executionID)
 
-		var body []byte
-		var message *pb.NewTransferMessage
+		executionID, err := p.service.StartTransfer(ctx, &message)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 
-		_, err := r.Body.Read(body)
+		transfer, err := p.service.GetTransferInformation(ctx, executionID)
 		if err != nil {
-			http.Error(w, err.Error(), 400)
+			writeError(w, err)
+			return
+		}
+
+		// A concurrent request with the same key may have stored its
+		// transfer first, and StartTransfer then carries on with that one.
+		if key != "" && !business.SameTransfer(&message, transfer) {
+			writeError(w, business.ErrIdempotencyKeyReused)
+			return
+		}
+
+		w.Header().Set("Location", p.transferURL(executionID))
+		w.Header().Set("X-Correlation-ID", executionID)
+		writeJSON(w, 201, newTransferResponse(transfer))
+	})
+}
+
+// replay answers a request repeating an idempotency key with the transfer
+// the key started.
+func (p *transferHandlerImpl) replay(w http.ResponseWriter, message *pb.NewTransferMessage, transfer *pb.Transfer) {
+	if !business.SameTransfer(message, transfer) {
+		writeError(w, business.ErrIdempotencyKeyReused)
+		return
+	}
+
+	w.Header().Set("Location", p.transferURL(transfer.ExecutionId))
+	w.Header().Set("X-Correlation-ID", transfer.ExecutionId)
+	writeJSON(w, 200, newTransferResponse(transfer))
+}
+
+func (p *transferHandlerImpl) GetTransfer() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		transfer, ok := p.transfer(w, r, readAnyAccount...)
+		if !ok {
+			return
+		}
+
+		writeJSON(w, 200, newTransferResponse(transfer))
+	})
+}
+
+// ApproveTransfer lets a validated transfer go on to block the funds.
+func (p *transferHandlerImpl) ApproveTransfer() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, r, auth.RoleApprover) {
+			return
+		}
+
+		transfer, ok := p.transfer(w, r, auth.RoleApprover)
+		if !ok {
+			return
+		}
+
+		err := p.service.Approve(r.Context(), transfer.ExecutionId)
+		p.transition(w, r, err)
+	})
+}
+
+// CancelTransfer stops a transfer before its funds are blocked. Callers
+// cancel their own transfers, approvers any.
+func (p *transferHandlerImpl) CancelTransfer() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Reason string `json:"reason"`
+		}
+
+		if r.ContentLength != 0 {
+			err := json.NewDecoder(r.Body).Decode(&body)
+			if err != nil {
+				writeError(w, invalidRequest(err))
+				return
+			}
+		}
+
+		transfer, ok := p.transfer(w, r, auth.RoleApprover)
+		if !ok {
 			return
 		}
 
-		err = json.NewDecoder(r.Body).Decode(&message)
+		err := p.service.Cancel(r.Context(), transfer.ExecutionId, body.Reason)
+		p.transition(w, r, err)
+	})
+}
+
+// transfer loads the transfer of the route, answering 404 when there is
+// none and 403 unless the caller may act for its account or has one of
+// the roles.
+func (p *transferHandlerImpl) transfer(w http.ResponseWriter, r *http.Request, roles ...auth.Role) (*pb.Transfer, bool) {
+	transfer, err := p.service.GetTransferInformation(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, err)
+		return nil, false
+	}
+
+	if !allowAccount(w, r, transfer.AccId, roles...) {
+		return nil, false
+	}
+
+	return transfer, true
+}
+
+// transition answers a request moving a transfer along, with the transfer
+// once it was moved.
+func (p *transferHandlerImpl) transition(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	transfer, err := p.service.GetTransferInformation(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, 202, newTransferResponse(transfer))
+}
+
+// ListTransfers lists transfers newest first. Filters are acc_id, status,
+// direction, created_from and created_to (RFC 3339), min_amount and
+// max_amount; the next page is asked for with the cursor of the last one.
+func (p *transferHandlerImpl) ListTransfers() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		filter, err := transferFilter(query)
 		if err != nil {
-			http.Error(w, err.Error(), 400)
+			writeError(w, invalidRequest(err))
+			return
+		}
+
+		// Listing every account is for those who may see any of them.
+		if !allowAccount(w, r, filter.AccID, readAnyAccount...) {
 			return
 		}
 
-		err = p.rabbit.ProduceStruct(context.Background(), message)
+		limit := 0
+		if value := query.Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				writeError(w, business.NewError(business.CodeInvalidRequest, "invalid limit"))
+				return
+			}
+		}
 
+		page, err := p.search.List(r.Context(), filter, query.Get("cursor"), limit)
 		if err != nil {
-			http.Error(w, err.Error(), 400)
+			writeError(w, err)
 			return
 		}
 
-		w.WriteHeader(200)
+		writeJSON(w, 200, page)
+	})
+}
+
+func transferFilter(query url.Values) (business.TransferFilter, error) {
+	filter := business.TransferFilter{
+		AccID:     query.Get("acc_id"),
+		Status:    query.Get("status"),
+		Direction: query.Get("direction"),
+	}
 
-		data := map[string]string{
-			"status": "ok",
+	var err error
+
+	if value := query.Get("created_from"); value != "" {
+		filter.From, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("created_from: %w", err)
 		}
+	}
 
-		jso, err := json.Marshal(data)
+	if value := query.Get("created_to"); value != "" {
+		filter.To, err = time.Parse(time.RFC3339, value)
 		if err != nil {
-			http.Error(w, err.Error(), 400)
+			return filter, fmt.Errorf("created_to: %w", err)
+		}
+	}
+
+	filter.MinAmount, err = amountParam(query, "min_amount")
+	if err != nil {
+		return filter, err
+	}
+
+	filter.MaxAmount, err = amountParam(query, "max_amount")
+
+	return filter, err
+}
+
+func amountParam(query url.Values, name string) (*float64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return &amount, nil
+}
+
+func (p *transferHandlerImpl) transferURL(executionID string) string {
+	location, err := p.router.Get(transferRoute).URL("id", executionID)
+	if err != nil {
+		return ""
+	}
+
+	return location.String()
+}
+
+func newTransferResponse(transfer *pb.Transfer) transferResponse {
+	return transferResponse{
+		ExecutionID: transfer.ExecutionId,
+		Status:      transfer.Status,
+		AccID:       transfer.AccId,
+		Amount:      transfer.Amount,
+		Direction:   transfer.Direction.String(),
+	}
+}
+
+func (p *transferHandlerImpl) StartTransfer() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message pb.NewTransferMessage
+
+		if !decode(w, r, &message) || !allowAccount(w, r, message.AccId) {
 			return
 		}
 
-		if data != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.Write(jso)
+		// The transfer is correlated by the execution id of its workflow, so
+		// the id is chosen here and handed to the consumer in the envelope.
+		executionID := business.NewSdToBankExecutionID()
+		ctx := broker.WithCorrelationID(r.Context(), executionID)
+
+		err := broker.ProduceStruct(ctx, p.broker, &message)
+		if err != nil {
+			writeError(w, err)
 			return
 		}
+
+		w.Header().Set("X-Correlation-ID", executionID)
+		writeJSON(w, 200, map[string]string{
+			"status":         "ok",
+			"correlation_id": executionID,
+		})
 	})
 }
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+const (
+	// eventsWait is how long a stream waits for an event before sending a
+	// keepalive.
+	eventsWait = 5 * time.Second
+	// sseStreamMax is how long a Server-Sent Events response lasts. The
+	// server's 15 second write timeout applies to the whole response, so
+	// the stream ends cleanly before it, eventsWait included, and the
+	// EventSource resumes from its last event.
+	sseStreamMax = 8 * time.Second
+	// eventsRetry is how long an EventSource waits before reconnecting.
+	eventsRetry = 3 * time.Second
+)
+
+type TransferEventsHandler interface {
+	Events() http.Handler
+}
+
+type transferEventsHandlerImpl struct {
+	router   *mux.Router
+	service  business.SdToBankService
+	events   business.TransferEvents
+	upgrader websocket.Upgrader
+	logger   *zap.SugaredLogger
+}
+
+func NewTransferEventsHandler(router *mux.Router, service business.SdToBankService, events business.TransferEvents) {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("transfer_events_handler")
+
+	handler := &transferEventsHandlerImpl{
+		router:  router,
+		service: service,
+		events:  events,
+		// Any origin is allowed, as for the rest of the API.
+		upgrader: websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		logger:   logger.Sugar(),
+	}
+	handler.buildRoutes()
+}
+
+func (p *transferEventsHandlerImpl) buildRoutes() {
+	p.router.Handle("/transfers/{id}/events", p.Events()).Methods("GET")
+}
+
+// Events streams the status changes of a transfer until it is done, as
+// Server-Sent Events or, on an upgrade request, over a WebSocket. Clients
+// resume after the event in the Last-Event-ID header or the last_event_id
+// parameter.
+func (p *transferEventsHandlerImpl) Events() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		executionID := mux.Vars(r)["id"]
+
+		transfer, err := p.service.GetTransferInformation(r.Context(), executionID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if !allowAccount(w, r, transfer.AccId, readAnyAccount...) {
+			return
+		}
+
+		lastID := r.Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = r.URL.Query().Get("last_event_id")
+		}
+
+		// Nothing more will happen to a finished transfer, which a 204
+		// tells an EventSource to stop reconnecting for.
+		pending, err := p.events.Read(r.Context(), executionID, lastID, 0)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if len(pending) == 0 && business.IsFinalTransferStatus(transfer.Status) {
+			w.WriteHeader(204)
+			return
+		}
+
+		if websocket.IsWebSocketUpgrade(r) {
+			p.serveWebSocket(w, r, executionID, lastID)
+			return
+		}
+
+		p.serveSSE(w, r, executionID, lastID)
+	})
+}
+
+func (p *transferEventsHandlerImpl) serveSSE(w http.ResponseWriter, r *http.Request, executionID, lastID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, errors.New("streaming unsupported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry/time.Millisecond)
+	flusher.Flush()
+
+	send := func(event business.TransferEvent) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "id: %s\nevent: status\ndata: %s\n\n", event.ID, data)
+		flusher.Flush()
+
+		return err
+	}
+
+	keepalive := func() error {
+		_, err := fmt.Fprint(w, ": keepalive\n\n")
+		flusher.Flush()
+
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), sseStreamMax)
+	defer cancel()
+
+	err := p.stream(ctx, executionID, lastID, send, keepalive)
+	if err != nil && ctx.Err() == nil {
+		p.logger.Warnw("Transfer event stream failed", "execution_id", executionID, "err", err)
+	}
+}
+
+func (p *transferEventsHandlerImpl) serveWebSocket(w http.ResponseWriter, r *http.Request, executionID, lastID string) {
+	conn, err := p.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already answered the request.
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Reading handles pings and the close handshake; the client sends
+	// nothing else.
+	go func() {
+		defer cancel()
+
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event business.TransferEvent) error {
+		return conn.WriteJSON(event)
+	}
+
+	keepalive := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventsWait))
+	}
+
+	err = p.stream(ctx, executionID, lastID, send, keepalive)
+	if err != nil && ctx.Err() == nil {
+		p.logger.Warnw("Transfer event stream failed", "execution_id", executionID, "err", err)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, ""))
+		return
+	}
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "transfer done"))
+}
+
+// stream sends the events after lastID as they come, and a keepalive
+// whenever none comes for eventsWait, until the transfer is done or ctx
+// ends.
+func (p *transferEventsHandlerImpl) stream(ctx context.Context, executionID, lastID string, send func(business.TransferEvent) error, keepalive func() error) error {
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		events, err := p.events.Read(ctx, executionID, lastID, eventsWait)
+		if err != nil {
+			return err
+		}
+
+		if len(events) == 0 {
+			err = keepalive()
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		for _, event := range events {
+			err = send(event)
+			if err != nil {
+				return err
+			}
+
+			lastID = event.ID
+
+			if business.IsFinalTransferStatus(event.Status) {
+				return nil
+			}
+		}
+	}
+}
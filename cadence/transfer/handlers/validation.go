@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/validation"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// decode reads a JSON message and validates it, answering 400 with the
+// broken fields when either fails.
+func decode(w http.ResponseWriter, r *http.Request, message proto.Message) bool {
+	err := json.NewDecoder(r.Body).Decode(message)
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		err = validation.Errors{{Field: typeErr.Field, Rule: "type", Message: "must be a " + jsonType(typeErr.Type)}}
+	}
+
+	if err == nil {
+		err = validation.Validate(message)
+	}
+
+	if err != nil {
+		writeError(w, invalidRequest(err))
+		return false
+	}
+
+	return true
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "number"
+	}
+
+	return t.Kind().String()
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/apex"
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const (
+	webhookMaxSkew   = 5 * time.Minute
+	webhookMaxBody   = 64 << 10
+	webhookRecordTTL = 7 * 24 * time.Hour
+)
+
+type webhookHandlerImpl struct {
+	router *mux.Router
+	broker broker.Broker
+	signer apex.Signer
+	// signatures remembers every accepted signature for as long as its
+	// timestamp is valid, so a captured request can't be sent again.
+	signatures redis.Inbox
+	// updates records each status callback, which Apex may deliver more
+	// than once with fresh signatures.
+	updates redis.Inbox
+	logger  *zap.SugaredLogger
+}
+
+// NewWebhookHandler receives Apex status callbacks and publishes them as
+// ApexWithdrawResponse, the same message the AMQP consumer advances the
+// workflow with.
+func NewWebhookHandler(router *mux.Router, broker broker.Broker, rd redis.RedisConnection, signer apex.Signer) {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("webhooks")
+
+	signer.MaxSkew = webhookMaxSkew
+
+	handler := &webhookHandlerImpl{
+		router:     router,
+		broker:     broker,
+		signer:     signer,
+		signatures: redis.NewInbox(rd, "apex_webhook_signature", 2*webhookMaxSkew, 2*webhookMaxSkew),
+		updates:    redis.NewInbox(rd, "apex_webhook", time.Minute, webhookRecordTTL),
+		logger:     logger.Sugar(),
+	}
+	handler.buildRoutes()
+}
+
+func (p *webhookHandlerImpl) buildRoutes() {
+	router := p.router.PathPrefix("/webhooks").Subrouter()
+
+	router.Handle("/apex", p.ApexWebhook()).Methods("POST")
+}
+
+func (p *webhookHandlerImpl) ApexWebhook() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBody))
+		if err != nil {
+			writeError(w, invalidRequest(err))
+			return
+		}
+
+		err = p.signer.Verify(r, body)
+		if err != nil {
+			p.logger.Warnw("Rejected apex webhook", "err", err)
+			writeError(w, business.Errorf(business.CodeInvalidSignature, "%w", err))
+			return
+		}
+
+		fresh, err := p.signatures.Claim(ctx, r.Header.Get(apex.HeaderSignature))
+		if err != nil && err != redis.ErrInboxInProgress {
+			writeError(w, err)
+			return
+		}
+
+		if !fresh {
+			p.logger.Warnw("Rejected replayed apex webhook", "signature", r.Header.Get(apex.HeaderSignature))
+			writeError(w, business.NewError(business.CodeReplayedRequest, "replayed request"))
+			return
+		}
+
+		message, err := apex.ParseWebhook(body)
+		if err != nil {
+			writeError(w, invalidRequest(err))
+			return
+		}
+
+		key := fmt.Sprintf("%s_%s", message.ExecutionId, message.Status)
+
+		claimed, err := p.updates.Claim(ctx, key)
+		if err == redis.ErrInboxInProgress {
+			writeError(w, business.Errorf(business.CodeInProgress, "%w", err))
+			return
+		}
+
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if !claimed {
+			p.logger.Infow("Duplicate apex webhook", "execution_id", message.ExecutionId, "status", message.Status)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		ctx = broker.WithCorrelationID(ctx, message.ExecutionId)
+
+		err = broker.ProduceStruct(ctx, p.broker, message)
+		if err != nil {
+			p.updates.Release(ctx, key)
+			// Let Apex retry the very same request.
+			p.signatures.Release(ctx, r.Header.Get(apex.HeaderSignature))
+			writeError(w, business.Errorf(business.CodeBrokerUnavailable, "%w", err))
+			return
+		}
+
+		err = p.updates.Complete(ctx, key)
+		if err != nil {
+			p.logger.Errorw("Error recording apex webhook", "execution_id", message.ExecutionId, "err", err)
+		}
+
+		p.logger.Infow("Apex webhook accepted", "execution_id", message.ExecutionId, "status", message.Status)
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
+	"go.uber.org/cadence/.gen/go/shared"
+)
+
+// Redis pings the Redis server.
+func Redis(rd redis.RedisConnection) Check {
+	return func(ctx context.Context) error {
+		return rd.GetConn().Ping(ctx).Err()
+	}
+}
+
+// CadenceDomain describes the domain, which needs the frontend up and the
+// domain registered.
+func CadenceDomain(service workflowserviceclient.Interface, domain string) Check {
+	return func(ctx context.Context) error {
+		_, err := service.DescribeDomain(ctx, &shared.DescribeDomainRequest{Name: &domain})
+		return err
+	}
+}
+
+// Broker asks brokers that are broker.Pinger whether they are still
+// connected. Others, such as the in-memory one, are always up.
+func Broker(b broker.Broker) Check {
+	return func(ctx context.Context) error {
+		pinger, ok := b.(broker.Pinger)
+		if !ok {
+			return nil
+		}
+
+		return pinger.Ping(ctx)
+	}
+}
+
+// TCP dials addr, for dependencies that can't be asked anything before
+// connecting to them for good.
+func TCP(addr string) Check {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+}
+
+// Pollers checks that the worker with the identity polled the decision and
+// activity task lists within maxIdle. Cadence only lists recent pollers, so
+// a stuck or stopped worker drops out.
+func Pollers(service workflowserviceclient.Interface, domain, taskList, identity string, maxIdle time.Duration) Check {
+	return func(ctx context.Context) error {
+		for _, kind := range []shared.TaskListType{shared.TaskListTypeDecision, shared.TaskListTypeActivity} {
+			kind := kind
+
+			resp, err := service.DescribeTaskList(ctx, &shared.DescribeTaskListRequest{
+				Domain:       &domain,
+				TaskList:     &shared.TaskList{Name: &taskList},
+				TaskListType: &kind,
+			})
+			if err != nil {
+				return err
+			}
+
+			if !polled(resp.Pollers, identity, maxIdle) {
+				return fmt.Errorf("no %s poller on %s for %s in the last %s", kind, taskList, identity, maxIdle)
+			}
+		}
+
+		return nil
+	}
+}
+
+func polled(pollers []*shared.PollerInfo, identity string, maxIdle time.Duration) bool {
+	for _, poller := range pollers {
+		if poller.GetIdentity() == identity && time.Since(time.Unix(0, poller.GetLastAccessTime())) <= maxIdle {
+			return true
+		}
+	}
+
+	return false
+}
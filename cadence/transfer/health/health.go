@@ -0,0 +1,221 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check returns an error when the dependency it looks at can't be used.
+type Check func(ctx context.Context) error
+
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the result of every check; it is up when all of them are.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Err lists the checks that failed, or is nil when the report is up.
+func (r Report) Err() error {
+	var failed []string
+	for name, result := range r.Checks {
+		if result.Status != StatusUp {
+			failed = append(failed, fmt.Sprintf("%s: %s", name, result.Error))
+		}
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	sort.Strings(failed)
+
+	return fmt.Errorf("not ready: %s", strings.Join(failed, "; "))
+}
+
+// Backoff spaces the tries of WaitReady, from Initial doubling up to Max,
+// until Timeout.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Timeout time.Duration
+}
+
+type Checker interface {
+	Add(name string, check Check)
+	// Run runs every check at once, each within the checker timeout.
+	Run(ctx context.Context) Report
+	// Live answers 200 for as long as the process can serve at all.
+	Live() http.Handler
+	// Ready answers the report, with 200 when it is up and 503 otherwise.
+	Ready() http.Handler
+	// WaitReady runs the checks until they are all up. It is the startup
+	// probe: dependencies that are still starting get time to come up.
+	WaitReady(ctx context.Context, backoff Backoff) error
+}
+
+type checkerImpl struct {
+	mu      sync.Mutex
+	checks  map[string]Check
+	timeout time.Duration
+	logger  *zap.SugaredLogger
+}
+
+func NewChecker(timeout time.Duration) Checker {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("health")
+
+	return &checkerImpl{
+		checks:  map[string]Check{},
+		timeout: timeout,
+		logger:  logger.Sugar(),
+	}
+}
+
+func (c *checkerImpl) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks[name] = check
+}
+
+func (c *checkerImpl) Run(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.Unlock()
+
+	report := Report{Status: StatusUp, Checks: make(map[string]Result, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, check := range checks {
+		wg.Add(1)
+
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			result := c.run(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			report.Checks[name] = result
+			if result.Status != StatusUp {
+				report.Status = StatusDown
+			}
+		}(name, check)
+	}
+
+	wg.Wait()
+
+	return report
+}
+
+func (c *checkerImpl) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	// A check that ignores its context still can't hold the report up.
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{Status: StatusUp, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func (c *checkerImpl) Live() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, 200, map[string]string{"status": StatusUp})
+	})
+}
+
+func (c *checkerImpl) Ready() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+
+		status := 200
+		if report.Status != StatusUp {
+			status = 503
+		}
+
+		writeJSON(w, status, report)
+	})
+}
+
+func (c *checkerImpl) WaitReady(ctx context.Context, backoff Backoff) error {
+	if backoff.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, backoff.Timeout)
+		defer cancel()
+	}
+
+	wait := backoff.Initial
+	if wait <= 0 {
+		wait = time.Second
+	}
+
+	for {
+		err := c.Run(ctx).Err()
+		if err == nil {
+			return nil
+		}
+
+		c.logger.Warnw("Waiting for dependencies", "wait", wait, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if backoff.Max > 0 && wait > backoff.Max {
+			wait = backoff.Max
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,200 @@
+package iso20022
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	namespaceCamt054 = "urn:iso:std:iso:20022:tech:xsd:camt.054.001."
+
+	StatusBooked  = "BOOK"
+	StatusPending = "PDNG"
+	StatusInfo    = "INFO"
+
+	indicatorCredit = "CRDT"
+	indicatorDebit  = "DBIT"
+)
+
+// StatusUpdate is what a camt.054 entry tells us about one of our
+// payments.
+type StatusUpdate struct {
+	EndToEndID  string
+	Amount      float64
+	Currency    string
+	Debit       bool
+	Status      string
+	BookingDate string
+	// Returned is set when the bank sent the payment back, with the ISO
+	// reason code such as AC04.
+	Returned   bool
+	ReasonCode string
+}
+
+// Camt054 is a BankToCustomerDebitCreditNotification. Only the elements
+// we use are mapped; the namespace is not pinned, so later versions read
+// the same way.
+type Camt054 struct {
+	XMLName       xml.Name       `xml:"Document"`
+	Namespace     string         `xml:"xmlns,attr"`
+	Notifications []Notification `xml:"BkToCstmrDbtCdtNtfctn>Ntfctn"`
+}
+
+type Notification struct {
+	ID      string  `xml:"Id"`
+	Entries []Entry `xml:"Ntry"`
+}
+
+type Entry struct {
+	Amount      CurrencyAmount `xml:"Amt"`
+	Indicator   string         `xml:"CdtDbtInd"`
+	Status      EntryStatus    `xml:"Sts"`
+	BookingDate DateAndTime    `xml:"BookgDt"`
+	Details     []EntryDetails `xml:"NtryDtls"`
+}
+
+// EntryStatus is a plain code up to camt.054.001.05 and a Cd element
+// from then on.
+type EntryStatus struct {
+	Code string `xml:"Cd"`
+	Text string `xml:",chardata"`
+}
+
+func (s EntryStatus) Value() string {
+	if s.Code != "" {
+		return s.Code
+	}
+
+	return strings.TrimSpace(s.Text)
+}
+
+type DateAndTime struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+type EntryDetails struct {
+	Transactions []TransactionDetails `xml:"TxDtls"`
+}
+
+type TransactionDetails struct {
+	References struct {
+		EndToEndID string `xml:"EndToEndId"`
+	} `xml:"Refs"`
+	Amount     *CurrencyAmount `xml:"AmtDtls>TxAmt>Amt"`
+	ReturnInfo *struct {
+		Reason struct {
+			Code string `xml:"Cd"`
+		} `xml:"Rsn"`
+	} `xml:"RtrInf"`
+}
+
+// ParseCamt054 reads the status updates out of a notification, one per
+// transaction, and checks what it reads against part of the schema.
+func ParseCamt054(data []byte) ([]StatusUpdate, error) {
+	var doc Camt054
+	err := xml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = doc.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []StatusUpdate
+
+	for _, notification := range doc.Notifications {
+		for _, entry := range notification.Entries {
+			for _, details := range entry.Details {
+				for _, tx := range details.Transactions {
+					amount := entry.Amount
+					if tx.Amount != nil {
+						amount = *tx.Amount
+					}
+
+					value, _ := strconv.ParseFloat(amount.Value, 64)
+
+					update := StatusUpdate{
+						EndToEndID:  tx.References.EndToEndID,
+						Amount:      value,
+						Currency:    amount.Currency,
+						Debit:       entry.Indicator == indicatorDebit,
+						Status:      entry.Status.Value(),
+						BookingDate: entry.BookingDate.Date,
+					}
+
+					if update.BookingDate == "" && len(entry.BookingDate.DateTime) >= 10 {
+						update.BookingDate = entry.BookingDate.DateTime[:10]
+					}
+
+					if tx.ReturnInfo != nil {
+						update.Returned = true
+						update.ReasonCode = tx.ReturnInfo.Reason.Code
+					}
+
+					updates = append(updates, update)
+				}
+			}
+		}
+	}
+
+	return updates, nil
+}
+
+// Validate runs the camt.054 checks of validate.go on the elements we read.
+func (d *Camt054) Validate() error {
+	v := &validator{}
+
+	if !strings.HasPrefix(d.Namespace, namespaceCamt054) {
+		v.add("Document", "namespace %q is not camt.054", d.Namespace)
+	}
+
+	if len(d.Notifications) == 0 {
+		v.add("BkToCstmrDbtCdtNtfctn/Ntfctn", "at least one is required")
+	}
+
+	for i, notification := range d.Notifications {
+		path := fmt.Sprintf("Ntfctn[%d]", i)
+		v.text(path+"/Id", notification.ID, 35)
+
+		for j, entry := range notification.Entries {
+			entryPath := fmt.Sprintf("%s/Ntry[%d]", path, j)
+
+			v.pattern(entryPath+"/Amt/@Ccy", entry.Amount.Currency, patternCurrency)
+			v.amount(entryPath+"/Amt", entry.Amount.Value)
+
+			if entry.Indicator != indicatorCredit && entry.Indicator != indicatorDebit {
+				v.add(entryPath+"/CdtDbtInd", "%q is not CRDT or DBIT", entry.Indicator)
+			}
+
+			switch entry.Status.Value() {
+			case StatusBooked, StatusPending, StatusInfo:
+			default:
+				v.add(entryPath+"/Sts", "%q is not BOOK, PDNG or INFO", entry.Status.Value())
+			}
+
+			for k, details := range entry.Details {
+				for l, tx := range details.Transactions {
+					txPath := fmt.Sprintf("%s/NtryDtls[%d]/TxDtls[%d]", entryPath, k, l)
+
+					v.text(txPath+"/Refs/EndToEndId", tx.References.EndToEndID, 35)
+
+					if tx.Amount != nil {
+						v.pattern(txPath+"/AmtDtls/TxAmt/Amt/@Ccy", tx.Amount.Currency, patternCurrency)
+						v.amount(txPath+"/AmtDtls/TxAmt/Amt", tx.Amount.Value)
+					}
+
+					if tx.ReturnInfo != nil && len(tx.ReturnInfo.Reason.Code) > 4 {
+						v.add(txPath+"/RtrInf/Rsn/Cd", "%q is longer than 4 characters", tx.ReturnInfo.Reason.Code)
+					}
+				}
+			}
+		}
+	}
+
+	return v.err()
+}
@@ -0,0 +1,79 @@
+package iso20022
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+func TestParseCamt054(t *testing.T) {
+	updates, err := ParseCamt054(readTestdata(t, "camt.054.001.02.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []StatusUpdate{
+		{
+			EndToEndID:  "SB7F0C2A9E4B1D4C3E9A2F5D6E7F8A9B0C",
+			Amount:      1500,
+			Currency:    "EUR",
+			Debit:       true,
+			Status:      StatusBooked,
+			BookingDate: "2026-10-20",
+		},
+		{
+			EndToEndID:  "SB91D4B6C32E5F4A7B8C9D0E1F2A3B4C5D",
+			Amount:      250.50,
+			Currency:    "EUR",
+			Status:      StatusBooked,
+			BookingDate: "2026-10-21",
+			Returned:    true,
+			ReasonCode:  "AC04",
+		},
+	}
+
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("got %+v\nwant %+v", updates, want)
+	}
+}
+
+func TestCamt054RoundTrip(t *testing.T) {
+	data := readTestdata(t, "camt.054.001.02.xml")
+
+	updates, err := ParseCamt054(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc Camt054
+	err = xml.Unmarshal(data, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := xml.Marshal(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := ParseCamt054(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(updates, again) {
+		t.Errorf("round trip changed the updates:\n%+v\n%+v", updates, again)
+	}
+}
+
+func TestParseCamt054RejectsLongEndToEndID(t *testing.T) {
+	data := []byte(`<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.054.001.02"><BkToCstmrDbtCdtNtfctn><Ntfctn><Id>1</Id><Ntry>
+<Amt Ccy="EUR">1.00</Amt><CdtDbtInd>DBIT</CdtDbtInd><Sts>BOOK</Sts>
+<NtryDtls><TxDtls><Refs><EndToEndId>sdtobank_7f0c2a9e-4b1d-4c3e-9a2f-5d6e7f8a9b0c</EndToEndId></Refs></TxDtls></NtryDtls>
+</Ntry></Ntfctn></BkToCstmrDbtCdtNtfctn></Document>`)
+
+	_, err := ParseCamt054(data)
+	if err == nil {
+		t.Error("a 45 character end to end id was accepted")
+	}
+}
@@ -0,0 +1,61 @@
+package iso20022
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Initiator pays transfers to banks outside the US by writing a pain.001
+// per payment to an outbox the bank picks its files up from.
+type Initiator interface {
+	// Initiate writes the payment and returns the path of its file. The
+	// end to end id names the file, so initiating a payment again returns
+	// the file written the first time.
+	Initiate(ctx context.Context, transfer CreditTransfer) (string, error)
+}
+
+type initiatorImpl struct {
+	dir    string
+	debtor Account
+	logger *zap.SugaredLogger
+}
+
+// NewInitiator pays from the debtor account. Payments fail validation
+// while the debtor has no IBAN or BIC.
+func NewInitiator(dir string, debtor Account) Initiator {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("pain001")
+
+	return &initiatorImpl{
+		dir:    dir,
+		debtor: debtor,
+		logger: logger.Sugar(),
+	}
+}
+
+func (i *initiatorImpl) Initiate(ctx context.Context, transfer CreditTransfer) (string, error) {
+	path := filepath.Join(i.dir, pain001FileName(transfer.EndToEndID))
+
+	_, err := os.Stat(path)
+	if err == nil {
+		return path, nil
+	}
+
+	doc, err := NewPain001(transfer.EndToEndID, i.debtor, []CreditTransfer{transfer}, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	path, err = WritePain001(i.dir, doc)
+	if err != nil {
+		return "", err
+	}
+
+	i.logger.Infow("pain.001 written", "end_to_end_id", transfer.EndToEndID, "file", path)
+
+	return path, nil
+}
@@ -0,0 +1,242 @@
+package iso20022
+
+import (
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	NamespacePain001 = "urn:iso:std:iso:20022:tech:xsd:pain.001.001.03"
+
+	isoDateTime = "2006-01-02T15:04:05"
+	isoDate     = "2006-01-02"
+)
+
+// Account is a party to a credit transfer and where its money is held.
+type Account struct {
+	Name string
+	IBAN string
+	BIC  string
+}
+
+// CreditTransfer is one payment of a pain.001. EndToEndID travels with the
+// payment to the creditor's bank and back in its notifications.
+type CreditTransfer struct {
+	EndToEndID string
+	Amount     float64
+	Currency   string
+	Creditor   Account
+	Remittance string
+}
+
+// CreditTransferFromTransfer pays out a transfer. The end to end id comes
+// back in camt.054 notifications, so it must lead back to the workflow; the
+// execution id goes in the remittance for people reading statements.
+func CreditTransferFromTransfer(transfer *pb.Transfer, endToEndID, currency string, creditor Account) CreditTransfer {
+	return CreditTransfer{
+		EndToEndID: endToEndID,
+		Amount:     transfer.Amount,
+		Currency:   currency,
+		Creditor:   creditor,
+		Remittance: transfer.ExecutionId,
+	}
+}
+
+// Pain001 is a CustomerCreditTransferInitiationV03 document. Field order
+// follows the schema sequences.
+type Pain001 struct {
+	XMLName    xml.Name         `xml:"Document"`
+	Namespace  string           `xml:"xmlns,attr"`
+	Initiation CreditInitiation `xml:"CstmrCdtTrfInitn"`
+}
+
+type CreditInitiation struct {
+	GroupHeader        GroupHeader          `xml:"GrpHdr"`
+	PaymentInformation []PaymentInformation `xml:"PmtInf"`
+}
+
+type GroupHeader struct {
+	MessageID        string `xml:"MsgId"`
+	CreationDateTime string `xml:"CreDtTm"`
+	NumberOfTxs      string `xml:"NbOfTxs"`
+	ControlSum       string `xml:"CtrlSum"`
+	InitiatingParty  Party  `xml:"InitgPty"`
+}
+
+type PaymentInformation struct {
+	PaymentInformationID  string                      `xml:"PmtInfId"`
+	PaymentMethod         string                      `xml:"PmtMtd"`
+	NumberOfTxs           string                      `xml:"NbOfTxs"`
+	ControlSum            string                      `xml:"CtrlSum"`
+	RequestedExecution    string                      `xml:"ReqdExctnDt"`
+	Debtor                Party                       `xml:"Dbtr"`
+	DebtorAccount         CashAccount                 `xml:"DbtrAcct"`
+	DebtorAgent           Agent                       `xml:"DbtrAgt"`
+	ChargeBearer          string                      `xml:"ChrgBr,omitempty"`
+	CreditTransferTxInfos []CreditTransferTransaction `xml:"CdtTrfTxInf"`
+}
+
+type CreditTransferTransaction struct {
+	PaymentID       PaymentID   `xml:"PmtId"`
+	Amount          Amount      `xml:"Amt"`
+	CreditorAgent   Agent       `xml:"CdtrAgt"`
+	Creditor        Party       `xml:"Cdtr"`
+	CreditorAccount CashAccount `xml:"CdtrAcct"`
+	Remittance      *Remittance `xml:"RmtInf,omitempty"`
+}
+
+type Party struct {
+	Name string `xml:"Nm"`
+}
+
+type CashAccount struct {
+	ID AccountID `xml:"Id"`
+}
+
+type AccountID struct {
+	IBAN string `xml:"IBAN"`
+}
+
+type Agent struct {
+	FinancialInstitution FinancialInstitution `xml:"FinInstnId"`
+}
+
+type FinancialInstitution struct {
+	BIC string `xml:"BIC"`
+}
+
+type PaymentID struct {
+	EndToEndID string `xml:"EndToEndId"`
+}
+
+type Amount struct {
+	Instructed CurrencyAmount `xml:"InstdAmt"`
+}
+
+type CurrencyAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type Remittance struct {
+	Unstructured string `xml:"Ustrd"`
+}
+
+// NewPain001 builds a validated initiation paying transfers from debtor in
+// a single payment information block.
+func NewPain001(messageID string, debtor Account, transfers []CreditTransfer, now time.Time) (*Pain001, error) {
+	sum := 0.0
+	txs := make([]CreditTransferTransaction, 0, len(transfers))
+
+	for _, t := range transfers {
+		sum += t.Amount
+
+		tx := CreditTransferTransaction{
+			PaymentID:       PaymentID{EndToEndID: t.EndToEndID},
+			Amount:          Amount{Instructed: CurrencyAmount{Currency: t.Currency, Value: formatAmount(t.Amount)}},
+			CreditorAgent:   Agent{FinancialInstitution{BIC: t.Creditor.BIC}},
+			Creditor:        Party{Name: t.Creditor.Name},
+			CreditorAccount: CashAccount{ID: AccountID{IBAN: t.Creditor.IBAN}},
+		}
+
+		if t.Remittance != "" {
+			tx.Remittance = &Remittance{Unstructured: t.Remittance}
+		}
+
+		txs = append(txs, tx)
+	}
+
+	count := strconv.Itoa(len(transfers))
+	controlSum := formatAmount(sum)
+
+	doc := &Pain001{
+		Namespace: NamespacePain001,
+		Initiation: CreditInitiation{
+			GroupHeader: GroupHeader{
+				MessageID:        messageID,
+				CreationDateTime: now.UTC().Format(isoDateTime),
+				NumberOfTxs:      count,
+				ControlSum:       controlSum,
+				InitiatingParty:  Party{Name: debtor.Name},
+			},
+			PaymentInformation: []PaymentInformation{{
+				PaymentInformationID:  messageID,
+				PaymentMethod:         "TRF",
+				NumberOfTxs:           count,
+				ControlSum:            controlSum,
+				RequestedExecution:    now.UTC().Format(isoDate),
+				Debtor:                Party{Name: debtor.Name},
+				DebtorAccount:         CashAccount{ID: AccountID{IBAN: debtor.IBAN}},
+				DebtorAgent:           Agent{FinancialInstitution{BIC: debtor.BIC}},
+				ChargeBearer:          "SLEV",
+				CreditTransferTxInfos: txs,
+			}},
+		},
+	}
+
+	err := doc.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+func (d *Pain001) Marshal() ([]byte, error) {
+	data, err := xml.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), data...), nil
+}
+
+// ParsePain001 reads and validates a pain.001 document.
+func ParsePain001(data []byte) (*Pain001, error) {
+	var doc Pain001
+	err := xml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &doc, doc.Validate()
+}
+
+// WritePain001 writes the document to dir as pain001-<message id>.xml and
+// returns its path.
+func WritePain001(dir string, doc *Pain001) (string, error) {
+	data, err := doc.Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, pain001FileName(doc.Initiation.GroupHeader.MessageID))
+	tmp := path + ".tmp"
+
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return path, os.Rename(tmp, path)
+}
+
+func pain001FileName(messageID string) string {
+	return fmt.Sprintf("pain001-%s.xml", messageID)
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(math.Round(amount*100)/100, 'f', 2, 64)
+}
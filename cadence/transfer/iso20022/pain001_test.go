@@ -0,0 +1,149 @@
+package iso20022
+
+import (
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testDebtor = Account{Name: "Avenue Securities", IBAN: "DE89370400440532013000", BIC: "COBADEFFXXX"}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestParsePain001(t *testing.T) {
+	doc, err := ParsePain001(readTestdata(t, "pain.001.001.03.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := doc.Initiation.GroupHeader
+	if header.MessageID != "AVN-20261019-0001" || header.NumberOfTxs != "2" || header.ControlSum != "1750.50" {
+		t.Errorf("group header %+v", header)
+	}
+
+	txs := doc.Initiation.PaymentInformation[0].CreditTransferTxInfos
+	if len(txs) != 2 {
+		t.Fatalf("%d transactions, want 2", len(txs))
+	}
+
+	if got := txs[0].PaymentID.EndToEndID; got != "SB7F0C2A9E4B1D4C3E9A2F5D6E7F8A9B0C" {
+		t.Errorf("end to end id %q", got)
+	}
+
+	if got := txs[1].Amount.Instructed; got.Currency != "EUR" || got.Value != "250.50" {
+		t.Errorf("amount %+v", got)
+	}
+}
+
+func TestPain001RoundTrip(t *testing.T) {
+	doc, err := ParsePain001(readTestdata(t, "pain.001.001.03.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := doc.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := ParsePain001(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(doc, again) {
+		t.Errorf("round trip changed the document:\n%+v\n%+v", doc, again)
+	}
+}
+
+func TestNewPain001(t *testing.T) {
+	transfer := CreditTransferFromTransfer(&pb.Transfer{
+		ExecutionId: "sdtobank_7f0c2a9e-4b1d-4c3e-9a2f-5d6e7f8a9b0c",
+		Amount:      1500,
+	}, "SB7F0C2A9E4B1D4C3E9A2F5D6E7F8A9B0C", "EUR", Account{Name: "Maria Silva", IBAN: "PT50000201231234567890154", BIC: "CGDIPTPL"})
+
+	doc, err := NewPain001("SB7F0C2A9E4B1D4C3E9A2F5D6E7F8A9B0C", testDebtor, []CreditTransfer{transfer}, time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := doc.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParsePain001(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := parsed.Initiation.PaymentInformation[0].CreditTransferTxInfos[0]
+	if tx.Remittance == nil || tx.Remittance.Unstructured != transfer.Remittance {
+		t.Errorf("remittance %+v, want %q", tx.Remittance, transfer.Remittance)
+	}
+
+	if parsed.Initiation.GroupHeader.ControlSum != "1500.00" {
+		t.Errorf("control sum %q", parsed.Initiation.GroupHeader.ControlSum)
+	}
+}
+
+func TestNewPain001RejectsLongEndToEndID(t *testing.T) {
+	transfer := CreditTransfer{
+		// An execution id is longer than the 35 characters allowed.
+		EndToEndID: "sdtobank_7f0c2a9e-4b1d-4c3e-9a2f-5d6e7f8a9b0c",
+		Amount:     10,
+		Currency:   "EUR",
+		Creditor:   Account{Name: "Maria Silva", IBAN: "PT50000201231234567890154", BIC: "CGDIPTPL"},
+	}
+
+	_, err := NewPain001("AVN-1", testDebtor, []CreditTransfer{transfer}, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "EndToEndId") {
+		t.Errorf("got %v, want an EndToEndId error", err)
+	}
+}
+
+func TestInitiatorWritesOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pain001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	initiator := NewInitiator(dir, testDebtor)
+	transfer := CreditTransfer{
+		EndToEndID: "SB7F0C2A9E4B1D4C3E9A2F5D6E7F8A9B0C",
+		Amount:     10,
+		Currency:   "USD",
+		Creditor:   Account{Name: "Maria Silva", IBAN: "PT50000201231234567890154", BIC: "CGDIPTPL"},
+	}
+
+	first, err := initiator.Initiate(context.Background(), transfer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := initiator.Initiate(context.Background(), transfer)
+	if err != nil || second != first {
+		t.Errorf("initiating again returned %q, %v; want %q", second, err, first)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if len(files) != 1 {
+		t.Errorf("%d files written, want 1", len(files))
+	}
+}
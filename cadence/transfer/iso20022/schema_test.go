@@ -0,0 +1,73 @@
+package iso20022
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// validateSchema checks the document at path against its XSD in
+// testdata/xsd with xmllint, skipping when xmllint is not installed.
+func validateSchema(t *testing.T, schema, path string) {
+	t.Helper()
+
+	xmllint, err := exec.LookPath("xmllint")
+	if err != nil {
+		t.Skip("xmllint is not installed")
+	}
+
+	out, err := exec.Command(xmllint, "--noout", "--schema", filepath.Join("testdata", "xsd", schema), path).CombinedOutput()
+	if err != nil {
+		t.Errorf("%s does not validate against %s: %v\n%s", path, schema, err, out)
+	}
+}
+
+func TestSamplesMatchSchemas(t *testing.T) {
+	validateSchema(t, "pain.001.001.03.xsd", filepath.Join("testdata", "pain.001.001.03.xml"))
+	validateSchema(t, "camt.054.001.02.xsd", filepath.Join("testdata", "camt.054.001.02.xml"))
+}
+
+func TestNewPain001MatchesSchema(t *testing.T) {
+	transfers := []CreditTransfer{
+		{
+			EndToEndID: "SB7F0C2A9E4B1D4C3E9A2F5D6E7F8A9B0C",
+			Amount:     1500,
+			Currency:   "USD",
+			Creditor:   Account{Name: "Maria Silva", IBAN: "PT50000201231234567890154", BIC: "CGDIPTPL"},
+			Remittance: "sdtobank_7f0c2a9e-4b1d-4c3e-9a2f-5d6e7f8a9b0c",
+		},
+		{
+			EndToEndID: "SB91D4B6C32E5F4A7B8C9D0E1F2A3B4C5D",
+			Amount:     0.1,
+			Currency:   "USD",
+			Creditor:   Account{Name: "Jan Jansen", IBAN: "NL91ABNA0417164300", BIC: "ABNANL2A"},
+		},
+	}
+
+	doc, err := NewPain001("SB7F0C2A9E4B1D4C3E9A2F5D6E7F8A9B0C", testDebtor, transfers, time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := doc.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := ioutil.TempFile("", "pain001-*.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validateSchema(t, "pain.001.001.03.xsd", file.Name())
+}
@@ -0,0 +1,179 @@
+package iso20022
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The checks below are the lengths, patterns and required elements of the
+// pain.001.001.03 and camt.054.001.02 schemas for what we fill in or read,
+// so the usual mistakes are refused at runtime without an XML schema
+// library. The tests validate the samples and NewPain001 output against
+// the XSDs in testdata/xsd.
+var (
+	patternIBAN     = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[a-zA-Z0-9]{1,30}$`)
+	patternBIC      = regexp.MustCompile(`^[A-Z]{6}[A-Z2-9][A-NP-Z0-9]([A-Z0-9]{3})?$`)
+	patternCurrency = regexp.MustCompile(`^[A-Z]{3}$`)
+	patternCount    = regexp.MustCompile(`^[0-9]{1,15}$`)
+	patternDecimal  = regexp.MustCompile(`^[0-9]{1,13}(\.[0-9]{1,5})?$`)
+)
+
+// ValidationError lists every schema violation found in a document.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid iso 20022 document: " + strings.Join(e.Problems, "; ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) text(path, value string, max int) {
+	if len(value) < 1 || len(value) > max {
+		v.add(path, "must have 1 to %d characters", max)
+	}
+}
+
+func (v *validator) pattern(path, value string, pattern *regexp.Regexp) {
+	if !pattern.MatchString(value) {
+		v.add(path, "%q does not match %s", value, pattern)
+	}
+}
+
+func (v *validator) iban(path, value string) {
+	v.pattern(path, value, patternIBAN)
+
+	if patternIBAN.MatchString(value) && !validIBANChecksum(value) {
+		v.add(path, "%q has a wrong check digit", value)
+	}
+}
+
+func (v *validator) amount(path, value string) float64 {
+	v.pattern(path, value, patternDecimal)
+
+	amount, _ := strconv.ParseFloat(value, 64)
+	if amount <= 0 {
+		v.add(path, "must be positive")
+	}
+
+	return amount
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Problems: v.problems}
+}
+
+// validIBANChecksum runs the ISO 13616 mod 97 check.
+func validIBANChecksum(iban string) bool {
+	rearranged := strings.ToUpper(iban[4:] + iban[:4])
+
+	var digits strings.Builder
+	for _, c := range rearranged {
+		if c >= 'A' && c <= 'Z' {
+			digits.WriteString(strconv.Itoa(int(c-'A') + 10))
+		} else {
+			digits.WriteRune(c)
+		}
+	}
+
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return false
+	}
+
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// Validate runs the pain.001.001.03 checks of this file on the elements we
+// fill in, and checks that counts and control sums add up.
+func (d *Pain001) Validate() error {
+	v := &validator{}
+
+	if d.Namespace != NamespacePain001 {
+		v.add("Document", "namespace %q, want %q", d.Namespace, NamespacePain001)
+	}
+
+	header := d.Initiation.GroupHeader
+	v.text("GrpHdr/MsgId", header.MessageID, 35)
+	v.text("GrpHdr/CreDtTm", header.CreationDateTime, 35)
+	v.pattern("GrpHdr/NbOfTxs", header.NumberOfTxs, patternCount)
+	v.text("GrpHdr/InitgPty/Nm", header.InitiatingParty.Name, 140)
+
+	if len(d.Initiation.PaymentInformation) == 0 {
+		v.add("PmtInf", "at least one is required")
+	}
+
+	count := 0
+	sum := 0.0
+
+	for i, info := range d.Initiation.PaymentInformation {
+		path := fmt.Sprintf("PmtInf[%d]", i)
+
+		v.text(path+"/PmtInfId", info.PaymentInformationID, 35)
+
+		if info.PaymentMethod != "TRF" && info.PaymentMethod != "CHK" && info.PaymentMethod != "TRA" {
+			v.add(path+"/PmtMtd", "%q is not a payment method", info.PaymentMethod)
+		}
+
+		v.text(path+"/ReqdExctnDt", info.RequestedExecution, 10)
+		v.text(path+"/Dbtr/Nm", info.Debtor.Name, 140)
+		v.iban(path+"/DbtrAcct/Id/IBAN", info.DebtorAccount.ID.IBAN)
+		v.pattern(path+"/DbtrAgt/FinInstnId/BIC", info.DebtorAgent.FinancialInstitution.BIC, patternBIC)
+
+		if len(info.CreditTransferTxInfos) == 0 {
+			v.add(path+"/CdtTrfTxInf", "at least one is required")
+		}
+
+		infoSum := 0.0
+
+		for j, tx := range info.CreditTransferTxInfos {
+			txPath := fmt.Sprintf("%s/CdtTrfTxInf[%d]", path, j)
+
+			v.text(txPath+"/PmtId/EndToEndId", tx.PaymentID.EndToEndID, 35)
+			v.pattern(txPath+"/Amt/InstdAmt/@Ccy", tx.Amount.Instructed.Currency, patternCurrency)
+			infoSum += v.amount(txPath+"/Amt/InstdAmt", tx.Amount.Instructed.Value)
+			v.pattern(txPath+"/CdtrAgt/FinInstnId/BIC", tx.CreditorAgent.FinancialInstitution.BIC, patternBIC)
+			v.text(txPath+"/Cdtr/Nm", tx.Creditor.Name, 140)
+			v.iban(txPath+"/CdtrAcct/Id/IBAN", tx.CreditorAccount.ID.IBAN)
+
+			if tx.Remittance != nil {
+				v.text(txPath+"/RmtInf/Ustrd", tx.Remittance.Unstructured, 140)
+			}
+		}
+
+		if info.NumberOfTxs != "" && info.NumberOfTxs != strconv.Itoa(len(info.CreditTransferTxInfos)) {
+			v.add(path+"/NbOfTxs", "is %s but there are %d transactions", info.NumberOfTxs, len(info.CreditTransferTxInfos))
+		}
+
+		if info.ControlSum != "" && info.ControlSum != formatAmount(infoSum) {
+			v.add(path+"/CtrlSum", "is %s but the transactions add up to %s", info.ControlSum, formatAmount(infoSum))
+		}
+
+		count += len(info.CreditTransferTxInfos)
+		sum += infoSum
+	}
+
+	if header.NumberOfTxs != strconv.Itoa(count) {
+		v.add("GrpHdr/NbOfTxs", "is %s but there are %d transactions", header.NumberOfTxs, count)
+	}
+
+	if header.ControlSum != "" && header.ControlSum != formatAmount(sum) {
+		v.add("GrpHdr/CtrlSum", "is %s but the transactions add up to %s", header.ControlSum, formatAmount(sum))
+	}
+
+	return v.err()
+}
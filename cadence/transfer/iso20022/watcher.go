@@ -0,0 +1,127 @@
+package iso20022
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type StatusHandler func(ctx context.Context, update StatusUpdate) error
+
+// Watcher reads the camt.054 notifications dropped in a directory and hands
+// each status update to a handler. Files are moved to processed/ once
+// every update in them was handled, so handlers must tolerate seeing an
+// update again after a failure.
+type Watcher interface {
+	Start()
+	Stop()
+	ProcessFile(ctx context.Context, path string) error
+}
+
+type watcherImpl struct {
+	dir      string
+	interval time.Duration
+	handler  StatusHandler
+	done     chan struct{}
+	logger   *zap.SugaredLogger
+}
+
+func NewWatcher(dir string, interval time.Duration, handler StatusHandler) Watcher {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("camt054")
+
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return &watcherImpl{
+		dir:      dir,
+		interval: interval,
+		handler:  handler,
+		done:     make(chan struct{}),
+		logger:   logger.Sugar(),
+	}
+}
+
+func (w *watcherImpl) Start() {
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+			}
+
+			w.scan(context.Background())
+		}
+	}()
+}
+
+func (w *watcherImpl) Stop() {
+	close(w.done)
+}
+
+func (w *watcherImpl) ProcessFile(ctx context.Context, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	updates, err := ParseCamt054(data)
+	if err != nil {
+		return err
+	}
+
+	for _, update := range updates {
+		err = w.handler(ctx, update)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.logger.Infow("camt.054 processed", "file", path, "updates", len(updates))
+
+	return nil
+}
+
+func (w *watcherImpl) scan(ctx context.Context) {
+	files, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			w.logger.Errorw("Error reading camt.054 inbox", "dir", w.dir, "err", err)
+		}
+		return
+	}
+
+	processed := filepath.Join(w.dir, "processed")
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".xml" {
+			continue
+		}
+
+		path := filepath.Join(w.dir, file.Name())
+
+		err = w.ProcessFile(ctx, path)
+		if err != nil {
+			w.logger.Errorw("Error processing camt.054", "file", path, "err", err)
+			continue
+		}
+
+		err = os.MkdirAll(processed, 0755)
+		if err == nil {
+			err = os.Rename(path, filepath.Join(processed, file.Name()))
+		}
+
+		if err != nil {
+			w.logger.Errorw("Error moving camt.054", "file", path, "err", err)
+		}
+	}
+}
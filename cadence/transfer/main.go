@@ -3,10 +3,16 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -19,14 +25,26 @@ import (
 	"go.uber.org/yarpc"
 	"go.uber.org/yarpc/transport/tchannel"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 
+	"avenuesec/workflow-poc/cadence/transfer/ach"
+	"avenuesec/workflow-poc/cadence/transfer/apex"
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	"avenuesec/workflow-poc/cadence/transfer/broker/memory"
 	"avenuesec/workflow-poc/cadence/transfer/business"
 	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
 	"avenuesec/workflow-poc/cadence/transfer/handlers"
+	"avenuesec/workflow-poc/cadence/transfer/health"
 	"avenuesec/workflow-poc/cadence/transfer/helpers/model"
 	"avenuesec/workflow-poc/cadence/transfer/helpers/security"
+	"avenuesec/workflow-poc/cadence/transfer/iso20022"
+	"avenuesec/workflow-poc/cadence/transfer/nats"
 	"avenuesec/workflow-poc/cadence/transfer/rabbitmq"
+	"avenuesec/workflow-poc/cadence/transfer/reconciliation"
 	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"avenuesec/workflow-poc/cadence/transfer/resilience"
+	"avenuesec/workflow-poc/cadence/transfer/rpc"
 	wf "avenuesec/workflow-poc/cadence/transfer/workflow"
 )
 
@@ -36,6 +54,23 @@ var ClientName = "simpleworker"
 var CadenceService = "cadence-frontend"
 var CadenceClientName = "cadence-client"
 
+// brokerAmqpConfig is where the amqp broker connects.
+var brokerAmqpConfig = model.AmqpConfig{
+	User:     "guest",
+	Password: "guest",
+	VHost:    "avenue",
+	Host:     "rabbitmq",
+	Port:     5672,
+}
+
+const (
+	// healthCheckTimeout bounds each dependency check.
+	healthCheckTimeout = 5 * time.Second
+	// pollerMaxIdle is how long ago a worker may have last polled and still
+	// be ready. Polls wait up to a minute for a task.
+	pollerMaxIdle = 2 * time.Minute
+)
+
 var (
 	flagUser  string
 	flagPwd   string
@@ -43,6 +78,54 @@ var (
 	flagHost  string
 	flagPort  = 5672
 	mode      string
+
+	flagBroker       string
+	flagNatsURL      string
+	flagNatsStoreDir string
+
+	flagApexURL    string
+	flagApexKey    string
+	flagApexSecret string
+
+	flagSimulatorConfig string
+	flagProvidersConfig string
+
+	flagACHOutbox      string
+	flagACHReturns     string
+	flagACHInterval    time.Duration
+	flagACHODFI        string
+	flagACHDestination string
+	flagACHCompanyID   string
+
+	flagCamtInbox string
+
+	flagPainOutbox     string
+	flagPainDebtorName string
+	flagPainDebtorIBAN string
+	flagPainDebtorBIC  string
+
+	flagReconInbox    string
+	flagReconReports  string
+	flagReconSchedule string
+
+	flagBreakerFailures     int
+	flagBreakerOpenTimeout  time.Duration
+	flagProviderConcurrency int
+	flagProviderTimeout     time.Duration
+
+	flagAdvancedVisibility bool
+
+	flagGRPCAddr string
+
+	flagAuthJWKS     string
+	flagAuthJWTKey   string
+	flagAuthIssuer   string
+	flagAuthAudience string
+	flagAuthAPIKeys  string
+	flagAuthDisabled bool
+
+	flagHealthAddr     string
+	flagStartupTimeout time.Duration
 )
 
 func InitWithFlagSet(flagSet *flag.FlagSet) {
@@ -51,6 +134,42 @@ func InitWithFlagSet(flagSet *flag.FlagSet) {
 	flagSet.StringVar(&flagVHost, "rmq_vhost", "avenue", "")
 	flagSet.StringVar(&flagHost, "rmq_host", "localhost", "")
 	flagSet.IntVar(&flagPort, "rmq_port", 5672, "")
+	flagSet.StringVar(&flagBroker, "broker", "amqp", "Message broker: amqp, redis, nats or memory.")
+	flagSet.StringVar(&flagNatsURL, "nats_url", "", "NATS server URL. Empty starts an embedded server.")
+	flagSet.StringVar(&flagNatsStoreDir, "nats_store_dir", os.TempDir(), "JetStream storage for the embedded NATS server.")
+	flagSet.StringVar(&flagApexURL, "apex_url", "", "Apex API base URL. Withdrawals go to the Apex simulator when empty.")
+	flagSet.StringVar(&flagApexKey, "apex_key", "local", "")
+	flagSet.StringVar(&flagApexSecret, "apex_secret", "local", "")
+	flagSet.StringVar(&flagProvidersConfig, "providers_config", "", "Payment providers and routes file. Empty routes SdToBank USD to Apex, or to the simulator without apex_url.")
+	flagSet.StringVar(&flagACHOutbox, "ach_outbox", filepath.Join(os.TempDir(), "ach", "outbox"), "Directory ACH files are written to.")
+	flagSet.StringVar(&flagACHReturns, "ach_returns", filepath.Join(os.TempDir(), "ach", "returns"), "Directory ACH return files are read from.")
+	flagSet.DurationVar(&flagACHInterval, "ach_interval", time.Minute, "How often queued credits are written to an ACH file.")
+	flagSet.StringVar(&flagACHODFI, "ach_odfi", "", "Routing number of our originating bank. Workers without it fail ACH credits.")
+	flagSet.StringVar(&flagACHDestination, "ach_destination", "", "Routing number of the bank receiving our ACH files. Workers without it fail ACH credits.")
+	flagSet.StringVar(&flagACHCompanyID, "ach_company_id", "1234567890", "")
+	flagSet.StringVar(&flagPainOutbox, "pain001_outbox", filepath.Join(os.TempDir(), "iso20022", "pain001"), "Directory pain.001 files paying banks outside the US are written to.")
+	flagSet.StringVar(&flagPainDebtorName, "pain001_debtor_name", "AVENUE SECURITIES", "")
+	flagSet.StringVar(&flagPainDebtorIBAN, "pain001_debtor_iban", "", "IBAN pain.001 payments are made from. Credits to banks outside the US fail without it.")
+	flagSet.StringVar(&flagPainDebtorBIC, "pain001_debtor_bic", "", "BIC of the bank holding pain001_debtor_iban.")
+	flagSet.StringVar(&flagCamtInbox, "camt_inbox", filepath.Join(os.TempDir(), "iso20022", "camt054"), "Directory camt.054 notifications are read from.")
+	flagSet.StringVar(&flagReconInbox, "recon_inbox", filepath.Join(os.TempDir(), "reconciliation", "inbox"), "Directory Apex settlement files are read from.")
+	flagSet.StringVar(&flagReconReports, "recon_reports", filepath.Join(os.TempDir(), "reconciliation", "reports"), "Directory reconciliation reports are written to.")
+	flagSet.StringVar(&flagReconSchedule, "recon_schedule", "0 6 * * *", "Cron schedule of the settlement reconciliation.")
+	flagSet.IntVar(&flagBreakerFailures, "breaker_failures", 5, "Provider failures in a row that open its circuit breaker.")
+	flagSet.DurationVar(&flagBreakerOpenTimeout, "breaker_open_timeout", 30*time.Second, "How long an open breaker rejects calls before probing the provider.")
+	flagSet.IntVar(&flagProviderConcurrency, "provider_concurrency", 10, "Calls each provider may have in flight.")
+	flagSet.DurationVar(&flagProviderTimeout, "provider_timeout", 10*time.Second, "Timeout budget of a provider call.")
+	flagSet.StringVar(&flagGRPCAddr, "grpc_addr", "0.0.0.0:9090", "Address the gRPC API listens on in server mode.")
+	flagSet.StringVar(&flagAuthJWKS, "auth_jwks", "", "JWKS file with the public keys bearer tokens are signed with.")
+	flagSet.StringVar(&flagAuthJWTKey, "auth_jwt_key", "", "HMAC key bearer tokens are signed with, when there is no JWKS file.")
+	flagSet.StringVar(&flagAuthIssuer, "auth_issuer", "", "Issuer every bearer token must have. Empty accepts any.")
+	flagSet.StringVar(&flagAuthAudience, "auth_audience", "", "Audience every bearer token must have. Empty accepts any.")
+	flagSet.StringVar(&flagAuthAPIKeys, "auth_api_keys", "", "JSON file with the SHA-256 of the API keys services call the API with.")
+	flagSet.BoolVar(&flagAuthDisabled, "auth_disabled", false, "Let every API caller in as an admin. For local development only.")
+	flagSet.StringVar(&flagHealthAddr, "health_addr", "0.0.0.0:8081", "Address workers serve /healthz and /readyz on. The server serves them on its HTTP port.")
+	flagSet.DurationVar(&flagStartupTimeout, "startup_timeout", 2*time.Minute, "How long to wait for Cadence, Redis and the broker at startup. 0 skips the wait.")
+	flagSet.BoolVar(&flagAdvancedVisibility, "advanced_visibility", false, "Set the transfer search attributes and list transfers through Cadence visibility. Needs ElasticSearch.")
+	flagSet.StringVar(&flagSimulatorConfig, "simulator_config", "", "Apex simulator scenario file. Empty uses the built-in scenarios.")
 }
 
 func GetEnvOrDefault(key string, defaultValue string) string {
@@ -84,7 +203,7 @@ func InitFromEnvSet() {
 }
 
 func init() {
-	flag.StringVar(&mode, "m", "trigger", "Mode is worker, trigger or shadower.")
+	flag.StringVar(&mode, "m", "trigger", "Mode is worker, server, local (worker and server in one process), simulator or apexstub.")
 	InitWithFlagSet(flag.CommandLine)
 	flag.Parse()
 }
@@ -92,27 +211,278 @@ func init() {
 func main() {
 	switch mode {
 	case "worker":
-		startWorker(buildLogger(), buildCadenceClient())
+		service := buildCadenceClient()
+		waitForDependencies(service)
+
+		startWorker(buildLogger(), service, buildBroker())
 
 		// The workers are supposed to be long running process that should not exit.
 		// Use select{} to block indefinitely for samples, you can quit by CMD+C.
 		select {}
 
 	case "server":
-		startServer(buildCadenceClient())
+		service := buildCadenceClient()
+		waitForDependencies(service)
+
+		startServer(service, buildBroker())
+
+	case "local":
+		// Worker and server share one broker, which is what makes the
+		// in-memory broker usable for demos.
+		service := buildCadenceClient()
+		waitForDependencies(service)
+
+		b := buildBroker()
+
+		startWorker(buildLogger(), service, b)
+		startServer(service, b)
+
+	case "simulator":
+		startSimulator(buildBroker())
+
+		select {}
+
+	case "apexstub":
+		startApexStub()
 	}
 }
 
-func getHandler(service workflowserviceclient.Interface, amqpConfig model.AmqpConfig) *mux.Router {
-	rabbit := rabbitmq.GetConnection(amqpConfig)
-	rd := redis.NewRedisConnection()
+// waitForDependencies is the startup probe. It blocks until Cadence, Redis
+// and the broker answer, backing off between tries, and exits when they
+// don't within startup_timeout.
+func waitForDependencies(service workflowserviceclient.Interface) {
+	if flagStartupTimeout <= 0 {
+		return
+	}
+
+	checker := health.NewChecker(healthCheckTimeout)
+	checker.Add("cadence", health.CadenceDomain(service, Domain))
+	checker.Add("redis", health.Redis(redis.NewRedisConnection()))
+
+	// The broker is not built yet, and the amqp one exits when it can't
+	// connect, so its server is only dialed.
+	switch flagBroker {
+	case "amqp":
+		checker.Add("amqp", health.TCP(net.JoinHostPort(brokerAmqpConfig.Host, strconv.Itoa(brokerAmqpConfig.Port))))
+	case "nats":
+		if addr := natsAddr(flagNatsURL); addr != "" {
+			checker.Add("nats", health.TCP(addr))
+		}
+	}
+
+	err := checker.WaitReady(context.Background(), health.Backoff{
+		Initial: time.Second,
+		Max:     15 * time.Second,
+		Timeout: flagStartupTimeout,
+	})
+	if err != nil {
+		log.Fatalf("Dependencies not ready after %s: %v", flagStartupTimeout, err)
+	}
+}
+
+// natsAddr is the host and port of the first server of a NATS URL list, or
+// empty for the embedded server.
+func natsAddr(urls string) string {
+	if urls == "" {
+		return ""
+	}
+
+	parsed, err := url.Parse(strings.TrimSpace(strings.Split(urls, ",")[0]))
+	if err != nil || parsed.Host == "" {
+		return ""
+	}
 
-	r := handlers.NewHandler(rabbit)
+	if parsed.Port() == "" {
+		return net.JoinHostPort(parsed.Hostname(), "4222")
+	}
+
+	return parsed.Host
+}
+
+// readiness checks what both modes need to do any work.
+func readiness(service workflowserviceclient.Interface, rd redis.RedisConnection, b broker.Broker) health.Checker {
+	checker := health.NewChecker(healthCheckTimeout)
+	checker.Add("cadence", health.CadenceDomain(service, Domain))
+	checker.Add("redis", health.Redis(rd))
+	checker.Add("broker", health.Broker(b))
+
+	return checker
+}
+
+func buildBroker() broker.Broker {
+	switch flagBroker {
+	case "memory":
+		return memory.NewBroker()
+
+	case "redis":
+		return redis.NewStreamBroker(redis.NewRedisConnection(), redis.StreamConfig{})
+
+	case "nats":
+		b, err := nats.NewBroker(nats.Config{
+			URL:      flagNatsURL,
+			StoreDir: flagNatsStoreDir,
+		})
+		if err != nil {
+			panic("Failed to start NATS broker: " + err.Error())
+		}
+
+		return b
+	}
 
-	bizz := business.NewSdToBankService(rabbit, rd, service, Domain)
-	handlers.NewConsumer(rabbit, bizz)
+	return rabbitmq.GetConnection(brokerAmqpConfig)
+}
+
+func getHandler(b broker.Broker, rd redis.RedisConnection, authenticator auth.Authenticator, bizz business.SdToBankService, search business.TransferSearch, events business.TransferEvents, admin business.TransferAdmin, scope tally.Scope) *mux.Router {
+	handlers.NewConsumer(b, rd, bizz, scope)
+
+	r := handlers.NewHandler(b, authenticator, bizz, search, events)
+
+	breakers := buildBreakers(rd, scope)
+
+	handlers.NewMoneyBinConsumer(b, rd, business.MoneyBinBinService(b, rd), scope)
+	handlers.NewProviderHandler(r.GetRouter(), buildProviders(b, breakers))
+	handlers.NewBreakerHandler(r.GetRouter(), breakers)
+	handlers.NewAdminHandler(r.GetRouter(), admin, bizz)
+	// Apex signs its webhooks instead of holding API credentials.
+	handlers.NewWebhookHandler(r.GetPublicRouter(), b, rd, apex.Signer{
+		KeyID:  flagApexKey,
+		Secret: []byte(security.DecryptIf(GetEnvOrDefault("AVENUE_GCLOUD_ID", "trading-dev-201715"), flagApexSecret)),
+	})
+	handlers.NewReconciliationHandler(r.GetRouter(), reconciliation.NewStore(rd))
 
-	return r.GetRouter()
+	return r.GetRootRouter()
+}
+
+// buildAuthenticator checks bearer tokens when a JWKS file or key is set
+// and API keys when a keys file is set. Having neither is an error unless
+// auth is disabled.
+func buildAuthenticator() (auth.Authenticator, error) {
+	if flagAuthDisabled {
+		return auth.Disabled(), nil
+	}
+
+	var tokens auth.TokenVerifier
+	var keys auth.APIKeys
+	var err error
+
+	if flagAuthJWKS != "" || flagAuthJWTKey != "" {
+		tokens, err = auth.NewJWTVerifier(auth.JWTConfig{
+			JWKSFile: flagAuthJWKS,
+			Key:      []byte(security.DecryptIf(GetEnvOrDefault("AVENUE_GCLOUD_ID", "trading-dev-201715"), flagAuthJWTKey)),
+			Issuer:   flagAuthIssuer,
+			Audience: flagAuthAudience,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if flagAuthAPIKeys != "" {
+		keys, err = auth.LoadAPIKeys(flagAuthAPIKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if tokens == nil && keys == nil {
+		return nil, fmt.Errorf("no auth configured: set auth_jwks, auth_jwt_key or auth_api_keys, or auth_disabled")
+	}
+
+	return auth.NewAuthenticator(tokens, keys), nil
+}
+
+// buildBreakers creates the circuit breakers, bulkheads and timeout budgets
+// of the provider calls. Open breakers are shared through Redis.
+func buildBreakers(rd redis.RedisConnection, scope tally.Scope) resilience.Group {
+	return resilience.NewGroup(resilience.Config{
+		FailureThreshold: flagBreakerFailures,
+		OpenTimeout:      flagBreakerOpenTimeout,
+		MaxConcurrent:    flagProviderConcurrency,
+		Timeout:          flagProviderTimeout,
+	}, scope, redis.NewBreakerStates(rd))
+}
+
+// buildProviders registers the payment provider adapters and the routes
+// between them. Without a providers file SdToBank USD goes to Apex when
+// apex_url is set and to the Apex simulator otherwise.
+func buildProviders(b broker.Broker, breakers resilience.Group) business.ProviderRegistry {
+	registry := business.NewProviderRegistry()
+	registry.RegisterFactory(business.ProviderKindApex, business.ResilientProviderFactory(business.ApexProviderFactory(b), breakers))
+	registry.RegisterFactory(business.ProviderKindSimulator, business.SimulatorProviderFactory(b))
+
+	config := business.ProvidersConfig{}
+
+	if flagProvidersConfig != "" {
+		var err error
+		config, err = business.LoadProvidersConfig(flagProvidersConfig)
+		if err != nil {
+			panic("Failed to load providers config: " + err.Error())
+		}
+	} else if flagApexURL != "" {
+		config.Providers = append(config.Providers, business.ProviderConfig{
+			Name:       "apex",
+			Kind:       business.ProviderKindApex,
+			Currencies: []string{business.CurrencyUSD},
+			Settings: map[string]string{
+				"base_url": flagApexURL,
+				"key":      flagApexKey,
+				"secret":   security.DecryptIf(GetEnvOrDefault("AVENUE_GCLOUD_ID", "trading-dev-201715"), flagApexSecret),
+			},
+		})
+		config.Routes = append(config.Routes, business.Route{Direction: pb.Direction_SdToBank, Currency: business.CurrencyUSD, Provider: "apex"})
+	} else {
+		config.Providers = append(config.Providers, business.ProviderConfig{
+			Name:       "simulator",
+			Kind:       business.ProviderKindSimulator,
+			Currencies: []string{business.CurrencyUSD},
+		})
+		config.Routes = append(config.Routes, business.Route{Direction: pb.Direction_SdToBank, Currency: business.CurrencyUSD, Provider: "simulator"})
+	}
+
+	err := registry.Load(config)
+	if err != nil {
+		panic("Failed to load providers: " + err.Error())
+	}
+
+	return registry
+}
+
+// startSimulator answers Apex withdraw requests on the broker with scripted
+// status sequences.
+func startSimulator(b broker.Broker) {
+	config := apex.DefaultSimulatorConfig()
+
+	if flagSimulatorConfig != "" {
+		var err error
+		config, err = apex.LoadSimulatorConfig(flagSimulatorConfig)
+		if err != nil {
+			panic("Failed to load simulator config: " + err.Error())
+		}
+	}
+
+	simulator, err := apex.NewSimulator(b, config)
+	if err != nil {
+		panic("Failed to create simulator: " + err.Error())
+	}
+
+	err = simulator.Start()
+	if err != nil {
+		panic("Failed to start simulator: " + err.Error())
+	}
+
+	log.Println("Apex simulator started")
+}
+
+// startApexStub serves a fake Apex withdrawal API for local runs.
+func startApexStub() {
+	handler := apex.NewStubHandler(apex.Signer{
+		KeyID:   flagApexKey,
+		Secret:  []byte(flagApexSecret),
+		MaxSkew: 5 * time.Minute,
+	})
+
+	log.Println("Apex stub listening on port :8090")
+	log.Fatal(http.ListenAndServe("0.0.0.0:8090", handler))
 }
 
 func withCors(r *mux.Router) http.Handler {
@@ -125,7 +495,7 @@ func withCors(r *mux.Router) http.Handler {
 	return corsWrapper.Handler(r)
 }
 
-func startServer(service workflowserviceclient.Interface) {
+func startServer(service workflowserviceclient.Interface, b broker.Broker) {
 	// amqpConfig := AmqpConfigFromFlags()
 
 	logger := buildLogger()
@@ -139,21 +509,41 @@ func startServer(service workflowserviceclient.Interface) {
 	flag.DurationVar(&wait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
 	flag.Parse()
 
+	authenticator, err := buildAuthenticator()
+	if err != nil {
+		sugar.Fatalw("Failed to set up API auth", "err", err)
+	}
+
+	if flagAuthDisabled {
+		sugar.Warnw("API auth is disabled, every caller is an admin")
+	}
+
+	rd := redis.NewRedisConnection()
+	scope := tally.NewTestScope("transfer_server", map[string]string{})
+
+	// The HTTP and gRPC APIs share the business layer.
+	bizz := business.NewSdToBankService(b, rd, service, Domain)
+	search := business.NewTransferSearch(rd, service, Domain, flagAdvancedVisibility)
+	events := business.NewTransferEvents(rd)
+	admin := business.NewTransferAdmin(service, Domain, bizz, business.MoneyBinBinService(b, rd))
+
+	router := getHandler(b, rd, authenticator, bizz, search, events, admin, scope)
+
+	checker := readiness(service, rd, b)
+	router.Handle("/healthz", checker.Live()).Methods("GET")
+	router.Handle("/readyz", checker.Ready()).Methods("GET")
+
 	srv := &http.Server{
 		Addr: "0.0.0.0:8080",
 		// Good practice to set timeouts to avoid Slowloris attacks.
 		WriteTimeout: time.Second * 15,
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
-		Handler: withCors(getHandler(service, model.AmqpConfig{
-			User:     "guest",
-			Password: "guest",
-			VHost:    "avenue",
-			Host:     "rabbitmq",
-			Port:     5672,
-		})), // Pass our instance of gorilla/mux in.
+		Handler:      withCors(router), // Pass our instance of gorilla/mux in.
 	}
 
+	grpcServer := getGRPCServer(rd, authenticator, bizz, search, events, scope)
+
 	// Run our server in a goroutine so that it doesn't block.
 	go func() {
 		if err := srv.ListenAndServe(); err != nil {
@@ -163,6 +553,19 @@ func startServer(service workflowserviceclient.Interface) {
 
 	sugar.Infow("Listening on port :8080")
 
+	lis, err := net.Listen("tcp", flagGRPCAddr)
+	if err != nil {
+		sugar.Fatalw("Failed to listen for gRPC", "addr", flagGRPCAddr, "err", err)
+	}
+
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	sugar.Infow("gRPC listening", "addr", flagGRPCAddr)
+
 	c := make(chan os.Signal, 1)
 	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
 	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
@@ -177,6 +580,7 @@ func startServer(service workflowserviceclient.Interface) {
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
 	srv.Shutdown(ctx)
+	grpcServer.GracefulStop()
 	// Optionally, you could run srv.Shutdown in a goroutine and block on
 	// <-ctx.Done() if your application should wait for other services
 	// to finalize based on context cancellation.
@@ -184,6 +588,37 @@ func startServer(service workflowserviceclient.Interface) {
 	os.Exit(0)
 }
 
+func getGRPCServer(rd redis.RedisConnection, authenticator auth.Authenticator, bizz business.SdToBankService, search business.TransferSearch, events business.TransferEvents, scope tally.Scope) *grpc.Server {
+	accCh := make(chan *pb.AccountInformation)
+
+	balSvc := business.NewBalanceService(rd, accCh)
+	accSvc := business.NewAccountService(rd, accCh)
+
+	return rpc.NewServer(bizz, search, events, accSvc, balSvc, scope, authenticator)
+}
+
+// serveHealth serves the worker /healthz and /readyz on health_addr.
+func serveHealth(logger *zap.Logger, checker health.Checker) {
+	router := mux.NewRouter()
+	router.Handle("/healthz", checker.Live()).Methods("GET")
+	router.Handle("/readyz", checker.Ready()).Methods("GET")
+
+	srv := &http.Server{
+		Addr:         flagHealthAddr,
+		WriteTimeout: time.Second * 15,
+		ReadTimeout:  time.Second * 15,
+		Handler:      router,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil {
+			logger.Error("Health server stopped", zap.Error(err))
+		}
+	}()
+
+	logger.Info("Serving health checks", zap.String("addr", flagHealthAddr))
+}
+
 func buildLogger() *zap.Logger {
 	config := zap.NewDevelopmentConfig()
 
@@ -214,12 +649,17 @@ func buildCadenceClient() workflowserviceclient.Interface {
 	return workflowserviceclient.New(dispatcher.ClientConfig(CadenceService))
 }
 
-func startWorker(logger *zap.Logger, service workflowserviceclient.Interface) {
+func startWorker(logger *zap.Logger, service workflowserviceclient.Interface, b broker.Broker) {
 	// TaskListName identifies set of client workflows, activities, and workers.
 	// It could be your group or client or application name.
+	hostname, _ := os.Hostname()
+	identity := fmt.Sprintf("%d@%s@%s", os.Getpid(), hostname, business.SdToBankApplicationName)
+
 	workerOptions := worker.Options{
-		Logger:       logger,
-		MetricsScope: tally.NewTestScope(business.SdToBankApplicationName, map[string]string{}),
+		Identity:           identity,
+		Logger:             logger,
+		MetricsScope:       tally.NewTestScope(business.SdToBankApplicationName, map[string]string{}),
+		ContextPropagators: []workflow.ContextPropagator{broker.NewEnvelopePropagator()},
 	}
 
 	worker := worker.New(
@@ -228,28 +668,79 @@ func startWorker(logger *zap.Logger, service workflowserviceclient.Interface) {
 		business.SdToBankApplicationName,
 		workerOptions)
 
-	rabbit := rabbitmq.GetConnection(model.AmqpConfig{
-		User:     "guest",
-		Password: "guest",
-		VHost:    "avenue",
-		Host:     "rabbitmq",
-		Port:     5672,
-	})
 	rd := redis.NewRedisConnection()
-	bizz := business.NewSdToBankService(rabbit, rd, service, Domain)
+	bizz := business.NewSdToBankService(b, rd, service, Domain)
 
 	accCh := make(chan *pb.AccountInformation)
 
 	balSvc := business.NewBalanceService(rd, accCh)
 	accSvc := business.NewAccountService(rd, accCh)
 
-	sdToBankWf := wf.NewSdToBankWorkflow(bizz, balSvc, accSvc, rabbit)
+	bankAccounts := ach.NewBankAccounts(rd)
+
+	// Every ACH file carries both routing numbers, so a worker without
+	// them fails the ACH credits instead of writing files the bank rejects.
+	// Routing numbers that were set but are invalid are a mistake.
+	var credits ach.Originator
+
+	if flagACHODFI == "" && flagACHDestination == "" {
+		logger.Warn("ACH credits are disabled, set ach_odfi and ach_destination to enable them")
+		credits = ach.NewDisabledOriginator()
+	} else {
+		if !ach.ValidRoutingNumber(flagACHODFI) {
+			logger.Fatal("Invalid ach_odfi routing number", zap.String("ach_odfi", flagACHODFI))
+		}
+
+		if !ach.ValidRoutingNumber(flagACHDestination) {
+			logger.Fatal("Invalid ach_destination routing number", zap.String("ach_destination", flagACHDestination))
+		}
+
+		credits = ach.NewOriginator(b, rd, bankAccounts, ach.OriginatorConfig{
+			Config: ach.Config{
+				ImmediateDestination:     flagACHDestination,
+				ImmediateOrigin:          flagACHODFI,
+				ImmediateDestinationName: "DESTINATION BANK",
+				ImmediateOriginName:      "AVENUE SECURITIES",
+				CompanyName:              "AVENUE SECURITIES",
+				CompanyID:                flagACHCompanyID,
+				ODFI:                     flagACHODFI[:8],
+			},
+			OutboxDir:  flagACHOutbox,
+			ReturnsDir: flagACHReturns,
+			Interval:   flagACHInterval,
+		}, bizz.CreditReturned)
+	}
+
+	credits.Start()
+
+	payments := iso20022.NewInitiator(flagPainOutbox, iso20022.Account{
+		Name: flagPainDebtorName,
+		IBAN: flagPainDebtorIBAN,
+		BIC:  flagPainDebtorBIC,
+	})
+
+	iso20022.NewWatcher(flagCamtInbox, time.Minute, bizz.BankStatusChanged).Start()
+
+	breakers := buildBreakers(rd, workerOptions.MetricsScope)
+	sdToBankWf := wf.NewSdToBankWorkflow(bizz, balSvc, accSvc, b, credits, bankAccounts, payments, buildProviders(b, breakers), breakers, flagAdvancedVisibility)
+
+	reconWf := wf.NewReconciliationWorkflow(reconciliation.NewReconciler(
+		business.NewReconciliationLedger(rd),
+		reconciliation.NewStore(rd),
+		reconciliation.Config{InboxDir: flagReconInbox, ReportDir: flagReconReports}))
 
 	worker.RegisterWorkflowWithOptions(sdToBankWf.SdToBankWorkflow, workflow.RegisterOptions{Name: business.SdToBankWorkflowName})
-	worker.RegisterActivity(sdToBankWf.BlockAndJournal)
+	worker.RegisterActivity(sdToBankWf.Block)
+	worker.RegisterActivity(sdToBankWf.Journal)
+	worker.RegisterActivity(sdToBankWf.PostEntry)
 	worker.RegisterActivity(sdToBankWf.Credit)
-	worker.RegisterActivity(sdToBankWf.UnblockDebit)
+	worker.RegisterActivity(sdToBankWf.CheckTransfer)
 	worker.RegisterActivity(sdToBankWf.Validate)
+	worker.RegisterActivity(sdToBankWf.RecordStatus)
+
+	worker.RegisterWorkflowWithOptions(reconWf.ReconciliationWorkflow, workflow.RegisterOptions{Name: business.ReconciliationWorkflowName})
+	worker.RegisterActivity(reconWf.PendingSettlements)
+	worker.RegisterActivity(reconWf.ReconcileSettlement)
 
 	err := worker.Start()
 	if err != nil {
@@ -257,4 +748,13 @@ func startWorker(logger *zap.Logger, service workflowserviceclient.Interface) {
 	}
 
 	logger.Info("Started Worker.", zap.String("worker", business.SdToBankApplicationName))
+
+	checker := readiness(service, rd, b)
+	checker.Add("pollers", health.Pollers(service, Domain, business.SdToBankApplicationName, identity, pollerMaxIdle))
+	serveHealth(logger, checker)
+
+	err = business.NewReconciliationService(service, Domain).StartDaily(context.Background(), flagReconSchedule)
+	if err != nil {
+		logger.Error("Failed to schedule reconciliation", zap.Error(err))
+	}
 }
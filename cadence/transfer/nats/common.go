@@ -0,0 +1,305 @@
+package nats
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	headerMessageType = "x-message-type"
+	headerContentType = "content-type"
+
+	fetchWait = time.Second
+)
+
+type Config struct {
+	// URL of an existing NATS server. When empty an embedded server with
+	// JetStream is started in-process.
+	URL      string
+	StoreDir string
+	Stream   string
+	Subject  string
+}
+
+// natsBroker is the NATS JetStream implementation of broker.Broker. Messages
+// go to a single stream subject and consumers are durable pull consumers, so
+// several processes sharing a consumer name compete for messages like they
+// do on the AMQP queue.
+type natsBroker struct {
+	config   Config
+	embedded *server.Server
+	conn     *nats.Conn
+	js       nats.JetStreamContext
+	logger   *zap.SugaredLogger
+}
+
+func NewBroker(config Config) (broker.Broker, error) {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("nats_broker")
+
+	if config.Stream == "" {
+		config.Stream = "TRANSFERS"
+	}
+
+	if config.Subject == "" {
+		config.Subject = "transfers.messages"
+	}
+
+	b := &natsBroker{
+		config: config,
+		logger: logger.Sugar(),
+	}
+
+	url := config.URL
+	if url == "" {
+		embedded, err := startEmbedded(config.StoreDir)
+		if err != nil {
+			return nil, err
+		}
+
+		b.embedded = embedded
+		url = embedded.ClientURL()
+		b.logger.Infow("Started embedded NATS server", "url", url)
+	}
+
+	conn, err := nats.Connect(url)
+	if err != nil {
+		b.shutdownEmbedded()
+		return nil, err
+	}
+
+	b.conn = conn
+
+	js, err := conn.JetStream()
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	b.js = js
+
+	if _, err := js.StreamInfo(config.Stream); err != nil {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:     config.Stream,
+			Subjects: []string{config.Subject},
+		})
+
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+func startEmbedded(storeDir string) (*server.Server, error) {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  storeDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go s.Start()
+
+	if !s.ReadyForConnections(10 * time.Second) {
+		s.Shutdown()
+		return nil, fmt.Errorf("embedded NATS server not ready")
+	}
+
+	return s, nil
+}
+
+func (b *natsBroker) Publish(ctx context.Context, message broker.Message) error {
+	msg := nats.NewMsg(b.config.Subject)
+	msg.Data = message.Body
+	msg.Header.Set(headerMessageType, message.Type)
+	msg.Header.Set(headerContentType, message.ContentType)
+
+	for k, v := range message.Envelope.Headers() {
+		msg.Header.Set(k, v)
+	}
+
+	// The message id doubles as the JetStream dedup id, so a retried
+	// publish isn't stored twice.
+	_, err := b.js.PublishMsg(msg, nats.MsgId(message.Envelope.MessageID))
+	if err != nil {
+		return err
+	}
+
+	b.logger.Infow("Sent message", "type", message.Type, "id", message.Envelope.MessageID, "correlation", message.Envelope.CorrelationID)
+
+	return nil
+}
+
+func (b *natsBroker) Subscribe(options broker.SubscribeOptions) (broker.Subscription, error) {
+	prefetch := options.Prefetch
+	if prefetch <= 0 {
+		prefetch = 1
+	}
+
+	sub, err := b.js.PullSubscribe(b.config.Subject, options.Name, nats.ManualAck(), nats.MaxAckPending(prefetch))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &subscription{
+		sub:        sub,
+		prefetch:   prefetch,
+		deliveries: make(chan broker.Delivery),
+		done:       make(chan struct{}),
+		exited:     make(chan struct{}),
+		logger:     b.logger,
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// Ping flushes the connection, which takes a round trip to the server.
+func (b *natsBroker) Ping(ctx context.Context) error {
+	if !b.conn.IsConnected() {
+		return fmt.Errorf("nats connection is %v", b.conn.Status())
+	}
+
+	return b.conn.FlushWithContext(ctx)
+}
+
+func (b *natsBroker) Close() error {
+	if b.conn != nil {
+		b.conn.Close()
+	}
+
+	b.shutdownEmbedded()
+
+	return nil
+}
+
+func (b *natsBroker) shutdownEmbedded() {
+	if b.embedded != nil {
+		b.embedded.Shutdown()
+	}
+}
+
+type subscription struct {
+	sub        *nats.Subscription
+	prefetch   int
+	deliveries chan broker.Delivery
+	done       chan struct{}
+	exited     chan struct{}
+	inFlight   sync.WaitGroup
+	logger     *zap.SugaredLogger
+}
+
+func (s *subscription) run() {
+	defer close(s.exited)
+	defer close(s.deliveries)
+
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		msgs, err := s.sub.Fetch(s.prefetch, nats.MaxWait(fetchWait))
+		if err == nats.ErrTimeout {
+			continue
+		}
+
+		if err != nil {
+			s.logger.Errorw("Error fetching messages", "err", err)
+			time.Sleep(fetchWait)
+			continue
+		}
+
+		for i, msg := range msgs {
+			s.inFlight.Add(1)
+			d := &delivery{msg: msg, inFlight: &s.inFlight}
+
+			select {
+			case s.deliveries <- d:
+			case <-s.done:
+				// Hand back whatever was fetched but not delivered.
+				for _, rest := range msgs[i:] {
+					rest.Nak()
+				}
+				s.inFlight.Done()
+				return
+			}
+		}
+	}
+}
+
+func (s *subscription) Deliveries() <-chan broker.Delivery {
+	return s.deliveries
+}
+
+func (s *subscription) Close() error {
+	close(s.done)
+	<-s.exited
+	s.inFlight.Wait()
+
+	return s.sub.Unsubscribe()
+}
+
+type delivery struct {
+	msg      *nats.Msg
+	inFlight *sync.WaitGroup
+	once     sync.Once
+}
+
+func (d *delivery) Message() broker.Message {
+	headers := map[string]string{}
+	for k := range d.msg.Header {
+		headers[k] = d.msg.Header.Get(k)
+	}
+
+	return broker.Message{
+		Type:        headers[headerMessageType],
+		ContentType: headers[headerContentType],
+		Body:        d.msg.Data,
+		Envelope:    broker.EnvelopeFromHeaders(headers),
+	}
+}
+
+func (d *delivery) Redelivered() bool {
+	meta, err := d.msg.Metadata()
+	if err != nil {
+		return false
+	}
+
+	return meta.NumDelivered > 1
+}
+
+func (d *delivery) Ack() error {
+	defer d.settle()
+	return d.msg.Ack()
+}
+
+func (d *delivery) Nack(requeue bool) error {
+	defer d.settle()
+
+	if requeue {
+		return d.msg.Nak()
+	}
+
+	// JetStream has no dead letter stream, terminating stops redelivery.
+	return d.msg.Term()
+}
+
+func (d *delivery) settle() {
+	d.once.Do(d.inFlight.Done)
+}
@@ -0,0 +1,352 @@
+package openapi
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/validation"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ValidateRequest checks the parameters and the JSON body of a request
+// against the operation, pathParams holding the values of the path
+// template. The body is put back for the handler to read. A request
+// breaking the operation gets validation.Errors, at most one per field.
+func (d *Document) ValidateRequest(r *http.Request, operation *Operation, pathParams map[string]string) error {
+	var errs validation.Errors
+
+	for _, param := range operation.Parameters {
+		value, ok := paramValue(r, param, pathParams)
+		if !ok {
+			if param.Required {
+				errs = append(errs, validation.FieldError{Field: param.Name, Rule: "required", Message: "is required"})
+			}
+
+			continue
+		}
+
+		errs = append(errs, d.checkParam(param, value)...)
+	}
+
+	if operation.RequestBody != nil {
+		bodyErrs, err := d.checkBody(r, operation.RequestBody)
+		if err != nil {
+			return err
+		}
+
+		errs = append(errs, bodyErrs...)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func paramValue(r *http.Request, param *Parameter, pathParams map[string]string) (string, bool) {
+	var value string
+
+	switch param.In {
+	case InPath:
+		value = pathParams[param.Name]
+	case InQuery:
+		value = r.URL.Query().Get(param.Name)
+	case InHeader:
+		value = r.Header.Get(param.Name)
+	}
+
+	return value, value != ""
+}
+
+// checkParam reads the text of a parameter as the type of its schema
+// before checking it.
+func (d *Document) checkParam(param *Parameter, text string) validation.Errors {
+	schema := d.resolve(param.Schema)
+	if schema == nil {
+		return nil
+	}
+
+	var value interface{} = text
+
+	switch schema.Type {
+	case "integer", "number":
+		number, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return typeError(param.Name, schema.Type)
+		}
+
+		value = number
+	case "boolean":
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return typeError(param.Name, schema.Type)
+		}
+
+		value = b
+	}
+
+	return d.check(param.Name, schema, value)
+}
+
+func (d *Document) checkBody(r *http.Request, body *RequestBody) (validation.Errors, error) {
+	media, ok := body.Content["application/json"]
+	if !ok || r.Body == nil {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		if body.Required {
+			return validation.Errors{{Field: "body", Rule: "required", Message: "is required"}}, nil
+		}
+
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return validation.Errors{{Field: "body", Rule: "json", Message: "must be valid JSON"}}, nil
+	}
+
+	return d.check("", media.Schema, value), nil
+}
+
+// check checks a decoded JSON value against the schema. Objects and arrays
+// are checked down to their fields, which are named by their path.
+func (d *Document) check(field string, schema *Schema, value interface{}) validation.Errors {
+	schema = d.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+
+	if number, ok := value.(json.Number); ok {
+		f, err := number.Float64()
+		if err != nil {
+			return typeError(field, "number")
+		}
+
+		value = f
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return typeError(field, schema.Type)
+		}
+
+		return d.checkObject(field, schema, object)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return typeError(field, schema.Type)
+		}
+
+		var errs validation.Errors
+		for i, item := range array {
+			errs = append(errs, d.check(fmt.Sprintf("%s[%d]", fieldName(field), i), schema.Items, item)...)
+		}
+
+		return errs
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return typeError(field, schema.Type)
+		}
+
+		rule, message := checkString(schema, s)
+
+		return fieldError(field, rule, message)
+	case "number", "integer":
+		f, ok := value.(float64)
+		if !ok || (schema.Type == "integer" && f != math.Trunc(f)) {
+			return typeError(field, schema.Type)
+		}
+
+		rule, message := checkNumber(schema, f)
+
+		return fieldError(field, rule, message)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return typeError(field, schema.Type)
+		}
+	}
+
+	rule, message := checkEnum(schema, value)
+
+	return fieldError(field, rule, message)
+}
+
+func (d *Document) checkObject(field string, schema *Schema, object map[string]interface{}) validation.Errors {
+	var errs validation.Errors
+
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			errs = append(errs, validation.FieldError{Field: join(field, name), Rule: "required", Message: "is required"})
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := schema.Properties[name]
+		if !ok {
+			if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+				errs = append(errs, validation.FieldError{Field: join(field, name), Rule: "unknown", Message: "is not a known field"})
+			}
+
+			continue
+		}
+
+		errs = append(errs, d.check(join(field, name), property, object[name])...)
+	}
+
+	return errs
+}
+
+func checkString(schema *Schema, value string) (rule, message string) {
+	length := utf8.RuneCountInString(value)
+
+	switch {
+	case schema.MinLength != nil && length < *schema.MinLength:
+		return "length", fmt.Sprintf("must be at least %d characters long", *schema.MinLength)
+	case schema.MaxLength != nil && length > *schema.MaxLength:
+		return "length", fmt.Sprintf("must be at most %d characters long", *schema.MaxLength)
+	}
+
+	if schema.Pattern != "" {
+		matched, err := regexp.MatchString(schema.Pattern, value)
+		if err != nil || !matched {
+			return "pattern", fmt.Sprintf("must match %s", schema.Pattern)
+		}
+	}
+
+	if rule, message := checkFormat(schema.Format, value); rule != "" {
+		return rule, message
+	}
+
+	return checkEnum(schema, value)
+}
+
+func checkFormat(format, value string) (rule, message string) {
+	var ok bool
+
+	switch format {
+	case "uuid":
+		ok = uuidPattern.MatchString(value)
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		ok = err == nil
+	case "byte":
+		_, err := base64.StdEncoding.DecodeString(value)
+		ok = err == nil
+	default:
+		return "", ""
+	}
+
+	if !ok {
+		return "format", "must be a " + format
+	}
+
+	return "", ""
+}
+
+func checkNumber(schema *Schema, value float64) (rule, message string) {
+	switch {
+	case math.IsNaN(value) || math.IsInf(value, 0):
+		return "type", "must be a finite number"
+	case schema.Minimum != nil && value < *schema.Minimum:
+		return "range", fmt.Sprintf("must be at least %v", *schema.Minimum)
+	case schema.Maximum != nil && value > *schema.Maximum:
+		return "range", fmt.Sprintf("must be at most %v", *schema.Maximum)
+	}
+
+	if schema.MultipleOf != nil && *schema.MultipleOf > 0 {
+		quotient := value / *schema.MultipleOf
+
+		// As for validation.Decimals, allow for the rounding of decimal
+		// fractions.
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9*math.Max(1, math.Abs(quotient)) {
+			return "multiple_of", fmt.Sprintf("must be a multiple of %v", *schema.MultipleOf)
+		}
+	}
+
+	return checkEnum(schema, value)
+}
+
+// checkEnum compares values by their text, so the integers of an enum
+// match the floats JSON numbers decode to.
+func checkEnum(schema *Schema, value interface{}) (rule, message string) {
+	if len(schema.Enum) == 0 {
+		return "", ""
+	}
+
+	text := fmt.Sprint(value)
+	for _, allowed := range schema.Enum {
+		if fmt.Sprint(allowed) == text {
+			return "", ""
+		}
+	}
+
+	return "enum", fmt.Sprintf("must be one of %v", schema.Enum)
+}
+
+func typeError(field, kind string) validation.Errors {
+	article := "a"
+	if kind == "object" || kind == "array" || kind == "integer" {
+		article = "an"
+	}
+
+	return validation.Errors{{Field: fieldName(field), Rule: "type", Message: fmt.Sprintf("must be %s %s", article, kind)}}
+}
+
+func fieldError(field, rule, message string) validation.Errors {
+	if rule == "" {
+		return nil
+	}
+
+	return validation.Errors{{Field: fieldName(field), Rule: rule, Message: message}}
+}
+
+// fieldName names the body itself "body".
+func fieldName(field string) string {
+	if field == "" {
+		return "body"
+	}
+
+	return field
+}
+
+func join(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+
+	return parent + "." + name
+}
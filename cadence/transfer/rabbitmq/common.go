@@ -1,13 +1,13 @@
 package rabbitmq
 
 import (
+	"avenuesec/workflow-poc/cadence/transfer/broker"
 	"avenuesec/workflow-poc/cadence/transfer/helpers/model"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/streadway/amqp"
 )
 
@@ -17,9 +17,23 @@ func failOnError(err error, msg string) {
 	}
 }
 
+const (
+	exchangeName = "transfers"
+	// deadLetterExchange routes rejected messages to the dead letter queue
+	// of the subscriber that rejected them, by its name.
+	deadLetterExchange = "transfers_dead_letter"
+)
+
+// AmqpConnection is the RabbitMQ implementation of broker.Broker. Messages
+// are published to a fanout exchange and every subscriber name gets a
+// durable queue bound to it, plus a dead letter queue for the messages it
+// rejects.
 type AmqpConnection struct {
-	queue   amqp.Queue
+	conn    *amqp.Connection
 	channel *amqp.Channel
+	mu      *sync.Mutex
+	// closed is why the publisher channel closed, once it has.
+	closed *error
 }
 
 func GetConnection(amqpConfig model.AmqpConfig) AmqpConnection {
@@ -30,70 +44,293 @@ func GetConnection(amqpConfig model.AmqpConfig) AmqpConnection {
 	ch, err := conn.Channel()
 	failOnError(err, "Failed to open a channel")
 
-	q, err := ch.QueueDeclare(
-		"hello", // name
-		false,   // durable
-		false,   // delete when unused
-		false,   // exclusive
-		false,   // no-wait
-		nil,     // arguments
+	err = ch.ExchangeDeclare(
+		exchangeName, // name
+		"fanout",     // type
+		true,         // durable
+		false,        // auto-deleted
+		false,        // internal
+		false,        // no-wait
+		nil,          // arguments
 	)
-	failOnError(err, "Failed to declare a queue")
+	failOnError(err, "Failed to declare an exchange")
 
-	return AmqpConnection{
+	err = ch.ExchangeDeclare(
+		deadLetterExchange, // name
+		"direct",           // type
+		true,               // durable
+		false,              // auto-deleted
+		false,              // internal
+		false,              // no-wait
+		nil,                // arguments
+	)
+	failOnError(err, "Failed to declare the dead letter exchange")
+
+	a := AmqpConnection{
+		conn:    conn,
 		channel: ch,
-		queue:   q,
+		mu:      &sync.Mutex{},
+		closed:  new(error),
+	}
+
+	go a.watchChannel(ch.NotifyClose(make(chan *amqp.Error, 1)))
+
+	return a
+}
+
+func (a AmqpConnection) watchChannel(closes <-chan *amqp.Error) {
+	closeErr, ok := <-closes
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if ok && closeErr != nil {
+		*a.closed = closeErr
+	} else {
+		*a.closed = amqp.ErrClosed
 	}
 }
 
-func (a AmqpConnection) ProduceStruct(ctx context.Context, message proto.Message) error {
-	msgName := proto.MessageName(message)
+// Ping fails once the connection or the publisher channel is closed.
+// Neither is reopened, so the process has to be restarted.
+func (a AmqpConnection) Ping(ctx context.Context) error {
+	if a.conn.IsClosed() {
+		return amqp.ErrClosed
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return *a.closed
+}
 
-	msg, err := proto.Marshal(message)
-	failOnError(err, "Faailed to marshal a message")
+func (a AmqpConnection) Publish(ctx context.Context, message broker.Message) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
 	// The message content is a byte array, so you can encode whatever you like there.
-	err = a.channel.Publish(
-		"",           // exchange
-		a.queue.Name, // routing key
+	err := a.channel.Publish(
+		exchangeName, // exchange
+		"",           // routing key
 		false,        // mandatory
 		false,        // immediate
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        msg,
-			Type:        msgName,
-		})
-	log.Printf(" [x] Sent %s, with type %s", message, msgName)
-	failOnError(err, "Failed to publish a message")
+		toPublishing(message))
+	if err != nil {
+		return err
+	}
+
+	log.Printf(" [x] Sent %s, id %s, correlation %s", message.Type, message.Envelope.MessageID, message.Envelope.CorrelationID)
 
 	return nil
 }
 
-func (a AmqpConnection) Produce(ctx context.Context, message interface{}) error {
+func (a AmqpConnection) Subscribe(options broker.SubscribeOptions) (broker.Subscription, error) {
+	// Each subscription gets its own channel so its QoS doesn't affect the
+	// publisher channel or other consumers.
+	ch, err := a.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
 
-	msg, err := json.Marshal(message)
-	failOnError(err, "Faailed to marshal a message")
+	err = declareDeadLetterQueue(ch, options.Name)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
 
-	// The message content is a byte array, so you can encode whatever you like there.
-	err = a.channel.Publish(
-		"",           // exchange
-		a.queue.Name, // routing key
-		false,        // mandatory
-		false,        // immediate
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        msg,
-		})
-	log.Printf(" [x] Sent %s", message)
-	failOnError(err, "Faailed to publish a message")
+	// Queues declared before they had a dead letter exchange have to be
+	// deleted once, RabbitMQ refuses to change the arguments of a queue.
+	q, err := ch.QueueDeclare(
+		options.Name, // name
+		true,         // durable
+		false,        // delete when unused
+		false,        // exclusive
+		false,        // no-wait
+		amqp.Table{
+			"x-dead-letter-exchange":    deadLetterExchange,
+			"x-dead-letter-routing-key": options.Name,
+		},
+	)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
 
-	return nil
+	err = ch.QueueBind(q.Name, "", exchangeName, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	err = ch.Qos(options.Prefetch, 0, false)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	msgs, err := ch.Consume(
+		q.Name,       // queue
+		options.Name, // consumer
+		false,        // auto-ack
+		false,        // exclusive
+		false,        // no-local
+		false,        // no-wait
+		nil,          // args
+	)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	sub := &amqpSubscription{
+		channel:    ch,
+		name:       options.Name,
+		deliveries: make(chan broker.Delivery),
+		done:       make(chan struct{}),
+	}
+
+	go sub.forward(msgs)
+
+	return sub, nil
+}
+
+func (a AmqpConnection) Close() error {
+	return a.conn.Close()
+}
+
+// declareDeadLetterQueue declares the queue the messages a subscriber
+// rejects end up in, to be looked at and moved back by hand.
+func declareDeadLetterQueue(ch *amqp.Channel, name string) error {
+	q, err := ch.QueueDeclare(
+		name+"_dead_letter", // name
+		true,                // durable
+		false,               // delete when unused
+		false,               // exclusive
+		false,               // no-wait
+		nil,                 // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	return ch.QueueBind(q.Name, name, deadLetterExchange, false, nil)
+}
+
+type amqpSubscription struct {
+	channel    *amqp.Channel
+	name       string
+	deliveries chan broker.Delivery
+	done       chan struct{}
+	inFlight   sync.WaitGroup
+}
+
+func (s *amqpSubscription) forward(msgs <-chan amqp.Delivery) {
+	defer close(s.done)
+	defer close(s.deliveries)
+
+	for d := range msgs {
+		s.inFlight.Add(1)
+		s.deliveries <- &amqpDelivery{delivery: d, inFlight: &s.inFlight}
+	}
 }
 
-func (a AmqpConnection) GetChannel() *amqp.Channel {
-	return a.channel
+func (s *amqpSubscription) Deliveries() <-chan broker.Delivery {
+	return s.deliveries
 }
 
-func (a AmqpConnection) GetQueue() string {
-	return a.queue.Name
+func (s *amqpSubscription) Close() error {
+	err := s.channel.Cancel(s.name, false)
+
+	// Acks need the channel open, so only close it once everything that was
+	// handed out has been settled.
+	<-s.done
+	s.inFlight.Wait()
+
+	if closeErr := s.channel.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+type amqpDelivery struct {
+	delivery amqp.Delivery
+	inFlight *sync.WaitGroup
+	once     sync.Once
+}
+
+func (d *amqpDelivery) Message() broker.Message {
+	return broker.Message{
+		Type:        d.delivery.Type,
+		ContentType: d.delivery.ContentType,
+		Body:        d.delivery.Body,
+		Envelope:    envelopeFromDelivery(d.delivery),
+	}
+}
+
+func (d *amqpDelivery) Redelivered() bool {
+	return d.delivery.Redelivered
+}
+
+func (d *amqpDelivery) Ack() error {
+	defer d.settle()
+	return d.delivery.Ack(false)
+}
+
+func (d *amqpDelivery) Nack(requeue bool) error {
+	defer d.settle()
+	return d.delivery.Nack(false, requeue)
+}
+
+func (d *amqpDelivery) settle() {
+	d.once.Do(d.inFlight.Done)
+}
+
+func toPublishing(message broker.Message) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range message.Envelope.Headers() {
+		headers[k] = v
+	}
+
+	return amqp.Publishing{
+		ContentType:   message.ContentType,
+		Body:          message.Body,
+		Type:          message.Type,
+		MessageId:     message.Envelope.MessageID,
+		CorrelationId: message.Envelope.CorrelationID,
+		Timestamp:     message.Envelope.Timestamp,
+		AppId:         message.Envelope.Producer,
+		Headers:       headers,
+	}
+}
+
+// envelopeFromDelivery reads the envelope back from a delivery. Messages
+// published before envelopes existed only get the AMQP properties.
+func envelopeFromDelivery(delivery amqp.Delivery) broker.Envelope {
+	headers := map[string]string{}
+	for k, v := range delivery.Headers {
+		if s, ok := v.(string); ok {
+			headers[k] = s
+		}
+	}
+
+	envelope := broker.EnvelopeFromHeaders(headers)
+
+	if delivery.MessageId != "" {
+		envelope.MessageID = delivery.MessageId
+	}
+
+	if delivery.CorrelationId != "" {
+		envelope.CorrelationID = delivery.CorrelationId
+	}
+
+	if delivery.AppId != "" {
+		envelope.Producer = delivery.AppId
+	}
+
+	if envelope.Timestamp.IsZero() {
+		envelope.Timestamp = delivery.Timestamp
+	}
+
+	return envelope
 }
@@ -0,0 +1,326 @@
+package reconciliation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// MissingOurs is a settled line with no transfer of ours.
+	MissingOurs = "missing_ours"
+	// MissingTheirs is a transfer of ours the custodian did not settle.
+	MissingTheirs = "missing_theirs"
+	// AmountDiffers is a transfer settled for another amount.
+	AmountDiffers = "amount_differs"
+	// DuplicateLine is a transfer settled more than once in a file.
+	DuplicateLine = "duplicate_line"
+)
+
+// Transfer is our record of a transfer, as a settlement should show it.
+type Transfer struct {
+	ExecutionID string
+	AmountCents int64
+}
+
+// Ledger is our side of the reconciliation.
+type Ledger interface {
+	// TransfersOn lists the transfers we expect to settle on date.
+	TransfersOn(ctx context.Context, date string) ([]Transfer, error)
+	// Transfer finds a transfer whatever its date. ok is false when we have
+	// none.
+	Transfer(ctx context.Context, executionID string) (transfer Transfer, ok bool, err error)
+}
+
+type Mismatch struct {
+	Kind             string `json:"kind"`
+	ExecutionID      string `json:"execution_id"`
+	OurAmountCents   int64  `json:"our_amount_cents"`
+	TheirAmountCents int64  `json:"their_amount_cents"`
+	// Line is the settlement file line, 0 for transfers missing on theirs.
+	Line int `json:"line,omitempty"`
+}
+
+// Report is the outcome of reconciling one settlement file. Its ID is the
+// file name without extension.
+type Report struct {
+	ID         string     `json:"id"`
+	File       string     `json:"file"`
+	Date       string     `json:"settlement_date"`
+	Lines      int        `json:"lines"`
+	Transfers  int        `json:"transfers"`
+	Matched    int        `json:"matched"`
+	Mismatches []Mismatch `json:"mismatches"`
+	Cases      []string   `json:"cases"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Summary is a report without its mismatches, small enough for workflow
+// history.
+type Summary struct {
+	ID         string
+	Date       string
+	Lines      int
+	Matched    int
+	Mismatches int
+	Cases      int
+}
+
+func (r *Report) Summary() Summary {
+	return Summary{
+		ID:         r.ID,
+		Date:       r.Date,
+		Lines:      r.Lines,
+		Matched:    r.Matched,
+		Mismatches: len(r.Mismatches),
+		Cases:      len(r.Cases),
+	}
+}
+
+// Match compares a settlement with our transfers. expected are the
+// transfers due on the settlement date; lines for other transfers are looked
+// up in the ledger, so a transfer settling the day after it was created is
+// not reported missing on our side.
+func Match(ctx context.Context, settlement *Settlement, ledger Ledger) (*Report, error) {
+	expected, err := ledger.TransfersOn(ctx, settlement.Date)
+	if err != nil {
+		return nil, err
+	}
+
+	ours := make(map[string]Transfer, len(expected))
+	for _, transfer := range expected {
+		ours[transfer.ExecutionID] = transfer
+	}
+
+	report := &Report{
+		ID:         strings.TrimSuffix(filepath.Base(settlement.File), filepath.Ext(settlement.File)),
+		File:       filepath.Base(settlement.File),
+		Date:       settlement.Date,
+		Lines:      len(settlement.Lines),
+		Transfers:  len(expected),
+		Mismatches: []Mismatch{},
+		Cases:      []string{},
+	}
+
+	seen := map[string]bool{}
+
+	for _, line := range settlement.Lines {
+		if seen[line.ExecutionID] {
+			report.Mismatches = append(report.Mismatches, Mismatch{
+				Kind:             DuplicateLine,
+				ExecutionID:      line.ExecutionID,
+				TheirAmountCents: line.AmountCents,
+				Line:             line.Line,
+			})
+			continue
+		}
+
+		seen[line.ExecutionID] = true
+
+		transfer, ok := ours[line.ExecutionID]
+		if !ok {
+			transfer, ok, err = ledger.Transfer(ctx, line.ExecutionID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case !ok:
+			report.Mismatches = append(report.Mismatches, Mismatch{
+				Kind:             MissingOurs,
+				ExecutionID:      line.ExecutionID,
+				TheirAmountCents: line.AmountCents,
+				Line:             line.Line,
+			})
+		case transfer.AmountCents != line.AmountCents:
+			report.Mismatches = append(report.Mismatches, Mismatch{
+				Kind:             AmountDiffers,
+				ExecutionID:      line.ExecutionID,
+				OurAmountCents:   transfer.AmountCents,
+				TheirAmountCents: line.AmountCents,
+				Line:             line.Line,
+			})
+		default:
+			report.Matched++
+		}
+	}
+
+	sort.Slice(expected, func(i, j int) bool {
+		return expected[i].ExecutionID < expected[j].ExecutionID
+	})
+
+	for _, transfer := range expected {
+		if !seen[transfer.ExecutionID] {
+			report.Mismatches = append(report.Mismatches, Mismatch{
+				Kind:           MissingTheirs,
+				ExecutionID:    transfer.ExecutionID,
+				OurAmountCents: transfer.AmountCents,
+			})
+		}
+	}
+
+	return report, nil
+}
+
+type Config struct {
+	// InboxDir is where settlement files are dropped. Reconciled files
+	// are moved to its processed/ directory.
+	InboxDir  string
+	ReportDir string
+}
+
+// Reconciler checks the custodian's settlement files against our
+// transfers.
+type Reconciler interface {
+	// Pending lists the settlement files waiting in the inbox, by
+	// name.
+	Pending() ([]string, error)
+	// Reconcile matches a settlement file, writes its report, opens a case
+	// per mismatch and archives the file. Running it again on the same file
+	// opens no new cases.
+	Reconcile(ctx context.Context, path string) (*Report, error)
+}
+
+type reconcilerImpl struct {
+	ledger Ledger
+	store  Store
+	config Config
+	logger *zap.SugaredLogger
+}
+
+func NewReconciler(ledger Ledger, store Store, config Config) Reconciler {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("reconciliation")
+
+	return &reconcilerImpl{
+		ledger: ledger,
+		store:  store,
+		config: config,
+		logger: logger.Sugar(),
+	}
+}
+
+func (r *reconcilerImpl) Pending() ([]string, error) {
+	files, err := ioutil.ReadDir(r.config.InboxDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, file := range files {
+		if !file.IsDir() && strings.EqualFold(filepath.Ext(file.Name()), ".csv") {
+			paths = append(paths, filepath.Join(r.config.InboxDir, file.Name()))
+		}
+	}
+
+	return paths, nil
+}
+
+func (r *reconcilerImpl) Reconcile(ctx context.Context, path string) (*Report, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	settlement, err := ParseSettlement(path, file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := Match(ctx, settlement, r.ledger)
+	if err != nil {
+		return nil, err
+	}
+
+	report.CreatedAt = time.Now().UTC()
+
+	for _, mismatch := range report.Mismatches {
+		c, err := r.store.OpenCase(ctx, Case{
+			ID:               caseID(report.ID, mismatch),
+			Report:           report.ID,
+			Date:             report.Date,
+			Kind:             mismatch.Kind,
+			ExecutionID:      mismatch.ExecutionID,
+			OurAmountCents:   mismatch.OurAmountCents,
+			TheirAmountCents: mismatch.TheirAmountCents,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		report.Cases = append(report.Cases, c.ID)
+	}
+
+	_, err = writeReport(r.config.ReportDir, report)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.store.SaveReport(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+
+	processed := filepath.Join(filepath.Dir(path), "processed")
+
+	err = os.MkdirAll(processed, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(path, filepath.Join(processed, filepath.Base(path)))
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.Infow("Settlement reconciled", "file", path, "date", report.Date, "matched", report.Matched, "mismatches", len(report.Mismatches))
+
+	return report, nil
+}
+
+func caseID(report string, mismatch Mismatch) string {
+	id := fmt.Sprintf("%s_%s_%s", report, mismatch.Kind, mismatch.ExecutionID)
+	if mismatch.Kind == DuplicateLine {
+		id = fmt.Sprintf("%s_%d", id, mismatch.Line)
+	}
+
+	return id
+}
+
+// writeReport writes the report to dir as <id>.report.json and returns its
+// path.
+func writeReport(dir string, report *Report) (string, error) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, report.ID+".report.json")
+	tmp := path + ".tmp"
+
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return path, os.Rename(tmp, path)
+}
@@ -0,0 +1,134 @@
+package reconciliation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const settlementDate = "2006-01-02"
+
+var fileDate = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})`)
+
+// SettlementLine is one transfer as the custodian settled it.
+type SettlementLine struct {
+	ExecutionID string `json:"execution_id"`
+	AmountCents int64  `json:"amount_cents"`
+	Currency    string `json:"currency,omitempty"`
+	Status      string `json:"status,omitempty"`
+	Date        string `json:"settlement_date"`
+	// Line is the line number in the file, counting the header.
+	Line int `json:"line"`
+}
+
+// Settlement is a parsed statement file. Every line settles on Date.
+type Settlement struct {
+	File  string
+	Date  string
+	Lines []SettlementLine
+}
+
+// ParseSettlement reads an Apex settlement CSV. The header names the
+// columns; execution_id, amount and settlement_date are required, currency
+// and status are kept when present. A file without lines takes its date
+// from its name.
+func ParseSettlement(name string, r io.Reader) (*Settlement, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%s: missing header", name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+
+	for _, required := range []string{"execution_id", "amount", "settlement_date"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%s: missing column %s", name, required)
+		}
+	}
+
+	field := func(record []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+
+		return strings.TrimSpace(record[i])
+	}
+
+	settlement := &Settlement{File: name}
+
+	for n := 2; ; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		line := SettlementLine{
+			ExecutionID: field(record, "execution_id"),
+			Currency:    strings.ToUpper(field(record, "currency")),
+			Status:      strings.ToLower(field(record, "status")),
+			Date:        field(record, "settlement_date"),
+			Line:        n,
+		}
+
+		if line.ExecutionID == "" {
+			return nil, fmt.Errorf("%s:%d: missing execution_id", name, n)
+		}
+
+		amount, err := strconv.ParseFloat(strings.Replace(field(record, "amount"), ",", "", -1), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid amount %q", name, n, field(record, "amount"))
+		}
+
+		line.AmountCents = int64(math.Round(amount * 100))
+
+		_, err = time.Parse(settlementDate, line.Date)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid settlement_date %q", name, n, line.Date)
+		}
+
+		if settlement.Date == "" {
+			settlement.Date = line.Date
+		} else if settlement.Date != line.Date {
+			return nil, fmt.Errorf("%s:%d: settles on %s, the file on %s", name, n, line.Date, settlement.Date)
+		}
+
+		settlement.Lines = append(settlement.Lines, line)
+	}
+
+	if settlement.Date == "" {
+		match := fileDate.FindStringSubmatch(filepath.Base(name))
+		if match == nil {
+			return nil, fmt.Errorf("%s: no lines and no date in the file name", name)
+		}
+
+		settlement.Date = match[1] + "-" + match[2] + "-" + match[3]
+	}
+
+	return settlement, nil
+}
@@ -0,0 +1,261 @@
+package reconciliation
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/redis"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+const (
+	CaseOpen     = "open"
+	CaseResolved = "resolved"
+
+	reportsKey = "recon_reports"
+)
+
+var (
+	ErrCaseNotFound   = errors.New("reconciliation case not found")
+	ErrReportNotFound = errors.New("reconciliation report not found")
+	ErrCaseResolved   = errors.New("reconciliation case already resolved")
+)
+
+// Case is an exception an operator has to look at: a mismatch found by a
+// reconciliation or one opened by hand.
+type Case struct {
+	ID               string    `json:"id"`
+	Report           string    `json:"report,omitempty"`
+	Date             string    `json:"settlement_date,omitempty"`
+	Kind             string    `json:"kind"`
+	ExecutionID      string    `json:"execution_id"`
+	OurAmountCents   int64     `json:"our_amount_cents"`
+	TheirAmountCents int64     `json:"their_amount_cents"`
+	Note             string    `json:"note,omitempty"`
+	Status           string    `json:"status"`
+	Resolution       string    `json:"resolution,omitempty"`
+	OpenedAt         time.Time `json:"opened_at"`
+	ResolvedAt       time.Time `json:"resolved_at,omitempty"`
+}
+
+// Store keeps reconciliation reports and exception cases.
+type Store interface {
+	SaveReport(ctx context.Context, report *Report) error
+	Report(ctx context.Context, id string) (*Report, error)
+	// Reports lists the report ids, by name.
+	Reports(ctx context.Context) ([]string, error)
+	// OpenCase stores a new open case. A case with the same id is returned
+	// as it is, so mismatches found again do not reopen resolved cases.
+	OpenCase(ctx context.Context, c Case) (*Case, error)
+	Case(ctx context.Context, id string) (*Case, error)
+	// Cases lists the cases in a status, or all of them for "", oldest
+	// first.
+	Cases(ctx context.Context, status string) ([]*Case, error)
+	ResolveCase(ctx context.Context, id, resolution string) (*Case, error)
+}
+
+type storeImpl struct {
+	redis redis.RedisConnection
+}
+
+func NewStore(redis redis.RedisConnection) Store {
+	return &storeImpl{redis: redis}
+}
+
+func (s *storeImpl) SaveReport(ctx context.Context, report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redis.GetConn().TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Set(ctx, reportKey(report.ID), data, 0)
+		pipe.SAdd(ctx, reportsKey, report.ID)
+		return nil
+	})
+
+	return err
+}
+
+func (s *storeImpl) Report(ctx context.Context, id string) (*Report, error) {
+	result, err := s.redis.GetConn().Get(ctx, reportKey(id)).Result()
+	if s.redis.NoKeyError(err) {
+		return nil, ErrReportNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+	err = json.Unmarshal([]byte(result), &report)
+
+	return &report, err
+}
+
+func (s *storeImpl) Reports(ctx context.Context) ([]string, error) {
+	ids, err := s.redis.GetConn().SMembers(ctx, reportsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (s *storeImpl) OpenCase(ctx context.Context, c Case) (*Case, error) {
+	if c.ID == "" || c.Kind == "" {
+		return nil, fmt.Errorf("case needs an id and a kind")
+	}
+
+	c.Status = CaseOpen
+	c.Resolution = ""
+	c.OpenedAt = time.Now().UTC()
+	c.ResolvedAt = time.Time{}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	key := caseKey(c.ID)
+
+	created, err := s.redis.GetConn().SetNX(ctx, key, data, 0).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if !created {
+		return s.Case(ctx, c.ID)
+	}
+
+	err = s.redis.GetConn().SAdd(ctx, casesKey(CaseOpen), c.ID).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (s *storeImpl) Case(ctx context.Context, id string) (*Case, error) {
+	result, err := s.redis.GetConn().Get(ctx, caseKey(id)).Result()
+	if s.redis.NoKeyError(err) {
+		return nil, ErrCaseNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var c Case
+	err = json.Unmarshal([]byte(result), &c)
+
+	return &c, err
+}
+
+func (s *storeImpl) Cases(ctx context.Context, status string) ([]*Case, error) {
+	statuses := []string{CaseOpen, CaseResolved}
+	if status != "" {
+		statuses = []string{status}
+	}
+
+	var cases []*Case
+
+	for _, status := range statuses {
+		ids, err := s.redis.GetConn().SMembers(ctx, casesKey(status)).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range ids {
+			c, err := s.Case(ctx, id)
+			if err == ErrCaseNotFound {
+				continue
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			cases = append(cases, c)
+		}
+	}
+
+	sort.Slice(cases, func(i, j int) bool {
+		if !cases[i].OpenedAt.Equal(cases[j].OpenedAt) {
+			return cases[i].OpenedAt.Before(cases[j].OpenedAt)
+		}
+
+		return cases[i].ID < cases[j].ID
+	})
+
+	return cases, nil
+}
+
+func (s *storeImpl) ResolveCase(ctx context.Context, id, resolution string) (*Case, error) {
+	key := caseKey(id)
+
+	var resolved *Case
+
+	err := s.redis.GetConn().Watch(ctx, func(tx *goredis.Tx) error {
+		result, err := tx.Get(ctx, key).Result()
+		if s.redis.NoKeyError(err) {
+			return ErrCaseNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var c Case
+		err = json.Unmarshal([]byte(result), &c)
+		if err != nil {
+			return err
+		}
+
+		if c.Status == CaseResolved {
+			return ErrCaseResolved
+		}
+
+		c.Status = CaseResolved
+		c.Resolution = resolution
+		c.ResolvedAt = time.Now().UTC()
+
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.Set(ctx, key, data, 0)
+			pipe.SMove(ctx, casesKey(CaseOpen), casesKey(CaseResolved), id)
+			return nil
+		})
+
+		resolved = &c
+
+		return err
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolved, nil
+}
+
+func reportKey(id string) string {
+	return fmt.Sprintf("recon_report_%s", id)
+}
+
+func caseKey(id string) string {
+	return fmt.Sprintf("recon_case_%s", id)
+}
+
+func casesKey(status string) string {
+	return fmt.Sprintf("recon_cases_%s", status)
+}
@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/apex"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type apexHistoryImpl struct {
+	redis RedisConnection
+	ttl   time.Duration
+}
+
+// NewApexHistory stores Apex status histories as Redis lists of JSON
+// entries, kept for ttl after the last change.
+func NewApexHistory(redis RedisConnection, ttl time.Duration) apex.HistoryStore {
+	return &apexHistoryImpl{
+		redis: redis,
+		ttl:   ttl,
+	}
+}
+
+func (h *apexHistoryImpl) Load(ctx context.Context, executionID string) ([]apex.StatusChange, error) {
+	entries, err := h.redis.GetConn().LRange(ctx, h.key(executionID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]apex.StatusChange, 0, len(entries))
+	for _, entry := range entries {
+		var change apex.StatusChange
+		err = json.Unmarshal([]byte(entry), &change)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, change)
+	}
+
+	return history, nil
+}
+
+func (h *apexHistoryImpl) Append(ctx context.Context, executionID string, n int, change apex.StatusChange) error {
+	key := h.key(executionID)
+
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	err = h.redis.GetConn().Watch(ctx, func(tx *redis.Tx) error {
+		length, err := tx.LLen(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+
+		if length != int64(n) {
+			return apex.ErrHistoryChanged
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.RPush(ctx, key, data)
+			pipe.Expire(ctx, key, h.ttl)
+			return nil
+		})
+
+		return err
+	}, key)
+
+	if err == redis.TxFailedErr {
+		return apex.ErrHistoryChanged
+	}
+
+	return err
+}
+
+func (h *apexHistoryImpl) key(executionID string) string {
+	return fmt.Sprintf("apex_history_%s", executionID)
+}
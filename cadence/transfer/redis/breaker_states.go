@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/resilience"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type breakerStatesImpl struct {
+	redis RedisConnection
+}
+
+// NewBreakerStates shares open breakers through Redis. A key only lives
+// while its breaker is open, so a process that died with a breaker open
+// does not keep the provider blocked.
+func NewBreakerStates(redis RedisConnection) resilience.StateStore {
+	return &breakerStatesImpl{redis: redis}
+}
+
+func (b *breakerStatesImpl) Save(ctx context.Context, state resilience.State) error {
+	ttl := time.Until(state.RetryAt)
+
+	if state.State != resilience.StateOpen || ttl <= 0 {
+		return b.redis.GetConn().Del(ctx, b.key(state.Provider)).Err()
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return b.redis.GetConn().Set(ctx, b.key(state.Provider), data, ttl).Err()
+}
+
+func (b *breakerStatesImpl) Load(ctx context.Context, provider string) (*resilience.State, error) {
+	result, err := b.redis.GetConn().Get(ctx, b.key(provider)).Result()
+	if b.redis.NoKeyError(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var state resilience.State
+	err = json.Unmarshal([]byte(result), &state)
+
+	return &state, err
+}
+
+func (b *breakerStatesImpl) key(provider string) string {
+	return fmt.Sprintf("provider_breaker_%s", provider)
+}
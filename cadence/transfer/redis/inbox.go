@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	inboxProcessing = "processing"
+	inboxDone       = "done"
+)
+
+var ErrInboxInProgress = errors.New("message is being processed by another consumer")
+
+// releaseScript only drops a claim that is still in progress, so a late
+// release can't erase a message another consumer already completed.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Inbox records the ids of messages a consumer has processed. A message is
+// claimed before its handler runs and completed afterwards; the claim has a
+// short lease so a consumer that crashes mid-message doesn't block the
+// redelivery forever.
+//
+// The id is recorded after the side effects, not with them, so a consumer
+// dying in between runs them again on redelivery. Handlers must still be
+// idempotent; the transfer ones are, through their Cadence workflow ids.
+type Inbox interface {
+	// Claim returns false when the message was already processed and
+	// ErrInboxInProgress when another consumer holds it.
+	Claim(ctx context.Context, messageID string) (bool, error)
+	Complete(ctx context.Context, messageID string) error
+	Release(ctx context.Context, messageID string) error
+}
+
+type inboxImpl struct {
+	redis RedisConnection
+	name  string
+	lease time.Duration
+	ttl   time.Duration
+}
+
+func NewInbox(redis RedisConnection, name string, lease, ttl time.Duration) Inbox {
+	return &inboxImpl{
+		redis: redis,
+		name:  name,
+		lease: lease,
+		ttl:   ttl,
+	}
+}
+
+func (i *inboxImpl) Claim(ctx context.Context, messageID string) (bool, error) {
+	key := i.key(messageID)
+
+	claimed, err := i.redis.GetConn().SetNX(ctx, key, inboxProcessing, i.lease).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if claimed {
+		return true, nil
+	}
+
+	state, err := i.redis.GetConn().Get(ctx, key).Result()
+	if i.redis.NoKeyError(err) {
+		// The other claim expired between both calls, try again.
+		return i.Claim(ctx, messageID)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if state == inboxDone {
+		return false, nil
+	}
+
+	return false, ErrInboxInProgress
+}
+
+func (i *inboxImpl) Complete(ctx context.Context, messageID string) error {
+	return i.redis.GetConn().Set(ctx, i.key(messageID), inboxDone, i.ttl).Err()
+}
+
+func (i *inboxImpl) Release(ctx context.Context, messageID string) error {
+	return releaseScript.Run(ctx, i.redis.GetConn(), []string{i.key(messageID)}, inboxProcessing).Err()
+}
+
+func (i *inboxImpl) key(messageID string) string {
+	return fmt.Sprintf("inbox_%s_%s", i.name, messageID)
+}
@@ -0,0 +1,389 @@
+package redis
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pborman/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	fieldType        = "type"
+	fieldContentType = "content_type"
+	fieldBody        = "body"
+)
+
+type StreamConfig struct {
+	Stream string
+	// MaxLen trims the stream to roughly this many entries on every publish.
+	// Trimming can drop entries nobody has read yet, so keep it well above
+	// the expected backlog.
+	MaxLen int64
+	// Entries pending longer than ClaimMinIdle belong to a consumer that
+	// probably crashed and are claimed by whoever checks next.
+	ClaimMinIdle  time.Duration
+	ClaimInterval time.Duration
+	Block         time.Duration
+}
+
+// streamBroker is the Redis Streams implementation of broker.Broker.
+// Subscribers with the same name share a consumer group, so they compete
+// for entries like consumers of the AMQP queue do.
+type streamBroker struct {
+	redis  RedisConnection
+	config StreamConfig
+	logger *zap.SugaredLogger
+}
+
+func NewStreamBroker(redis RedisConnection, config StreamConfig) broker.Broker {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("redis_stream_broker")
+
+	if config.Stream == "" {
+		config.Stream = "transfers"
+	}
+
+	if config.MaxLen <= 0 {
+		config.MaxLen = 100000
+	}
+
+	if config.ClaimMinIdle <= 0 {
+		config.ClaimMinIdle = time.Minute
+	}
+
+	if config.ClaimInterval <= 0 {
+		config.ClaimInterval = 15 * time.Second
+	}
+
+	if config.Block <= 0 {
+		config.Block = time.Second
+	}
+
+	return &streamBroker{
+		redis:  redis,
+		config: config,
+		logger: logger.Sugar(),
+	}
+}
+
+func (b *streamBroker) Publish(ctx context.Context, message broker.Message) error {
+	id, err := b.redis.GetConn().XAdd(ctx, &redis.XAddArgs{
+		Stream:       b.config.Stream,
+		MaxLenApprox: b.config.MaxLen,
+		Values:       toValues(message),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	b.logger.Infow("Sent message", "type", message.Type, "id", message.Envelope.MessageID, "entry", id, "correlation", message.Envelope.CorrelationID)
+
+	return nil
+}
+
+func (b *streamBroker) Subscribe(options broker.SubscribeOptions) (broker.Subscription, error) {
+	ctx := context.Background()
+
+	err := b.redis.GetConn().XGroupCreateMkStream(ctx, b.config.Stream, options.Name, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+
+	prefetch := options.Prefetch
+	if prefetch <= 0 {
+		prefetch = 1
+	}
+
+	host, _ := os.Hostname()
+
+	sub := &streamSubscription{
+		broker:     b,
+		group:      options.Name,
+		consumer:   fmt.Sprintf("%s-%s-%s", options.Name, host, uuid.New()[:8]),
+		credits:    make(chan struct{}, prefetch),
+		deliveries: make(chan broker.Delivery),
+		done:       make(chan struct{}),
+		exited:     make(chan struct{}),
+	}
+
+	go sub.run()
+
+	return sub, nil
+}
+
+func (b *streamBroker) Ping(ctx context.Context) error {
+	return b.redis.GetConn().Ping(ctx).Err()
+}
+
+func (b *streamBroker) Close() error {
+	return nil
+}
+
+func (b *streamBroker) deadLetterStream() string {
+	return b.config.Stream + ".dead"
+}
+
+type streamSubscription struct {
+	broker     *streamBroker
+	group      string
+	consumer   string
+	credits    chan struct{}
+	deliveries chan broker.Delivery
+	done       chan struct{}
+	exited     chan struct{}
+	inFlight   sync.WaitGroup
+}
+
+func (s *streamSubscription) run() {
+	defer close(s.exited)
+	defer close(s.deliveries)
+
+	ctx := context.Background()
+	lastClaim := time.Time{}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		// deliver blocks on the credits, so with none free this reads a
+		// single entry and waits for an ack before handing it out.
+		free := cap(s.credits) - len(s.credits)
+		if free == 0 {
+			free = 1
+		}
+
+		var entries []redis.XMessage
+		redelivered := false
+
+		if time.Since(lastClaim) >= s.broker.config.ClaimInterval {
+			lastClaim = time.Now()
+			entries = s.claim(ctx, free)
+			redelivered = true
+		}
+
+		if len(entries) == 0 {
+			entries = s.read(ctx, free)
+			redelivered = false
+		}
+
+		for _, entry := range entries {
+			if !s.deliver(entry, redelivered) {
+				return
+			}
+		}
+	}
+}
+
+func (s *streamSubscription) read(ctx context.Context, count int) []redis.XMessage {
+	streams, err := s.broker.redis.GetConn().XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    s.group,
+		Consumer: s.consumer,
+		Streams:  []string{s.broker.config.Stream, ">"},
+		Count:    int64(count),
+		Block:    s.broker.config.Block,
+	}).Result()
+
+	if s.broker.redis.NoKeyError(err) {
+		return nil
+	}
+
+	if err != nil {
+		s.broker.logger.Errorw("Error reading stream", "stream", s.broker.config.Stream, "err", err)
+		time.Sleep(s.broker.config.Block)
+		return nil
+	}
+
+	var entries []redis.XMessage
+	for _, stream := range streams {
+		entries = append(entries, stream.Messages...)
+	}
+
+	return entries
+}
+
+// claim takes over entries another consumer left pending for longer than
+// ClaimMinIdle. XAUTOCLAIM needs Redis 6.2, and go-redis has no helper for
+// it yet, so the reply is parsed by hand.
+func (s *streamSubscription) claim(ctx context.Context, count int) []redis.XMessage {
+	reply, err := s.broker.redis.GetConn().Do(ctx, "XAUTOCLAIM",
+		s.broker.config.Stream,
+		s.group,
+		s.consumer,
+		s.broker.config.ClaimMinIdle.Milliseconds(),
+		"0-0",
+		"COUNT", count).Result()
+	if err != nil {
+		s.broker.logger.Errorw("Error claiming pending entries", "stream", s.broker.config.Stream, "err", err)
+		return nil
+	}
+
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) < 2 {
+		return nil
+	}
+
+	raw, _ := parts[1].([]interface{})
+
+	var entries []redis.XMessage
+	for _, r := range raw {
+		fields, ok := r.([]interface{})
+		if !ok || len(fields) != 2 {
+			continue
+		}
+
+		id, _ := fields[0].(string)
+		kv, ok := fields[1].([]interface{})
+		if !ok {
+			// The entry was trimmed while pending, only its id is left.
+			s.broker.redis.GetConn().XAck(ctx, s.broker.config.Stream, s.group, id)
+			continue
+		}
+
+		values := map[string]interface{}{}
+		for i := 0; i+1 < len(kv); i += 2 {
+			key, _ := kv[i].(string)
+			values[key] = kv[i+1]
+		}
+
+		entries = append(entries, redis.XMessage{ID: id, Values: values})
+	}
+
+	return entries
+}
+
+func (s *streamSubscription) deliver(entry redis.XMessage, claimed bool) bool {
+	select {
+	case s.credits <- struct{}{}:
+	case <-s.done:
+		return false
+	}
+
+	s.inFlight.Add(1)
+	d := &streamDelivery{
+		sub:         s,
+		entry:       entry,
+		redelivered: claimed,
+	}
+
+	select {
+	case s.deliveries <- d:
+		return true
+	case <-s.done:
+		// Left pending, another consumer will claim it.
+		d.settle()
+		return false
+	}
+}
+
+func (s *streamSubscription) Deliveries() <-chan broker.Delivery {
+	return s.deliveries
+}
+
+func (s *streamSubscription) Close() error {
+	close(s.done)
+	<-s.exited
+	s.inFlight.Wait()
+
+	return nil
+}
+
+type streamDelivery struct {
+	sub         *streamSubscription
+	entry       redis.XMessage
+	redelivered bool
+	once        sync.Once
+}
+
+func (d *streamDelivery) Message() broker.Message {
+	headers := map[string]string{}
+	for k := range d.entry.Values {
+		headers[k] = stringValue(d.entry.Values, k)
+	}
+
+	return broker.Message{
+		Type:        headers[fieldType],
+		ContentType: headers[fieldContentType],
+		Body:        []byte(headers[fieldBody]),
+		Envelope:    broker.EnvelopeFromHeaders(headers),
+	}
+}
+
+func (d *streamDelivery) Redelivered() bool {
+	return d.redelivered
+}
+
+func (d *streamDelivery) Ack() error {
+	defer d.settle()
+
+	return d.sub.broker.redis.GetConn().XAck(context.Background(), d.sub.broker.config.Stream, d.sub.group, d.entry.ID).Err()
+}
+
+// Nack leaves a requeued entry pending, so it is claimed again once it has
+// been idle for ClaimMinIdle. Adding it back to the stream would hand it to
+// every other consumer group too. Rejected entries move to the dead letter
+// stream in one transaction with the ack.
+func (d *streamDelivery) Nack(requeue bool) error {
+	defer d.settle()
+
+	if requeue {
+		return nil
+	}
+
+	ctx := context.Background()
+	config := d.sub.broker.config
+
+	_, err := d.sub.broker.redis.GetConn().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream:       d.sub.broker.deadLetterStream(),
+			MaxLenApprox: config.MaxLen,
+			Values:       toValues(d.Message()),
+		})
+		pipe.XAck(ctx, config.Stream, d.sub.group, d.entry.ID)
+
+		return nil
+	})
+
+	return err
+}
+
+func (d *streamDelivery) settle() {
+	d.once.Do(func() {
+		<-d.sub.credits
+		d.sub.inFlight.Done()
+	})
+}
+
+func toValues(message broker.Message) map[string]interface{} {
+	values := map[string]interface{}{
+		fieldType:        message.Type,
+		fieldContentType: message.ContentType,
+		fieldBody:        message.Body,
+	}
+
+	for k, v := range message.Envelope.Headers() {
+		values[k] = v
+	}
+
+	return values
+}
+
+func stringValue(values map[string]interface{}, key string) string {
+	switch v := values[key].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+
+	return ""
+}
@@ -0,0 +1,138 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+// stateValues are the breaker_state gauge values.
+var stateValues = map[string]float64{
+	StateClosed:   0,
+	StateHalfOpen: 1,
+	StateOpen:     2,
+}
+
+// breaker opens after FailureThreshold failures in a row and rejects calls
+// for OpenTimeout. It then lets HalfOpenProbes calls through: one failure
+// opens it again, HalfOpenProbes successes close it.
+type breaker struct {
+	mu       sync.Mutex
+	config   Config
+	state    string
+	failures int
+	probes   int
+	passed   int
+	openedAt time.Time
+	// generation changes with every state change, so calls admitted in an
+	// earlier state don't count in the current one.
+	generation int64
+	onChange   func(from, to string)
+}
+
+func newBreaker(config Config, onChange func(from, to string)) *breaker {
+	return &breaker{config: config, state: StateClosed, onChange: onChange}
+}
+
+// allow admits a call, returning its generation, or how long until the
+// breaker lets calls through again.
+func (b *breaker) allow(now time.Time) (int64, time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen {
+		retryAt := b.openedAt.Add(b.config.OpenTimeout)
+		if now.Before(retryAt) {
+			return 0, retryAt.Sub(now), false
+		}
+
+		b.setState(StateHalfOpen, now)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.probes >= b.config.HalfOpenProbes {
+			return 0, b.config.OpenTimeout, false
+		}
+
+		b.probes++
+	}
+
+	return b.generation, 0, true
+}
+
+// cancel gives back a call that was admitted but never made.
+func (b *breaker) cancel(generation int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation == b.generation && b.state == StateHalfOpen {
+		b.probes--
+	}
+}
+
+func (b *breaker) record(generation int64, failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+
+		b.failures++
+		if b.failures >= b.config.FailureThreshold {
+			b.setState(StateOpen, now)
+		}
+
+	case StateHalfOpen:
+		if failed {
+			b.setState(StateOpen, now)
+			return
+		}
+
+		b.passed++
+		if b.passed >= b.config.HalfOpenProbes {
+			b.setState(StateClosed, now)
+		}
+	}
+}
+
+func (b *breaker) setState(state string, now time.Time) {
+	from := b.state
+
+	b.state = state
+	b.generation++
+	b.failures = 0
+	b.probes = 0
+	b.passed = 0
+
+	if state == StateOpen {
+		b.openedAt = now
+	}
+
+	if b.onChange != nil {
+		b.onChange(from, state)
+	}
+}
+
+func (b *breaker) snapshot() (state string, failures int, retryAt time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen {
+		retryAt = b.openedAt.Add(b.config.OpenTimeout)
+	}
+
+	return b.state, b.failures, retryAt
+}
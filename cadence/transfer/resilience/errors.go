@@ -0,0 +1,75 @@
+package resilience
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// ReasonProviderUnavailable is the cadence.CustomError reason of
+	// activities failing on an Error. Its detail is the number of seconds
+	// to wait before trying again.
+	ReasonProviderUnavailable = "provider_unavailable"
+
+	CauseBreakerOpen  = "breaker_open"
+	CauseBulkheadFull = "bulkhead_full"
+	CauseTimeout      = "timeout"
+)
+
+// Error is returned instead of calling a provider that is failing or
+// saturated, and when a call runs out of its timeout budget. The call can
+// be tried again after RetryAfter.
+type Error struct {
+	Provider   string
+	Operation  string
+	Cause      string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("provider %s unavailable for %s: %s", e.Provider, e.Operation, e.Cause)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Retryable() bool {
+	return true
+}
+
+// IsUnavailable returns the Error in err's chain, if any.
+func IsUnavailable(err error) (*Error, bool) {
+	var unavailable *Error
+	if errors.As(err, &unavailable) {
+		return unavailable, true
+	}
+
+	return nil, false
+}
+
+type clientError struct {
+	err error
+}
+
+func (e *clientError) Error() string {
+	return e.err.Error()
+}
+
+// ClientError marks an error the provider answered with on purpose, such as
+// a rejected request. It is returned as it is by Execute and does not count
+// against the breaker.
+func ClientError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &clientError{err}
+}
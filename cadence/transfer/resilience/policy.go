@@ -0,0 +1,331 @@
+package resilience
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// Config sets the limits of every provider of a group.
+type Config struct {
+	// FailureThreshold is how many failures in a row open the breaker.
+	FailureThreshold int
+	// OpenTimeout is how long an open breaker rejects calls before probing.
+	OpenTimeout time.Duration
+	// HalfOpenProbes is how many probe calls a half-open breaker lets
+	// through, all of which must succeed to close it.
+	HalfOpenProbes int
+	// MaxConcurrent is the bulkhead: how many calls a provider may have in
+	// flight. MaxWait is how long a call waits for a slot.
+	MaxConcurrent int
+	MaxWait       time.Duration
+	// Timeout is the budget of a call, unless Timeouts has one for its
+	// operation. A shorter deadline on the caller's context wins.
+	Timeout  time.Duration
+	Timeouts map[string]time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+		HalfOpenProbes:   1,
+		MaxConcurrent:    10,
+		Timeout:          10 * time.Second,
+	}
+}
+
+func (c Config) withDefaults() Config {
+	defaults := DefaultConfig()
+
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = defaults.FailureThreshold
+	}
+
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = defaults.OpenTimeout
+	}
+
+	if c.HalfOpenProbes <= 0 {
+		c.HalfOpenProbes = defaults.HalfOpenProbes
+	}
+
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = defaults.MaxConcurrent
+	}
+
+	if c.Timeout <= 0 {
+		c.Timeout = defaults.Timeout
+	}
+
+	return c
+}
+
+// State is what operators see of a provider's breaker and bulkhead.
+type State struct {
+	Provider      string    `json:"provider"`
+	State         string    `json:"state"`
+	Failures      int       `json:"failures"`
+	RetryAt       time.Time `json:"retry_at,omitempty"`
+	InFlight      int       `json:"in_flight"`
+	MaxConcurrent int       `json:"max_concurrent"`
+}
+
+// StateStore shares breaker states between processes, so a worker that
+// never calls a provider can still tell it is down.
+type StateStore interface {
+	Save(ctx context.Context, state State) error
+	// Load returns nil when no process has seen the provider open.
+	Load(ctx context.Context, provider string) (*State, error)
+}
+
+// Policy guards the calls to one provider.
+type Policy interface {
+	// Execute runs fn unless the breaker is open or the bulkhead is full,
+	// with the timeout budget of operation. Those rejections and timeouts
+	// are *Error.
+	Execute(ctx context.Context, operation string, fn func(ctx context.Context) error) error
+	State() State
+}
+
+// Group keeps a policy per provider.
+type Group interface {
+	Policy(provider string) Policy
+	// Check fails fast with an *Error when the provider's breaker is open,
+	// here or, through the state store, in another process.
+	Check(ctx context.Context, provider string) error
+	States() []State
+}
+
+type groupImpl struct {
+	mu       sync.Mutex
+	config   Config
+	scope    tally.Scope
+	store    StateStore
+	policies map[string]*policyImpl
+	logger   *zap.SugaredLogger
+}
+
+// NewGroup creates the policies of a group of providers. store may be nil
+// when breaker states need not leave the process.
+func NewGroup(config Config, scope tally.Scope, store StateStore) Group {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("resilience")
+
+	if scope == nil {
+		scope = tally.NoopScope
+	}
+
+	return &groupImpl{
+		config:   config.withDefaults(),
+		scope:    scope,
+		store:    store,
+		policies: map[string]*policyImpl{},
+		logger:   logger.Sugar(),
+	}
+}
+
+func (g *groupImpl) Policy(provider string) Policy {
+	return g.policy(provider)
+}
+
+func (g *groupImpl) policy(provider string) *policyImpl {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.policies[provider]
+	if ok {
+		return p
+	}
+
+	p = &policyImpl{
+		provider: provider,
+		config:   g.config,
+		slots:    make(chan struct{}, g.config.MaxConcurrent),
+		scope:    g.scope.Tagged(map[string]string{"provider": provider}),
+		logger:   g.logger,
+	}
+	p.breaker = newBreaker(g.config, p.stateChanged)
+	p.store = g.store
+	p.scope.Gauge("breaker_state").Update(stateValues[StateClosed])
+
+	g.policies[provider] = p
+
+	return p
+}
+
+func (g *groupImpl) Check(ctx context.Context, provider string) error {
+	now := time.Now()
+
+	state := g.policy(provider).State()
+	if state.State == StateOpen && now.Before(state.RetryAt) {
+		return &Error{Provider: provider, Operation: "check", Cause: CauseBreakerOpen, RetryAfter: state.RetryAt.Sub(now)}
+	}
+
+	if g.store == nil {
+		return nil
+	}
+
+	shared, err := g.store.Load(ctx, provider)
+	if err != nil {
+		// The shared state is advisory; the call itself is still guarded.
+		g.logger.Warnw("Error loading breaker state", "provider", provider, "err", err)
+		return nil
+	}
+
+	if shared != nil && shared.State == StateOpen && now.Before(shared.RetryAt) {
+		return &Error{Provider: provider, Operation: "check", Cause: CauseBreakerOpen, RetryAfter: shared.RetryAt.Sub(now)}
+	}
+
+	return nil
+}
+
+func (g *groupImpl) States() []State {
+	g.mu.Lock()
+	policies := make([]*policyImpl, 0, len(g.policies))
+	for _, p := range g.policies {
+		policies = append(policies, p)
+	}
+	g.mu.Unlock()
+
+	states := make([]State, 0, len(policies))
+	for _, p := range policies {
+		states = append(states, p.State())
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Provider < states[j].Provider
+	})
+
+	return states
+}
+
+type policyImpl struct {
+	provider string
+	config   Config
+	breaker  *breaker
+	slots    chan struct{}
+	store    StateStore
+	scope    tally.Scope
+	logger   *zap.SugaredLogger
+}
+
+func (p *policyImpl) Execute(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	generation, retryAfter, ok := p.breaker.allow(time.Now())
+	if !ok {
+		p.scope.Counter("breaker_rejected").Inc(1)
+		return &Error{Provider: p.provider, Operation: operation, Cause: CauseBreakerOpen, RetryAfter: retryAfter}
+	}
+
+	if !p.acquire(ctx) {
+		p.breaker.cancel(generation)
+		p.scope.Counter("bulkhead_rejected").Inc(1)
+		return &Error{Provider: p.provider, Operation: operation, Cause: CauseBulkheadFull, RetryAfter: time.Second}
+	}
+	defer p.release()
+
+	timeout := p.config.Timeout
+	if t, ok := p.config.Timeouts[operation]; ok && t > 0 {
+		timeout = t
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	sw := p.scope.Timer("call_latency").Start()
+	err := fn(callCtx)
+	sw.Stop()
+
+	if client, ok := err.(*clientError); ok {
+		p.breaker.record(generation, false, time.Now())
+		p.scope.Counter("call_succeeded").Inc(1)
+		return client.err
+	}
+
+	// A caller giving up is not the provider's fault, nor a sign it is
+	// back, so the call counts neither way.
+	if err != nil && ctx.Err() != nil {
+		p.breaker.cancel(generation)
+		return err
+	}
+
+	p.breaker.record(generation, err != nil, time.Now())
+
+	if err == nil {
+		p.scope.Counter("call_succeeded").Inc(1)
+		return nil
+	}
+
+	p.scope.Counter("call_failed").Inc(1)
+
+	if callCtx.Err() == context.DeadlineExceeded {
+		p.scope.Counter("call_timeout").Inc(1)
+		return &Error{Provider: p.provider, Operation: operation, Cause: CauseTimeout, Err: err}
+	}
+
+	return err
+}
+
+func (p *policyImpl) acquire(ctx context.Context) bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if p.config.MaxWait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(p.config.MaxWait)
+	defer timer.Stop()
+
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *policyImpl) release() {
+	<-p.slots
+}
+
+func (p *policyImpl) State() State {
+	state, failures, retryAt := p.breaker.snapshot()
+
+	return State{
+		Provider:      p.provider,
+		State:         state,
+		Failures:      failures,
+		RetryAt:       retryAt,
+		InFlight:      len(p.slots),
+		MaxConcurrent: cap(p.slots),
+	}
+}
+
+// stateChanged runs with the breaker locked, so the state is shared from
+// another goroutine.
+func (p *policyImpl) stateChanged(from, to string) {
+	p.scope.Gauge("breaker_state").Update(stateValues[to])
+	p.scope.Counter("breaker_" + to).Inc(1)
+	p.logger.Warnw("Breaker state changed", "provider", p.provider, "from", from, "to", to)
+
+	if p.store == nil {
+		return
+	}
+
+	go func() {
+		err := p.store.Save(context.Background(), p.State())
+		if err != nil {
+			p.logger.Errorw("Error saving breaker state", "provider", p.provider, "err", err)
+		}
+	}()
+}
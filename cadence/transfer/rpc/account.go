@@ -0,0 +1,46 @@
+package rpc
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"context"
+)
+
+type accountServerImpl struct {
+	pb.UnimplementedAccountServiceServer
+	accounts business.AccountService
+	balances business.BalanceService
+}
+
+func NewAccountServer(accounts business.AccountService, balances business.BalanceService) pb.AccountServiceServer {
+	return &accountServerImpl{accounts: accounts, balances: balances}
+}
+
+func (a *accountServerImpl) Get(ctx context.Context, req *pb.GetAccountRequest) (*pb.AccountInformation, error) {
+	err := allowAccount(ctx, req.AccId, auth.RoleSupport)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := a.accounts.GetAccount(req.AccId)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return account, nil
+}
+
+func (a *accountServerImpl) GetBalance(ctx context.Context, req *pb.GetBalanceRequest) (*pb.BalanceInformation, error) {
+	err := allow(ctx, auth.RoleSupport)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := a.balances.GetBalance(req.AccountId)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return balance, nil
+}
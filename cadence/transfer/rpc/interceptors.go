@@ -0,0 +1,138 @@
+package rpc
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// credentials reads a bearer token from the authorization metadata and
+// an API key from x-api-key.
+func credentials(ctx context.Context) auth.Credentials {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	credentials := auth.Credentials{APIKey: first(md, "x-api-key")}
+
+	header := first(md, "authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		credentials.Bearer = strings.TrimSpace(header[7:])
+	}
+
+	return credentials
+}
+
+func first(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func authenticate(ctx context.Context, authenticator auth.Authenticator) (context.Context, error) {
+	principal, err := authenticator.Authenticate(ctx, credentials(ctx))
+	if err != nil {
+		return ctx, statusError(business.Errorf(business.CodeUnauthenticated, "%w", err))
+	}
+
+	return auth.WithPrincipal(ctx, principal), nil
+}
+
+func authUnary(authenticator auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, authenticator)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func authStream(authenticator auth.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), authenticator)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// serverStream is a stream served with another context.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func loggingUnary(logger *zap.SugaredLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logCall(logger, info.FullMethod, start, err)
+
+		return resp, err
+	}
+}
+
+func loggingStream(logger *zap.SugaredLogger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logCall(logger, info.FullMethod, start, err)
+
+		return err
+	}
+}
+
+func logCall(logger *zap.SugaredLogger, method string, start time.Time, err error) {
+	code := status.Code(err)
+
+	if code == codes.OK || code == codes.Canceled {
+		logger.Infow("gRPC call", "method", method, "code", code.String(), "duration", time.Since(start))
+		return
+	}
+
+	logger.Warnw("gRPC call failed", "method", method, "code", code.String(), "duration", time.Since(start), "err", err)
+}
+
+func metricsUnary(scope tally.Scope) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		measureCall(scope, info.FullMethod, start, err)
+
+		return resp, err
+	}
+}
+
+func metricsStream(scope tally.Scope) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		measureCall(scope, info.FullMethod, start, err)
+
+		return err
+	}
+}
+
+func measureCall(scope tally.Scope, method string, start time.Time, err error) {
+	tagged := scope.Tagged(map[string]string{"method": method})
+	tagged.Timer("grpc_latency").Record(time.Since(start))
+	tagged.Tagged(map[string]string{"code": status.Code(err).String()}).Counter("grpc_calls").Inc(1)
+}
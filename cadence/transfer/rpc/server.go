@@ -0,0 +1,156 @@
+package rpc
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"avenuesec/workflow-poc/cadence/transfer/resilience"
+	"avenuesec/workflow-poc/cadence/transfer/validation"
+	"context"
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// NewServer serves the transfer and account gRPC services from the same
+// business layer as the HTTP API. Calls are measured, logged and
+// authenticated.
+func NewServer(transfers business.SdToBankService, search business.TransferSearch, events business.TransferEvents, accounts business.AccountService, balances business.BalanceService, scope tally.Scope, authenticator auth.Authenticator) *grpc.Server {
+	logger, _ := zap.NewProduction()
+	logger = logger.Named("grpc")
+
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(metricsUnary(scope), loggingUnary(logger.Sugar()), authUnary(authenticator)),
+		grpc.ChainStreamInterceptor(metricsStream(scope), loggingStream(logger.Sugar()), authStream(authenticator)),
+	)
+
+	pb.RegisterTransferServiceServer(server, NewTransferServer(transfers, search, events))
+	pb.RegisterAccountServiceServer(server, NewAccountServer(accounts, balances))
+
+	return server
+}
+
+// errorDomain is the ErrorInfo domain of the error codes.
+const errorDomain = "transfer.avenuesec"
+
+var (
+	errUnauthenticated = business.NewError(business.CodeUnauthenticated, "unauthenticated")
+	errForbidden       = business.NewError(business.CodeForbidden, "forbidden")
+)
+
+// codeStatus is the gRPC code of each error code. Codes missing here are
+// Internal.
+var codeStatus = map[business.ErrorCode]codes.Code{
+	business.CodeInvalidRequest:       codes.InvalidArgument,
+	business.CodeUnauthenticated:      codes.Unauthenticated,
+	business.CodeForbidden:            codes.PermissionDenied,
+	business.CodeTransferNotFound:     codes.NotFound,
+	business.CodeAccountNotFound:      codes.NotFound,
+	business.CodePaymentNotFound:      codes.NotFound,
+	business.CodeHoldNotFound:         codes.NotFound,
+	business.CodeTransferState:        codes.FailedPrecondition,
+	business.CodeNotCancelable:        codes.FailedPrecondition,
+	business.CodeIdempotencyKeyReused: codes.FailedPrecondition,
+	business.CodeInsufficientFunds:    codes.FailedPrecondition,
+	business.CodeLimitExceeded:        codes.FailedPrecondition,
+	business.CodeNoProvider:           codes.FailedPrecondition,
+	business.CodeUnsupported:          codes.Unimplemented,
+	business.CodeProviderUnavailable:  codes.Unavailable,
+	business.CodeTimeout:              codes.DeadlineExceeded,
+}
+
+// statusError turns a business error into a gRPC status. Its error code
+// comes as the reason of an ErrorInfo detail, the broken fields of an
+// invalid request as BadRequest and the wait of a provider failure as
+// RetryInfo.
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return status.Error(codes.Canceled, err.Error())
+	}
+
+	code := business.CodeOf(err)
+
+	grpcCode, ok := codeStatus[code]
+	if !ok {
+		grpcCode = codes.Internal
+	}
+
+	details := []proto.Message{&errdetails.ErrorInfo{Reason: string(code), Domain: errorDomain}}
+
+	var errs validation.Errors
+	if errors.As(err, &errs) {
+		violations := &errdetails.BadRequest{}
+		for _, err := range errs {
+			violations.FieldViolations = append(violations.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       err.Field,
+				Description: err.Rule + ": " + err.Message,
+			})
+		}
+
+		details = append(details, violations)
+	}
+
+	if unavailable, ok := resilience.IsUnavailable(err); ok {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(unavailable.RetryAfter)})
+	}
+
+	st, detailsErr := status.New(grpcCode, err.Error()).WithDetails(details...)
+	if detailsErr != nil {
+		return status.Error(grpcCode, err.Error())
+	}
+
+	return st.Err()
+}
+
+// validate returns InvalidArgument with the field errors of an invalid
+// message.
+func validate(message proto.Message) error {
+	return statusError(validation.Validate(message))
+}
+
+// readAnyAccount are the roles that may see the transfers of any account.
+var readAnyAccount = []auth.Role{auth.RoleSupport, auth.RoleApprover}
+
+// allow denies the call unless the caller has one of the roles.
+func allow(ctx context.Context, roles ...auth.Role) error {
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return statusError(errUnauthenticated)
+	}
+
+	if !principal.HasRole(roles...) {
+		return statusError(errForbidden)
+	}
+
+	return nil
+}
+
+// allowAccount denies the call unless the caller may act for the account
+// or has one of the roles.
+func allowAccount(ctx context.Context, accID string, roles ...auth.Role) error {
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return statusError(errUnauthenticated)
+	}
+
+	if !principal.CanAccess(accID) && !principal.HasRole(roles...) {
+		return statusError(errForbidden)
+	}
+
+	return nil
+}
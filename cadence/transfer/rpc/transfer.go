@@ -0,0 +1,249 @@
+package rpc
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/auth"
+	"avenuesec/workflow-poc/cadence/transfer/broker"
+	"avenuesec/workflow-poc/cadence/transfer/business"
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// watchWait is how long WatchStatus waits for an event before looking
+	// at the stream again.
+	watchWait = 15 * time.Second
+	// idempotencyKeyMetadata makes creating a transfer safe to retry.
+	idempotencyKeyMetadata = "idempotency-key"
+)
+
+type transferServerImpl struct {
+	pb.UnimplementedTransferServiceServer
+	service business.SdToBankService
+	search  business.TransferSearch
+	events  business.TransferEvents
+}
+
+func NewTransferServer(service business.SdToBankService, search business.TransferSearch, events business.TransferEvents) pb.TransferServiceServer {
+	return &transferServerImpl{service: service, search: search, events: events}
+}
+
+func (t *transferServerImpl) Create(ctx context.Context, msg *pb.NewTransferMessage) (*pb.Transfer, error) {
+	if err := validate(msg); err != nil {
+		return nil, err
+	}
+
+	if err := allowAccount(ctx, msg.AccId); err != nil {
+		return nil, err
+	}
+
+	executionID := business.NewSdToBankExecutionID()
+	key := idempotencyKey(ctx)
+
+	// Calls with an idempotency key may be sent again, as HTTP requests
+	// may: the transfer the key started is answered, or an error when the
+	// call differs.
+	if key != "" {
+		principal, _ := auth.FromContext(ctx)
+		executionID = business.IdempotentExecutionID(principal.Subject, key)
+
+		transfer, err := t.service.GetTransferInformation(ctx, executionID)
+		if err == nil {
+			return t.replay(msg, transfer)
+		}
+
+		if !errors.Is(err, business.ErrTransferNotFound) {
+			return nil, statusError(err)
+		}
+	}
+
+	ctx = broker.WithCorrelationID(ctx, executionID)
+
+	executionID, err := t.service.StartTransfer(ctx, msg)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	transfer, err := t.get(ctx, executionID)
+	if err != nil || key == "" {
+		return transfer, err
+	}
+
+	// A concurrent call with the same key may have stored its transfer
+	// first.
+	return t.replay(msg, transfer)
+}
+
+// replay answers a call repeating an idempotency key with the transfer the
+// key started.
+func (t *transferServerImpl) replay(msg *pb.NewTransferMessage, transfer *pb.Transfer) (*pb.Transfer, error) {
+	if !business.SameTransfer(msg, transfer) {
+		return nil, statusError(business.ErrIdempotencyKeyReused)
+	}
+
+	return transfer, nil
+}
+
+// idempotencyKey reads the idempotency-key metadata of a call.
+func idempotencyKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	return first(md, idempotencyKeyMetadata)
+}
+
+func (t *transferServerImpl) Get(ctx context.Context, req *pb.GetTransferRequest) (*pb.Transfer, error) {
+	return t.transfer(ctx, req.ExecutionId, readAnyAccount...)
+}
+
+func (t *transferServerImpl) List(ctx context.Context, req *pb.ListTransfersRequest) (*pb.ListTransfersResponse, error) {
+	filter := business.TransferFilter{
+		AccID:     req.AccId,
+		Status:    req.Status,
+		Direction: req.Direction,
+		MinAmount: req.MinAmount,
+		MaxAmount: req.MaxAmount,
+	}
+
+	err := allowAccount(ctx, filter.AccID, readAnyAccount...)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.CreatedFrom != "" {
+		filter.From, err = time.Parse(time.RFC3339, req.CreatedFrom)
+		if err != nil {
+			return nil, statusError(business.Errorf(business.CodeInvalidRequest, "created_from: %w", err))
+		}
+	}
+
+	if req.CreatedTo != "" {
+		filter.To, err = time.Parse(time.RFC3339, req.CreatedTo)
+		if err != nil {
+			return nil, statusError(business.Errorf(business.CodeInvalidRequest, "created_to: %w", err))
+		}
+	}
+
+	page, err := t.search.List(ctx, filter, req.Cursor, int(req.Limit))
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	resp := &pb.ListTransfersResponse{NextCursor: page.NextCursor}
+
+	for _, transfer := range page.Transfers {
+		resp.Transfers = append(resp.Transfers, &pb.TransferSummary{
+			ExecutionId: transfer.ExecutionID,
+			AccId:       transfer.AccID,
+			Status:      transfer.Status,
+			Direction:   pb.Direction(pb.Direction_value[transfer.Direction]),
+			Amount:      transfer.Amount,
+			CreatedAt:   transfer.CreatedAt.Format(time.RFC3339Nano),
+		})
+	}
+
+	return resp, nil
+}
+
+func (t *transferServerImpl) Cancel(ctx context.Context, req *pb.CancelTransferRequest) (*pb.Transfer, error) {
+	_, err := t.transfer(ctx, req.ExecutionId, auth.RoleApprover)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.service.Cancel(ctx, req.ExecutionId, req.Reason)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return t.get(ctx, req.ExecutionId)
+}
+
+func (t *transferServerImpl) Approve(ctx context.Context, req *pb.ApproveTransferRequest) (*pb.Transfer, error) {
+	err := allow(ctx, auth.RoleApprover)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.service.Approve(ctx, req.ExecutionId)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return t.get(ctx, req.ExecutionId)
+}
+
+// WatchStatus sends the status changes of the transfer after
+// last_event_id until it is done.
+func (t *transferServerImpl) WatchStatus(req *pb.WatchStatusRequest, stream pb.TransferService_WatchStatusServer) error {
+	ctx := stream.Context()
+
+	transfer, err := t.transfer(ctx, req.ExecutionId, readAnyAccount...)
+	if err != nil {
+		return err
+	}
+
+	lastID := req.LastEventId
+	final := business.IsFinalTransferStatus(transfer.Status)
+
+	for {
+		events, err := t.events.Read(ctx, req.ExecutionId, lastID, watchWait)
+		if err != nil {
+			return statusError(err)
+		}
+
+		// A finished transfer has nothing left to send.
+		if len(events) == 0 && final {
+			return nil
+		}
+
+		for _, event := range events {
+			err = stream.Send(&pb.TransferStatusEvent{
+				Id:          event.ID,
+				ExecutionId: event.ExecutionID,
+				Status:      event.Status,
+				At:          event.At.Format(time.RFC3339Nano),
+			})
+			if err != nil {
+				return err
+			}
+
+			lastID = event.ID
+
+			if business.IsFinalTransferStatus(event.Status) {
+				return nil
+			}
+		}
+
+		if ctx.Err() != nil {
+			return statusError(ctx.Err())
+		}
+	}
+}
+
+func (t *transferServerImpl) get(ctx context.Context, executionID string) (*pb.Transfer, error) {
+	transfer, err := t.service.GetTransferInformation(ctx, executionID)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return transfer, nil
+}
+
+// transfer gets a transfer the caller may act for or, with one of the
+// roles, any transfer.
+func (t *transferServerImpl) transfer(ctx context.Context, executionID string, roles ...auth.Role) (*pb.Transfer, error) {
+	transfer, err := t.get(ctx, executionID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = allowAccount(ctx, transfer.AccId, roles...)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
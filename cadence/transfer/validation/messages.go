@@ -0,0 +1,56 @@
+package validation
+
+import (
+	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	MinTransferAmount = 0.01
+	MaxTransferAmount = 250000
+	// TransferAmountDecimals are the cents of a USD amount.
+	TransferAmountDecimals = 2
+
+	// sdToBankExecutionPrefix starts the execution ids
+	// business.NewSdToBankExecutionID makes.
+	sdToBankExecutionPrefix = "sdtobank_"
+)
+
+// rules are the fields each message type is checked on.
+var rules = map[string]func(message proto.Message) []Field{
+	proto.MessageName(&pb.NewTransferMessage{}): func(message proto.Message) []Field {
+		m := message.(*pb.NewTransferMessage)
+
+		return []Field{
+			amount(m.GetAmount()),
+			String("acc_id", m.GetAccId(), Required(), UUID()),
+			Enum("direction", int32(m.GetDirection()), pb.Direction_name),
+		}
+	},
+	proto.MessageName(&pb.Transfer{}): func(message proto.Message) []Field {
+		m := message.(*pb.Transfer)
+
+		return []Field{
+			String("execution_id", m.GetExecutionId(), Required(), PrefixedUUID(sdToBankExecutionPrefix)),
+			amount(m.GetAmount()),
+			String("acc_id", m.GetAccId(), Required(), UUID()),
+			Enum("direction", int32(m.GetDirection()), pb.Direction_name),
+		}
+	},
+}
+
+func amount(value float64) Field {
+	return Float("amount", value, Range(MinTransferAmount, MaxTransferAmount), Decimals(TransferAmountDecimals))
+}
+
+// Validate checks a message against the rules of its type, returning
+// Errors when it breaks any. Types without rules are always valid.
+func Validate(message proto.Message) error {
+	fields, ok := rules[proto.MessageName(message)]
+	if !ok {
+		return nil
+	}
+
+	return Check(fields(message)...)
+}
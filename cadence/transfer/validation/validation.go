@@ -0,0 +1,151 @@
+package validation
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// FieldError is why one field of a message is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors are the field errors of an invalid message, at most one per field.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, err := range e {
+		parts[i] = fmt.Sprintf("%s: %s", err.Field, err.Message)
+	}
+
+	return "invalid " + strings.Join(parts, ", ")
+}
+
+// Field is a named value and the rules it must pass.
+type Field struct {
+	name  string
+	check func() (rule, message string)
+}
+
+// FloatRule returns the rule a value breaks and why, or an empty rule.
+type FloatRule func(value float64) (rule, message string)
+
+// StringRule returns the rule a value breaks and why, or an empty rule.
+type StringRule func(value string) (rule, message string)
+
+// Check runs the rules of every field. Each field stops at its first broken
+// rule.
+func Check(fields ...Field) error {
+	var errs Errors
+
+	for _, field := range fields {
+		if rule, message := field.check(); rule != "" {
+			errs = append(errs, FieldError{Field: field.name, Rule: rule, Message: message})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func Float(name string, value float64, rules ...FloatRule) Field {
+	return Field{name: name, check: func() (string, string) {
+		for _, rule := range rules {
+			if name, message := rule(value); name != "" {
+				return name, message
+			}
+		}
+
+		return "", ""
+	}}
+}
+
+func String(name string, value string, rules ...StringRule) Field {
+	return Field{name: name, check: func() (string, string) {
+		for _, rule := range rules {
+			if name, message := rule(value); name != "" {
+				return name, message
+			}
+		}
+
+		return "", ""
+	}}
+}
+
+// Enum checks a proto enum value against the names generated for it.
+func Enum(name string, value int32, names map[int32]string) Field {
+	return Field{name: name, check: func() (string, string) {
+		if _, ok := names[value]; !ok {
+			return "enum", fmt.Sprintf("%d is not a known value", value)
+		}
+
+		return "", ""
+	}}
+}
+
+// Range takes finite values between min and max, both included.
+func Range(min, max float64) FloatRule {
+	return func(value float64) (string, string) {
+		if math.IsNaN(value) || math.IsInf(value, 0) || value < min || value > max {
+			return "range", fmt.Sprintf("must be between %v and %v", min, max)
+		}
+
+		return "", ""
+	}
+}
+
+// Decimals takes values with at most places decimal places.
+func Decimals(places int) FloatRule {
+	scale := math.Pow10(places)
+
+	return func(value float64) (string, string) {
+		scaled := value * scale
+
+		// Most decimal fractions have no exact float, so allow for the
+		// rounding of the scaling.
+		if math.Abs(scaled-math.Round(scaled)) > 1e-9*math.Max(1, math.Abs(scaled)) {
+			return "decimals", fmt.Sprintf("must have at most %d decimal places", places)
+		}
+
+		return "", ""
+	}
+}
+
+func Required() StringRule {
+	return func(value string) (string, string) {
+		if strings.TrimSpace(value) == "" {
+			return "required", "is required"
+		}
+
+		return "", ""
+	}
+}
+
+func UUID() StringRule {
+	return PrefixedUUID("")
+}
+
+// PrefixedUUID takes a UUID after the prefix, as execution ids are.
+func PrefixedUUID(prefix string) StringRule {
+	return func(value string) (string, string) {
+		if strings.HasPrefix(value, prefix) && uuidPattern.MatchString(value[len(prefix):]) {
+			return "", ""
+		}
+
+		if prefix == "" {
+			return "format", "must be a UUID"
+		}
+
+		return "format", fmt.Sprintf("must be %s followed by a UUID", prefix)
+	}
+}
@@ -0,0 +1,79 @@
+package workflow
+
+import (
+	"avenuesec/workflow-poc/cadence/transfer/reconciliation"
+	"context"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+type ReconciliationWorkflow struct {
+	reconciler reconciliation.Reconciler
+}
+
+func NewReconciliationWorkflow(reconciler reconciliation.Reconciler) ReconciliationWorkflow {
+	return ReconciliationWorkflow{reconciler: reconciler}
+}
+
+// ReconciliationWorkflow reconciles every settlement file waiting in the
+// inbox. It runs on a cron schedule; a file that fails stays in the inbox
+// for the next run.
+func (r *ReconciliationWorkflow) ReconciliationWorkflow(ctx workflow.Context) error {
+	logger := workflow.GetLogger(ctx).Sugar()
+
+	ao := workflow.ActivityOptions{
+		ScheduleToStartTimeout: time.Minute,
+		StartToCloseTimeout:    10 * time.Minute,
+		RetryPolicy: &cadence.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    3,
+		},
+	}
+
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	var files []string
+	err := workflow.ExecuteActivity(ctx, r.PendingSettlements).Get(ctx, &files)
+	if err != nil {
+		return err
+	}
+
+	var failed error
+
+	for _, file := range files {
+		var summary reconciliation.Summary
+
+		err = workflow.ExecuteActivity(ctx, r.ReconcileSettlement, file).Get(ctx, &summary)
+		if err != nil {
+			logger.Error("Settlement reconciliation failed.", zap.String("file", file), zap.Error(err))
+			failed = err
+			continue
+		}
+
+		logger.Info("Settlement reconciled.",
+			zap.String("report", summary.ID),
+			zap.String("date", summary.Date),
+			zap.Int("matched", summary.Matched),
+			zap.Int("mismatches", summary.Mismatches))
+	}
+
+	return failed
+}
+
+func (r *ReconciliationWorkflow) PendingSettlements(ctx context.Context) ([]string, error) {
+	return r.reconciler.Pending()
+}
+
+func (r *ReconciliationWorkflow) ReconcileSettlement(ctx context.Context, path string) (reconciliation.Summary, error) {
+	report, err := r.reconciler.Reconcile(ctx, path)
+	if err != nil {
+		return reconciliation.Summary{}, err
+	}
+
+	return report.Summary(), nil
+}
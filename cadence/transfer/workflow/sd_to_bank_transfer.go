@@ -1,12 +1,15 @@
 package workflow
 
 import (
+	"avenuesec/workflow-poc/cadence/transfer/ach"
+	"avenuesec/workflow-poc/cadence/transfer/broker"
 	"avenuesec/workflow-poc/cadence/transfer/business"
 	pb "avenuesec/workflow-poc/cadence/transfer/common/protogen"
-	"avenuesec/workflow-poc/cadence/transfer/rabbitmq"
-	"fmt"
-
+	"avenuesec/workflow-poc/cadence/transfer/iso20022"
+	"avenuesec/workflow-poc/cadence/transfer/resilience"
+	"avenuesec/workflow-poc/cadence/transfer/validation"
 	"context"
+	"errors"
 	"time"
 
 	"go.uber.org/cadence"
@@ -14,20 +17,56 @@ import (
 	"go.uber.org/zap"
 )
 
+// entryAckTimeout is how long the decider waits for MoneyBin to
+// acknowledge a ledger entry.
+const entryAckTimeout = 5 * time.Minute
+
+// timeoutMargin is how long before Cadence would time the workflow out it
+// gives up by itself, so the transfer gets a final status. It covers the
+// longest step, acks and provider backoff included.
+const timeoutMargin = 30 * time.Minute
+
+// The decider backs off on activities failing with a provider unavailable
+// error, from providerBackoff doubling up to providerBackoffMax, and gives
+// up after providerBackoffBudget. business.SdToBankExecutionTimeout leaves
+// room for it.
+const (
+	providerBackoff       = 2 * time.Second
+	providerBackoffMax    = time.Minute
+	providerBackoffBudget = 5 * time.Minute
+)
+
 type SdToBankWorkflow struct {
 	service business.SdToBankService
 	balance business.BalanceService
 	account business.AccountService
-	rabbit  rabbitmq.AmqpConnection
-	logger  *zap.SugaredLogger
+	broker  broker.Broker
+	credits ach.Originator
+	// bankAccounts tells which credits go out by ACH and which by pain.001
+	// through payments.
+	bankAccounts ach.BankAccounts
+	payments     iso20022.Initiator
+	// providers routes each withdrawal to the partner paying it out.
+	providers business.ProviderRegistry
+	breakers  resilience.Group
+	// searchAttributes is set when the cluster has advanced visibility and
+	// the transfer search attributes.
+	searchAttributes bool
+	logger           *zap.SugaredLogger
 }
 
-func NewSdToBankWorkflow(service business.SdToBankService, balance business.BalanceService, account business.AccountService, rabbit rabbitmq.AmqpConnection) SdToBankWorkflow {
+func NewSdToBankWorkflow(service business.SdToBankService, balance business.BalanceService, account business.AccountService, broker broker.Broker, credits ach.Originator, bankAccounts ach.BankAccounts, payments iso20022.Initiator, providers business.ProviderRegistry, breakers resilience.Group, searchAttributes bool) SdToBankWorkflow {
 	return SdToBankWorkflow{
-		service: service,
-		account: account,
-		balance: balance,
-		rabbit:  rabbit,
+		service:          service,
+		account:          account,
+		balance:          balance,
+		broker:           broker,
+		credits:          credits,
+		bankAccounts:     bankAccounts,
+		payments:         payments,
+		providers:        providers,
+		breakers:         breakers,
+		searchAttributes: searchAttributes,
 	}
 }
 
@@ -45,44 +84,181 @@ func (s *SdToBankWorkflow) SdToBankWorkflow(ctx workflow.Context) error {
 	ctx = workflow.WithActivityOptions(ctx, ao)
 
 	ch := workflow.GetSignalChannel(ctx, business.SdToBankSignalName)
+	// transfer is loaded once, by the first signal, and every step works
+	// on it.
+	var transfer *pb.Transfer
+	// next is a trigger the workflow gives itself, handled before waiting
+	// for another signal.
+	var next business.SignalTrigger
+	deadline := workflow.NewTimer(ctx, giveUpAfter(ctx))
+
 	for {
 		var signal business.SignalTrigger
 		var result string
 		var err error
 
-		if more := ch.Receive(ctx, &signal); !more {
-			s.logger.Info("SdToBank channel closed")
-			return cadence.NewCustomError("sd_to_bank_channel_closed")
+		if next != "" {
+			signal, next = next, ""
+		} else {
+			more := true
+			timedOut := false
+
+			selector := workflow.NewSelector(ctx)
+			selector.AddReceive(ch, func(c workflow.Channel, ok bool) {
+				more = c.Receive(ctx, &signal)
+			})
+			selector.AddFuture(deadline, func(f workflow.Future) {
+				timedOut = true
+			})
+			selector.Select(ctx)
+
+			if timedOut {
+				s.logger.Error("SdToBankWorkflow timed out.")
+				s.setStatus(ctx, business.TransferStatusFailed)
+				return cadence.NewCustomError("transfer_timed_out")
+			}
+
+			if !more {
+				s.logger.Info("SdToBank channel closed")
+				return cadence.NewCustomError("sd_to_bank_channel_closed")
+			}
 		}
 
 		s.logger.Info("Signal received.", zap.String("signal", string(signal)))
 
-		executionID := workflow.GetInfo(ctx).WorkflowExecution.ID
-		transfer, err := s.service.GetTransferInformation(context.Background(), executionID)
-		if err != nil {
-			s.logger.Error("SdToBankWorkflow failed to get transfer message.", zap.Error(err))
-			return err
+		if transfer == nil {
+			// Producers validate transfers too, but whatever got stored is
+			// checked before any funds move. Loading it reads Redis, so it
+			// runs as an activity and replays from its recorded result.
+			executionID := workflow.GetInfo(ctx).WorkflowExecution.ID
+
+			var checked pb.Transfer
+			err = workflow.ExecuteActivity(ctx, s.CheckTransfer, executionID).Get(ctx, &checked)
+			if err != nil {
+				s.logger.Error("SdToBankWorkflow got an invalid transfer.", zap.Error(err))
+				s.setStatus(ctx, business.TransferStatusFailed)
+				return err
+			}
+
+			transfer = &checked
+
+			s.upsertSearchAttributes(ctx, map[string]interface{}{
+				business.SearchAttributeAccountID: transfer.AccId,
+				business.SearchAttributeAmount:    transfer.Amount,
+				business.SearchAttributeDirection: transfer.Direction.String(),
+				business.SearchAttributeStatus:    business.TransferStatusStarting,
+			})
 		}
 
+		var status string
+
 		switch signal {
 		case business.SdToBankSignalStartValidate:
 			err = workflow.ExecuteActivity(ctx, s.Validate, transfer).Get(ctx, &result)
+			status = business.TransferStatusValidated
 		case business.SdToBankSignalStartBlock:
-			err = workflow.ExecuteActivity(ctx, s.BlockAndJournal, transfer).Get(ctx, &result)
+			err = s.blockAndJournal(ctx, transfer)
+			status = business.TransferStatusBlocked
 		case business.SdToBankSignalStartUnblockDebit:
-			err = workflow.ExecuteActivity(ctx, s.UnblockDebit, transfer).Get(ctx, &result)
+			err = s.postEntries(ctx, transfer, pb.EntryKind_Unblock, pb.EntryKind_Debit)
+			status = business.TransferStatusDebited
+			// The bank credit follows the debit without waiting for anyone.
+			next = business.SdToBankSignalStartCredit
 		case business.SdToBankSignalStartCredit:
 			err = workflow.ExecuteActivity(ctx, s.Credit, transfer).Get(ctx, &result)
+			status = business.TransferStatusCreditQueued
 		case business.SdToBankSignalDone:
 			s.logger.Info("SdToBankWorkflow completed.", zap.String("Result", result))
-			return nil
+			return s.setStatus(ctx, business.TransferStatusCompleted)
+		case business.SdToBankSignalBankReturned:
+			s.logger.Error("SdToBankWorkflow payment returned by the bank.")
+			s.setStatus(ctx, business.TransferStatusReturned)
+			return cadence.NewCustomError("bank_returned")
+		case business.SdToBankSignalCancel:
+			s.logger.Info("SdToBankWorkflow canceled.")
+			s.setStatus(ctx, business.TransferStatusCanceled)
+			return cadence.NewCustomError("transfer_canceled")
+		case business.SdToBankSignalWithdrawCanceled:
+			s.logger.Error("SdToBankWorkflow withdrawal canceled by the provider.")
+			err = s.postEntries(ctx, transfer, pb.EntryKind_Unblock)
+			if err == nil {
+				s.setStatus(ctx, business.TransferStatusFailed)
+				return cadence.NewCustomError("withdraw_canceled")
+			}
 		}
 
 		if err != nil {
 			s.logger.Error("SdToBankWorkflow failed.", zap.Error(err))
+			s.setStatus(ctx, business.TransferStatusFailed)
 			return err
 		}
+
+		if status != "" {
+			err = s.setStatus(ctx, status)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// giveUpAfter is how long the workflow waits for signals, timeoutMargin
+// short of its execution timeout.
+func giveUpAfter(ctx workflow.Context) time.Duration {
+	timeout := time.Duration(workflow.GetInfo(ctx).ExecutionStartToCloseTimeoutSeconds) * time.Second
+
+	margin := timeoutMargin
+	if margin > timeout/2 {
+		margin = timeout / 2
 	}
+
+	return timeout - margin
+}
+
+// setStatus records the transfer status with the transfer and in the
+// TransferStatus search attribute.
+func (s *SdToBankWorkflow) setStatus(ctx workflow.Context, status string) error {
+	s.upsertSearchAttributes(ctx, map[string]interface{}{business.SearchAttributeStatus: status})
+
+	executionID := workflow.GetInfo(ctx).WorkflowExecution.ID
+
+	err := workflow.ExecuteActivity(ctx, s.RecordStatus, executionID, status).Get(ctx, nil)
+	if err != nil {
+		s.logger.Error("SdToBankWorkflow failed to record status.", zap.String("status", status), zap.Error(err))
+	}
+
+	return err
+}
+
+func (s *SdToBankWorkflow) upsertSearchAttributes(ctx workflow.Context, attributes map[string]interface{}) {
+	if !s.searchAttributes {
+		return
+	}
+
+	err := workflow.UpsertSearchAttributes(ctx, attributes)
+	if err != nil {
+		s.logger.Warn("SdToBankWorkflow failed to upsert search attributes.", zap.Error(err))
+	}
+}
+
+func (s *SdToBankWorkflow) RecordStatus(ctx context.Context, executionID, status string) error {
+	return s.service.SetTransferStatus(ctx, executionID, status)
+}
+
+// CheckTransfer loads the stored transfer and fails with invalid_transfer
+// unless it passes validation.
+func (s *SdToBankWorkflow) CheckTransfer(ctx context.Context, executionID string) (*pb.Transfer, error) {
+	transfer, err := s.service.GetTransferInformation(ctx, executionID)
+	if err != nil {
+		return nil, business.ActivityError(err)
+	}
+
+	err = validation.Validate(transfer)
+	if err != nil {
+		return nil, cadence.NewCustomError("invalid_transfer", err.Error())
+	}
+
+	return transfer, nil
 }
 
 func (s *SdToBankWorkflow) Validate(ctx context.Context, msg *pb.Transfer) (string, error) {
@@ -91,7 +267,7 @@ func (s *SdToBankWorkflow) Validate(ctx context.Context, msg *pb.Transfer) (stri
 	accInfo, err := s.account.GetAccount(msg.AccId)
 	if err != nil {
 		s.logger.Errorw("Error getting account", "acc_id", msg.AccId, "err", err)
-		return "error_account", err
+		return "error_account", business.ActivityError(err)
 	}
 
 	fromAccId := accInfo.AccountUsId
@@ -104,7 +280,7 @@ func (s *SdToBankWorkflow) Validate(ctx context.Context, msg *pb.Transfer) (stri
 
 	if balance.Available < msg.Amount {
 		s.logger.Errorw("Balance is not enough", "required", msg.Amount, "available", balance.Available, "account id", balance.AccountId)
-		return "not_enough_balance", fmt.Errorf("not enough balance")
+		return "not_enough_balance", business.ActivityError(business.Errorf(business.CodeInsufficientFunds, "available balance %.2f is below the %.2f required", balance.Available, msg.Amount))
 	}
 
 	s.logger.Infow("Account has balance to perform operation", "account", balance.AccountId, "amount", msg.Amount)
@@ -112,39 +288,298 @@ func (s *SdToBankWorkflow) Validate(ctx context.Context, msg *pb.Transfer) (stri
 	return "has_balance", nil
 }
 
-func (s *SdToBankWorkflow) BlockAndJournal(ctx context.Context, msg *pb.Transfer) (string, error) {
+// executeWithBackoff runs an activity, waiting and running it again while
+// it fails because its provider is unavailable.
+func (s *SdToBankWorkflow) executeWithBackoff(ctx workflow.Context, result interface{}, activity interface{}, args ...interface{}) error {
+	backoff := providerBackoff
+	deadline := workflow.Now(ctx).Add(providerBackoffBudget)
+
+	for {
+		err := workflow.ExecuteActivity(ctx, activity, args...).Get(ctx, result)
+
+		retryAfter, ok := providerUnavailable(err)
+		if !ok {
+			return err
+		}
+
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+
+		if workflow.Now(ctx).Add(wait).After(deadline) {
+			return err
+		}
+
+		s.logger.Warn("Provider unavailable, backing off.", zap.Duration("wait", wait))
+
+		err = workflow.Sleep(ctx, wait)
+		if err != nil {
+			return err
+		}
+
+		backoff *= 2
+		if backoff > providerBackoffMax {
+			backoff = providerBackoffMax
+		}
+	}
+}
+
+// notSent tells whether a Journal error means no provider got the
+// withdrawal: none was routed to, or it was unavailable throughout.
+func notSent(err error) bool {
+	if _, ok := providerUnavailable(err); ok {
+		return true
+	}
+
+	customErr, ok := err.(*cadence.CustomError)
+
+	return ok && business.CodeOf(customErr) == business.CodeNoProvider
+}
+
+// providerUnavailable reads the wait out of the activity error of a
+// provider failure.
+func providerUnavailable(err error) (time.Duration, bool) {
+	customErr, ok := err.(*cadence.CustomError)
+	if !ok || business.CodeOf(customErr) != business.CodeProviderUnavailable {
+		return 0, false
+	}
+
+	var seconds float64
+	if customErr.HasDetails() {
+		customErr.Details(&seconds)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// blockAndJournal blocks the amount on the MoneyBin ledger and, once the
+// block is acknowledged, asks the provider for the withdrawal. The amount
+// is unblocked when the withdrawal surely never reached a provider; other
+// failures may have, and are left to operators.
+func (s *SdToBankWorkflow) blockAndJournal(ctx workflow.Context, transfer *pb.Transfer) error {
+	var result string
+
+	err := s.executeWithBackoff(ctx, &result, s.Block, transfer)
+	if err != nil {
+		return err
+	}
+
+	_, err = AwaitEntryAck(ctx, pb.EntryKind_Block, entryAckTimeout)
+	if err != nil {
+		return err
+	}
+
+	err = s.executeWithBackoff(ctx, &result, s.Journal, transfer)
+	if notSent(err) {
+		s.logger.Error("SdToBankWorkflow failed to request the withdrawal, unblocking.", zap.Error(err))
+
+		unblockErr := s.postEntries(ctx, transfer, pb.EntryKind_Unblock)
+		if unblockErr != nil {
+			s.logger.Error("SdToBankWorkflow failed to unblock.", zap.Error(unblockErr))
+		}
+	}
+
+	return err
+}
+
+// postEntries posts ledger entries one after the other, each once the
+// previous one is acknowledged.
+func (s *SdToBankWorkflow) postEntries(ctx workflow.Context, transfer *pb.Transfer, kinds ...pb.EntryKind) error {
+	for _, kind := range kinds {
+		var result string
+
+		err := workflow.ExecuteActivity(ctx, s.PostEntry, transfer, kind).Get(ctx, &result)
+		if err != nil {
+			return err
+		}
+
+		_, err = AwaitEntryAck(ctx, kind, entryAckTimeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Block posts the ledger entry blocking the amount. Nothing is blocked
+// while the provider of the transfer is down, so the activity can be run
+// again once it is back.
+func (s *SdToBankWorkflow) Block(ctx context.Context, msg *pb.Transfer) (string, error) {
 	s.logger.Info("Blocking Transfer request")
 
-	accInfo, err := s.account.GetAccount(msg.AccId)
+	provider, err := s.route(msg)
 	if err != nil {
-		s.logger.Errorw("Error getting account", "acc_id", msg.AccId, "err", err)
-		return "error_account", err
+		return "error_route", business.ActivityError(err)
 	}
 
-	err = s.balance.UpdateBalace(accInfo.AccountUsId, msg.Amount)
+	err = s.breakers.Check(ctx, provider.Name())
+	if unavailable, ok := resilience.IsUnavailable(err); ok {
+		s.logger.Warnw("Provider unavailable", "provider", provider.Name(), "retry_after", unavailable.RetryAfter)
+		return "provider_unavailable", business.ActivityError(unavailable)
+	}
+
+	return s.PostEntry(ctx, msg, pb.EntryKind_Block)
+}
+
+// Journal asks the provider the transfer routes to for the withdrawal of
+// the blocked amount. Providers take the execution id as idempotency key,
+// so the activity can be run again.
+func (s *SdToBankWorkflow) Journal(ctx context.Context, msg *pb.Transfer) (string, error) {
+	provider, err := s.route(msg)
+	if err != nil {
+		return "error_route", business.ActivityError(err)
+	}
+
+	accInfo, err := s.account.GetAccount(msg.AccId)
 	if err != nil {
-		return "error_update_balance", err
+		s.logger.Errorw("Error getting account", "acc_id", msg.AccId, "err", err)
+		return "error_account", business.ActivityError(err)
 	}
 
-	journal := &pb.ApexWithdrawMessage{
+	result, err := provider.Withdraw(ctx, business.PaymentRequest{
+		ExecutionID: msg.ExecutionId,
+		AccountID:   accInfo.AccountUsId,
 		Amount:      msg.Amount,
-		ExecutionId: msg.ExecutionId,
+		Currency:    business.CurrencyUSD,
 		Direction:   msg.Direction,
-		ApexAccId:   "",
+	})
+	if unavailable, ok := resilience.IsUnavailable(err); ok {
+		s.logger.Warnw("Provider unavailable", "provider", provider.Name(), "retry_after", unavailable.RetryAfter)
+		return "provider_unavailable", business.ActivityError(unavailable)
 	}
 
-	err = s.rabbit.ProduceStruct(ctx, journal)
 	if err != nil {
-		return "error_sending_journal", err
+		s.logger.Errorw("Error requesting withdraw", "execution_id", msg.ExecutionId, "provider", provider.Name(), "err", err)
+		return "error_sending_journal", business.ActivityError(err)
 	}
 
+	s.logger.Infow("Withdraw requested", "execution_id", msg.ExecutionId, "provider", provider.Name(), "status", result.ProviderStatus)
+
 	return "value_blocked", nil
 }
 
-func (s *SdToBankWorkflow) UnblockDebit(ctx context.Context, msg *pb.Transfer) (string, error) {
-	return "", nil
+// route picks the provider withdrawing the transfer. Transfers are in USD.
+func (s *SdToBankWorkflow) route(msg *pb.Transfer) (business.Provider, error) {
+	return s.providers.Route(msg.Direction, business.CurrencyUSD, business.CapabilityWithdraw)
+}
+
+// PostEntry sends a ledger entry of the transfer to MoneyBin, which
+// acknowledges it on the entry ack signal. MoneyBin posts each kind once
+// per transfer, so the activity can be run again.
+func (s *SdToBankWorkflow) PostEntry(ctx context.Context, msg *pb.Transfer, kind pb.EntryKind) (string, error) {
+	accInfo, err := s.account.GetAccount(msg.AccId)
+	if err != nil {
+		s.logger.Errorw("Error getting account", "acc_id", msg.AccId, "err", err)
+		return "error_account", business.ActivityError(err)
+	}
+
+	err = broker.ProduceStruct(ctx, s.broker, &pb.AddEntry{
+		Amount:      msg.Amount,
+		AccId:       accInfo.AccountUsId,
+		Kind:        kind,
+		ExecutionId: msg.ExecutionId,
+	})
+	if err != nil {
+		s.logger.Errorw("Error posting entry", "execution_id", msg.ExecutionId, "kind", kind, "err", err)
+		return "error_posting_entry", err
+	}
+
+	return "entry_posted", nil
 }
 
+// Credit queues the bank credit for the next ACH file, or writes its
+// pain.001 when the bank account is outside the US.
 func (s *SdToBankWorkflow) Credit(ctx context.Context, msg *pb.Transfer) (string, error) {
-	return "", nil
+	accInfo, err := s.account.GetAccount(msg.AccId)
+	if err != nil {
+		s.logger.Errorw("Error getting account", "acc_id", msg.AccId, "err", err)
+		return "error_account", business.ActivityError(err)
+	}
+
+	bankAccount, err := s.bankAccounts.BankAccount(ctx, msg.AccId)
+	if err != nil {
+		s.logger.Errorw("Error getting bank account", "acc_id", msg.AccId, "err", err)
+		return "error_bank_account", err
+	}
+
+	if bankAccount.Foreign() {
+		return s.initiate(ctx, msg, bankAccount)
+	}
+
+	err = s.credits.QueueCredit(ctx, ach.CreditRequest{
+		ExecutionID:     msg.ExecutionId,
+		AccID:           msg.AccId,
+		LedgerAccountID: accInfo.AccountUsId,
+		Amount:          msg.Amount,
+	})
+	if errors.Is(err, ach.ErrDisabled) {
+		s.logger.Errorw("ACH credits are disabled", "execution_id", msg.ExecutionId)
+		return "ach_disabled", business.ActivityError(business.NewError(business.CodeUnsupported, err.Error()))
+	}
+
+	if err != nil {
+		s.logger.Errorw("Error queuing credit", "execution_id", msg.ExecutionId, "err", err)
+		return "error_queue_credit", err
+	}
+
+	return "credit_queued", nil
+}
+
+func (s *SdToBankWorkflow) initiate(ctx context.Context, msg *pb.Transfer, bankAccount *ach.BankAccount) (string, error) {
+	endToEndID, err := business.EndToEndID(msg.ExecutionId)
+	if err != nil {
+		return "error_end_to_end_id", err
+	}
+
+	path, err := s.payments.Initiate(ctx, iso20022.CreditTransferFromTransfer(msg, endToEndID, business.CurrencyUSD, iso20022.Account{
+		Name: bankAccount.Name,
+		IBAN: bankAccount.IBAN,
+		BIC:  bankAccount.BIC,
+	}))
+	if err != nil {
+		s.logger.Errorw("Error writing pain.001", "execution_id", msg.ExecutionId, "err", err)
+		return "error_initiate_credit", err
+	}
+
+	s.logger.Infow("Credit initiated", "execution_id", msg.ExecutionId, "end_to_end_id", endToEndID, "file", path)
+
+	return "credit_initiated", nil
+}
+
+// AwaitEntryAck blocks the workflow until MoneyBin acknowledges its entry of
+// the given kind. Acks for other kinds received meanwhile are discarded.
+func AwaitEntryAck(ctx workflow.Context, kind pb.EntryKind, timeout time.Duration) (*pb.EntryAck, error) {
+	ch := workflow.GetSignalChannel(ctx, business.SdToBankEntryAckSignalName)
+
+	timedOut := false
+	timer := workflow.NewTimer(ctx, timeout)
+
+	for {
+		var ack pb.EntryAck
+
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(ch, func(c workflow.Channel, more bool) {
+			c.Receive(ctx, &ack)
+		})
+		selector.AddFuture(timer, func(f workflow.Future) {
+			timedOut = true
+		})
+		selector.Select(ctx)
+
+		if timedOut {
+			return nil, cadence.NewCustomError("entry_ack_timeout", kind.String())
+		}
+
+		if ack.Kind != kind {
+			continue
+		}
+
+		if !ack.Accepted {
+			return &ack, cadence.NewCustomError("entry_rejected", ack.Reason)
+		}
+
+		return &ack, nil
+	}
 }